@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/migrate"
+	"my-platform/internal/payment/midtranstest"
+	"my-platform/internal/websocket"
+)
+
+const (
+	e2eServerKey = "SB-Mid-server-E2ETESTKEY"
+	e2eAdminKey  = "e2e-admin-key"
+)
+
+// e2e is the API under test, booted once by TestMain with newRouter, a
+// throwaway schema in TEST_DATABASE_DSN and a fake Midtrans. It stays nil
+// without a database, and the scenarios skip.
+var e2e *e2eEnv
+
+type e2eEnv struct {
+	server   *httptest.Server
+	midtrans *midtranstest.Server
+	mailDir  string
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		os.Exit(m.Run())
+	}
+
+	// The background workers started by newRouter cannot be stopped, so one
+	// server is shared by every scenario and torn down with the process.
+	env, cleanup, err := setupE2E(dsn)
+	if err != nil {
+		log.Fatal("e2e setup: ", err)
+	}
+	e2e = env
+	code := m.Run()
+	cleanup()
+	os.Exit(code)
+}
+
+func setupE2E(dsn string) (*e2eEnv, func(), error) {
+	admin, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema := fmt.Sprintf("e2e_test_%d", time.Now().UnixNano())
+	admin.MustExec("CREATE SCHEMA " + schema)
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("TEST_DATABASE_DSN must be a URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db, err := sqlx.Connect("pgx", u.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := migrate.Up(db); err != nil {
+		return nil, nil, err
+	}
+
+	mailDir, err := os.MkdirTemp("", "e2e-mail")
+	if err != nil {
+		return nil, nil, err
+	}
+	fake := midtranstest.NewServer(e2eServerKey)
+
+	r, err := newRouter(Config{
+		JWT_SECRET:                   "e2e-secret",
+		PAYMENT_GATEWAY:              "midtrans",
+		MIDTRANS_SERVER_KEY:          e2eServerKey,
+		MIDTRANS_BASE_URL:            fake.URL,
+		WS_PING_INTERVAL:             websocket.DefaultPumpConfig().PingInterval,
+		WS_PONG_WAIT:                 websocket.DefaultPumpConfig().PongWait,
+		WS_WRITE_TIMEOUT:             websocket.DefaultPumpConfig().WriteTimeout,
+		WS_MAX_MESSAGE_SIZE:          websocket.DefaultPumpConfig().MaxMessageSize,
+		OVERLAY_BASE_URL:             "http://overlay.test",
+		ACCESS_TOKEN_TTL:             15 * time.Minute,
+		REFRESH_TOKEN_TTL:            time.Hour,
+		PUBLIC_BASE_URL:              "http://api.test",
+		FRONTEND_BASE_URL:            "http://app.test",
+		TOTP_ISSUER:                  "E2E",
+		MAILER:                       "file",
+		MAILER_DIR:                   mailDir,
+		MAIL_FROM:                    "no-reply@example.com",
+		OUTBOX_POLL_INTERVAL:         50 * time.Millisecond,
+		ORDER_ID_PREFIX:              "E2E-",
+		RECONCILE_INTERVAL:           time.Hour,
+		RECONCILE_MIN_AGE:            time.Hour,
+		PAYMENT_WINDOW:               24 * time.Hour,
+		RECONCILE_CHALLENGE_INTERVAL: time.Hour,
+		PLATFORM_FEE_BPS:             500,
+		MIDTRANS_FEE_BPS:             70,
+		DEFAULT_CURRENCY:             "IDR",
+		MIN_DONATION:                 1000,
+		MIN_PAYOUT:                   50000,
+		ADMIN_API_KEY:                e2eAdminKey,
+	}, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := &e2eEnv{server: httptest.NewServer(r), midtrans: fake, mailDir: mailDir}
+	cleanup := func() {
+		env.server.Close()
+		fake.Close()
+		os.RemoveAll(mailDir)
+		db.Close()
+		admin.MustExec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	}
+	return env, cleanup, nil
+}
+
+func requireE2E(t *testing.T) *e2eEnv {
+	t.Helper()
+	if e2e == nil {
+		t.Skip("TEST_DATABASE_DSN not set, skipping end-to-end test")
+	}
+	return e2e
+}
+
+// do sends a JSON request to the API and decodes the JSON response into
+// out, if given. header alternates names and values.
+func (e *e2eEnv) do(t *testing.T, method, path, token string, body any, out any, header ...string) int {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, e.server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+var verifyLink = regexp.MustCompile(`/api/auth/verify\?token=(\S+)`)
+
+// verificationToken reads the token from the last verification email sent
+// to the address.
+func (e *e2eEnv) verificationToken(t *testing.T, email string) string {
+	t.Helper()
+
+	files, _ := filepath.Glob(filepath.Join(e.mailDir, "*.eml"))
+	sort.Strings(files)
+	for i := len(files) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("To: "+email+"\r\n")) {
+			continue
+		}
+		if match := verifyLink.FindSubmatch(data); match != nil {
+			token, _ := url.QueryUnescape(string(match[1]))
+			return token
+		}
+	}
+	t.Fatalf("no verification email for %s", email)
+	return ""
+}
+
+type e2eCreator struct {
+	username    string
+	token       string
+	widgetToken string
+}
+
+// signUp registers a creator, opens the verification link and logs in.
+func (e *e2eEnv) signUp(t *testing.T, username string) e2eCreator {
+	t.Helper()
+
+	email := username + "@example.com"
+	status := e.do(t, "POST", "/api/auth/register", "", map[string]string{
+		"email":        email,
+		"password":     "correct horse",
+		"username":     username,
+		"display_name": "Creator " + username,
+	}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("register: status %d", status)
+	}
+
+	verify := "/api/auth/verify?token=" + url.QueryEscape(e.verificationToken(t, email))
+	if status := e.do(t, "GET", verify, "", nil, nil); status != http.StatusOK {
+		t.Fatalf("verify: status %d", status)
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	status = e.do(t, "POST", "/api/auth/login", "", map[string]string{"email": email, "password": "correct horse"}, &login)
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d", status)
+	}
+
+	var profile struct {
+		WidgetSecretToken string `json:"widget_secret_token"`
+		EmailVerified     bool   `json:"email_verified"`
+	}
+	e.do(t, "GET", "/api/me", login.Token, nil, &profile)
+	if !profile.EmailVerified {
+		t.Fatal("email not verified after opening the link")
+	}
+
+	return e2eCreator{username: username, token: login.Token, widgetToken: profile.WidgetSecretToken}
+}
+
+// donate starts a donation and returns its order ID.
+func (e *e2eEnv) donate(t *testing.T, username string, amount int64, donor, message string) string {
+	t.Helper()
+
+	var resp struct {
+		RedirectURL string `json:"redirect_url"`
+		OrderID     string `json:"order_id"`
+	}
+	status := e.do(t, "POST", "/api/donate/"+username, "", map[string]any{
+		"amount":        amount,
+		"donor_name":    donor,
+		"donor_message": message,
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("donate: status %d", status)
+	}
+	if !strings.HasPrefix(resp.RedirectURL, e.midtrans.URL) {
+		t.Errorf("redirect_url = %q, want the fake Midtrans", resp.RedirectURL)
+	}
+	return resp.OrderID
+}
+
+// pay moves the order to transactionStatus at the fake Midtrans and posts
+// the webhook Midtrans would send. It returns the webhook's HTTP status.
+func (e *e2eEnv) pay(t *testing.T, orderID, transactionStatus string) int {
+	t.Helper()
+
+	if err := e.midtrans.SetStatus(orderID, transactionStatus, "accept"); err != nil {
+		t.Fatal(err)
+	}
+	body, err := e.midtrans.Notification(orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.do(t, "POST", "/api/webhook/payment/midtrans", "", body, nil)
+}
+
+// overlay connects to the creator's widget socket.
+func (e *e2eEnv) overlay(t *testing.T, widgetToken string) *gorilla.Conn {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(e.server.URL, "http") + "/ws/" + widgetToken
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial overlay: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// nextAlert waits for the next alert on the overlay, skipping pongs.
+func nextAlert(t *testing.T, conn *gorilla.Conn) websocket.Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg websocket.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for alert: %v", err)
+		}
+		if msg.Type == websocket.TypeAlert {
+			return msg
+		}
+	}
+}
+
+func TestE2EDonationAlertReachesOverlay(t *testing.T) {
+	e := requireE2E(t)
+	creator := e.signUp(t, "live")
+	conn := e.overlay(t, creator.widgetToken)
+
+	orderID := e.donate(t, creator.username, 25000, "Bob", "great stream")
+	txn, ok := e.midtrans.Transaction(orderID)
+	if !ok || txn.GrossAmount != 25000 {
+		t.Fatalf("Midtrans transaction = %+v, %v", txn, ok)
+	}
+
+	if status := e.pay(t, orderID, "settlement"); status != http.StatusOK {
+		t.Fatalf("webhook: status %d", status)
+	}
+
+	msg := nextAlert(t, conn)
+	if msg.ID == 0 || msg.Alert.DonorName != "Bob" || msg.Alert.Amount != 25000 ||
+		msg.Alert.Currency != "IDR" || msg.Alert.DonorMessage != "great stream" {
+		t.Errorf("alert = %+v %+v", msg, msg.Alert)
+	}
+
+	var donations []struct {
+		OrderID   string `json:"order_id"`
+		Status    string `json:"status"`
+		NetAmount *int64 `json:"net_amount"`
+	}
+	e.do(t, "GET", "/api/me/donations", creator.token, nil, &donations)
+	if len(donations) != 1 || donations[0].OrderID != orderID || donations[0].Status != "settled" {
+		t.Fatalf("donations = %+v", donations)
+	}
+	// 5% platform fee and 0.7% Midtrans fee
+	if donations[0].NetAmount == nil || *donations[0].NetAmount != 25000-1250-175 {
+		t.Errorf("net_amount = %v, want %d", donations[0].NetAmount, 25000-1250-175)
+	}
+
+	// Midtrans retries webhooks; a replay changes nothing
+	if status := e.pay(t, orderID, "settlement"); status != http.StatusOK {
+		t.Errorf("replayed webhook: status %d", status)
+	}
+}
+
+func TestE2EAlertQueuedWhileOverlayOffline(t *testing.T) {
+	e := requireE2E(t)
+	creator := e.signUp(t, "offline")
+
+	orderID := e.donate(t, creator.username, 10000, "", "")
+	if status := e.pay(t, orderID, "settlement"); status != http.StatusOK {
+		t.Fatalf("webhook: status %d", status)
+	}
+
+	msg := nextAlert(t, e.overlay(t, creator.widgetToken))
+	if msg.Alert.DonorName != "Anonymous" || msg.Alert.Amount != 10000 {
+		t.Errorf("alert = %+v", msg.Alert)
+	}
+}
+
+func TestE2EUnpaidDonationRaisesNoAlert(t *testing.T) {
+	e := requireE2E(t)
+	creator := e.signUp(t, "expired")
+	conn := e.overlay(t, creator.widgetToken)
+
+	orderID := e.donate(t, creator.username, 10000, "Eve", "")
+	if status := e.pay(t, orderID, "expire"); status != http.StatusOK {
+		t.Fatalf("webhook: status %d", status)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	var msg websocket.Message
+	if err := conn.ReadJSON(&msg); err == nil && msg.Type == websocket.TypeAlert {
+		t.Errorf("got alert %+v for an expired payment", msg.Alert)
+	}
+
+	var donations []json.RawMessage
+	e.do(t, "GET", "/api/me/donations", creator.token, nil, &donations)
+	if len(donations) != 0 {
+		t.Errorf("donations = %d, want 0", len(donations))
+	}
+}
+
+func TestE2EForgedWebhookIsRejected(t *testing.T) {
+	e := requireE2E(t)
+	creator := e.signUp(t, "forged")
+	orderID := e.donate(t, creator.username, 10000, "Mallory", "")
+
+	if err := e.midtrans.SetStatus(orderID, "settlement", "accept"); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := e.midtrans.Notification(orderID)
+	var notification map[string]string
+	json.Unmarshal(body, &notification)
+	notification["gross_amount"] = "1.00"
+
+	if status := e.do(t, "POST", "/api/webhook/payment/midtrans", "", notification, nil); status != http.StatusUnauthorized {
+		t.Errorf("forged webhook: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	var donations []json.RawMessage
+	e.do(t, "GET", "/api/me/donations", creator.token, nil, &donations)
+	if len(donations) != 0 {
+		t.Errorf("donations = %d, want 0 after a forged webhook", len(donations))
+	}
+}
+
+func TestE2EAdminRefundsAnyDonation(t *testing.T) {
+	e := requireE2E(t)
+	creator := e.signUp(t, "refunded")
+	orderID := e.donate(t, creator.username, 20000, "Trent", "")
+	if status := e.pay(t, orderID, "settlement"); status != http.StatusOK {
+		t.Fatalf("webhook: status %d", status)
+	}
+	path := "/api/admin/donations/" + orderID + "/refund"
+
+	if status := e.do(t, "POST", path, creator.token, map[string]any{"reason": "mine", "operator": "me"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("refund without admin key: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	// The shared key does not say who is refunding, so the operator must
+	if status := e.do(t, "POST", path, "", map[string]any{"reason": "fraud report"}, nil, "X-Admin-Key", e2eAdminKey); status != http.StatusBadRequest {
+		t.Errorf("refund without operator: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var refund struct {
+		Status         string `json:"status"`
+		RefundedAmount int64  `json:"refunded_amount"`
+	}
+	status := e.do(t, "POST", path, "", map[string]any{
+		"amount":   5000,
+		"reason":   "fraud report",
+		"operator": "ops@example.com",
+	}, &refund, "X-Admin-Key", e2eAdminKey)
+	if status != http.StatusOK || refund.Status != "partially_refunded" || refund.RefundedAmount != 5000 {
+		t.Fatalf("admin refund: status %d, %+v", status, refund)
+	}
+
+	txn, _ := e.midtrans.Transaction(orderID)
+	if txn.RefundAmount != 5000 || txn.TransactionStatus != "partial_refund" {
+		t.Errorf("Midtrans transaction = %+v", txn)
+	}
+}
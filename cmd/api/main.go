@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -11,16 +14,67 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
+	"my-platform/internal/accounts"
+	"my-platform/internal/alerts"
+	"my-platform/internal/donations"
 	"my-platform/internal/handlers"
+	"my-platform/internal/ledger"
+	"my-platform/internal/mailer"
 	"my-platform/internal/middleware"
+	"my-platform/internal/migrate"
+	"my-platform/internal/money"
+	"my-platform/internal/outbox"
+	"my-platform/internal/payment"
+	"my-platform/internal/repository"
 	"my-platform/internal/websocket"
 )
 
 // This struct will hold our loaded configuration
 type Config struct {
-	DSN                 string `mapstructure:"DSN"`
-	JWT_SECRET          string `mapstructure:"JWT_SECRET"`
-	MIDTRANS_SERVER_KEY string `mapstructure:"MIDTRANS_SERVER_KEY"`
+	DSN                          string        `mapstructure:"DSN"`
+	JWT_SECRET                   string        `mapstructure:"JWT_SECRET"`
+	PAYMENT_GATEWAY              string        `mapstructure:"PAYMENT_GATEWAY"`
+	MIDTRANS_SERVER_KEY          string        `mapstructure:"MIDTRANS_SERVER_KEY"`
+	MIDTRANS_PRODUCTION          bool          `mapstructure:"MIDTRANS_PRODUCTION"`
+	MIDTRANS_BASE_URL            string        `mapstructure:"MIDTRANS_BASE_URL"`
+	XENDIT_SECRET_KEY            string        `mapstructure:"XENDIT_SECRET_KEY"`
+	XENDIT_CALLBACK_TOKEN        string        `mapstructure:"XENDIT_CALLBACK_TOKEN"`
+	WS_PING_INTERVAL             time.Duration `mapstructure:"WS_PING_INTERVAL"`
+	WS_PONG_WAIT                 time.Duration `mapstructure:"WS_PONG_WAIT"`
+	WS_WRITE_TIMEOUT             time.Duration `mapstructure:"WS_WRITE_TIMEOUT"`
+	WS_MAX_MESSAGE_SIZE          int64         `mapstructure:"WS_MAX_MESSAGE_SIZE"`
+	OVERLAY_BASE_URL             string        `mapstructure:"OVERLAY_BASE_URL"`
+	ACCESS_TOKEN_TTL             time.Duration `mapstructure:"ACCESS_TOKEN_TTL"`
+	REFRESH_TOKEN_TTL            time.Duration `mapstructure:"REFRESH_TOKEN_TTL"`
+	PUBLIC_BASE_URL              string        `mapstructure:"PUBLIC_BASE_URL"`
+	FRONTEND_BASE_URL            string        `mapstructure:"FRONTEND_BASE_URL"`
+	TOTP_ISSUER                  string        `mapstructure:"TOTP_ISSUER"`
+	MAILER                       string        `mapstructure:"MAILER"`
+	MAILER_DIR                   string        `mapstructure:"MAILER_DIR"`
+	MAIL_FROM                    string        `mapstructure:"MAIL_FROM"`
+	SMTP_HOST                    string        `mapstructure:"SMTP_HOST"`
+	SMTP_PORT                    int           `mapstructure:"SMTP_PORT"`
+	SMTP_USERNAME                string        `mapstructure:"SMTP_USERNAME"`
+	SMTP_PASSWORD                string        `mapstructure:"SMTP_PASSWORD"`
+	OUTBOX_POLL_INTERVAL         time.Duration `mapstructure:"OUTBOX_POLL_INTERVAL"`
+	ALERT_WEBHOOK_URL            string        `mapstructure:"ALERT_WEBHOOK_URL"`
+	ORDER_ID_PREFIX              string        `mapstructure:"ORDER_ID_PREFIX"`
+	RECONCILE_INTERVAL           time.Duration `mapstructure:"RECONCILE_INTERVAL"`
+	RECONCILE_MIN_AGE            time.Duration `mapstructure:"RECONCILE_MIN_AGE"`
+	PAYMENT_WINDOW               time.Duration `mapstructure:"PAYMENT_WINDOW"`
+	RECONCILE_CHALLENGE_INTERVAL time.Duration `mapstructure:"RECONCILE_CHALLENGE_INTERVAL"`
+	PLATFORM_FEE_BPS             int           `mapstructure:"PLATFORM_FEE_BPS"`
+	PLATFORM_FEE_FIXED           int64         `mapstructure:"PLATFORM_FEE_FIXED"`
+	DEFAULT_CURRENCY             string        `mapstructure:"DEFAULT_CURRENCY"`
+	MIN_DONATION                 int64         `mapstructure:"MIN_DONATION"`
+	MAX_DONATION                 int64         `mapstructure:"MAX_DONATION"`
+	MIDTRANS_FEE_BPS             int           `mapstructure:"MIDTRANS_FEE_BPS"`
+	MIDTRANS_FEE_FIXED           int64         `mapstructure:"MIDTRANS_FEE_FIXED"`
+	XENDIT_FEE_BPS               int           `mapstructure:"XENDIT_FEE_BPS"`
+	XENDIT_FEE_FIXED             int64         `mapstructure:"XENDIT_FEE_FIXED"`
+	MIN_PAYOUT                   int64         `mapstructure:"MIN_PAYOUT"`
+	ADMIN_API_KEY                string        `mapstructure:"ADMIN_API_KEY"`
+	DELETION_GRACE_PERIOD        time.Duration `mapstructure:"DELETION_GRACE_PERIOD"`
 }
 
 // Function loads the config.env file from the root folder
@@ -30,6 +84,53 @@ func loadConfig() (config Config, err error) {
 	viper.SetConfigType("env")
 	viper.AutomaticEnv()
 
+	// Defaults for optional settings, overridable from config.env or the environment
+	pump := websocket.DefaultPumpConfig()
+	viper.SetDefault("PAYMENT_GATEWAY", "midtrans")
+	viper.SetDefault("MIDTRANS_PRODUCTION", false)
+	viper.SetDefault("MIDTRANS_BASE_URL", "")
+	viper.SetDefault("XENDIT_SECRET_KEY", "")
+	viper.SetDefault("XENDIT_CALLBACK_TOKEN", "")
+	viper.SetDefault("WS_PING_INTERVAL", pump.PingInterval)
+	viper.SetDefault("WS_PONG_WAIT", pump.PongWait)
+	viper.SetDefault("WS_WRITE_TIMEOUT", pump.WriteTimeout)
+	viper.SetDefault("WS_MAX_MESSAGE_SIZE", pump.MaxMessageSize)
+	viper.SetDefault("OVERLAY_BASE_URL", "http://localhost:5173/overlay")
+	viper.SetDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+	viper.SetDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	viper.SetDefault("PUBLIC_BASE_URL", "http://localhost:8080")
+	viper.SetDefault("FRONTEND_BASE_URL", "http://localhost:5173")
+	viper.SetDefault("TOTP_ISSUER", "Donation Platform")
+	viper.SetDefault("MAILER", "log")
+	viper.SetDefault("MAILER_DIR", "./mail")
+	viper.SetDefault("MAIL_FROM", "no-reply@localhost")
+	viper.SetDefault("SMTP_HOST", "")
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("SMTP_USERNAME", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
+	viper.SetDefault("OUTBOX_POLL_INTERVAL", 500*time.Millisecond)
+	viper.SetDefault("ALERT_WEBHOOK_URL", "")
+	viper.SetDefault("ORDER_ID_PREFIX", "DONATION-")
+	viper.SetDefault("RECONCILE_INTERVAL", time.Minute)
+	viper.SetDefault("RECONCILE_MIN_AGE", 10*time.Minute)
+	// Midtrans Snap and Xendit invoices both expire after 24 hours by default
+	viper.SetDefault("PAYMENT_WINDOW", 24*time.Hour)
+	viper.SetDefault("RECONCILE_CHALLENGE_INTERVAL", time.Hour)
+	viper.SetDefault("PLATFORM_FEE_BPS", 500)
+	viper.SetDefault("PLATFORM_FEE_FIXED", 0)
+	// QRIS rates; adjust to the methods and rates in your merchant contract
+	viper.SetDefault("MIDTRANS_FEE_BPS", 70)
+	viper.SetDefault("MIDTRANS_FEE_FIXED", 0)
+	viper.SetDefault("XENDIT_FEE_BPS", 70)
+	viper.SetDefault("XENDIT_FEE_FIXED", 0)
+	// Amounts are in minor units of DEFAULT_CURRENCY, as are the fixed fees
+	viper.SetDefault("DEFAULT_CURRENCY", "IDR")
+	viper.SetDefault("MIN_DONATION", 1000)
+	viper.SetDefault("MAX_DONATION", 0)
+	viper.SetDefault("MIN_PAYOUT", 50000)
+	viper.SetDefault("ADMIN_API_KEY", "")
+	viper.SetDefault("DELETION_GRACE_PERIOD", 30*24*time.Hour)
+
 	err = viper.ReadInConfig()
 	if err != nil {
 		return
@@ -40,6 +141,11 @@ func loadConfig() (config Config, err error) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	log.Println("Starting donation platform server...")
 
 	// Load Configuration
@@ -56,28 +162,151 @@ func main() {
 	defer db.Close()
 	log.Println("Successfully connected to Supabase (PostgreSQL)!")
 
+	// Refuse to serve against a schema older than this binary
+	pending, err := migrate.Pending(db)
+	if err != nil {
+		log.Fatal("cannot check database migrations:", err)
+	}
+	if len(pending) > 0 {
+		log.Fatalf("database schema is behind by %d migration(s), starting with %04d_%s; run `api migrate up`, "+
+			"or `api migrate baseline <version>` first if its tables were made by hand",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+
+	r, err := newRouter(config, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Start the server
+	log.Println("Server starting on http://localhost:8080")
+	if err := r.Run(":8080"); err != nil {
+		log.Fatal("could not start server:", err)
+	}
+}
+
+// newRouter wires the handlers to db and starts the background workers
+// they rely on: the websocket hub, the outbox dispatcher and the
+// reconciler.
+func newRouter(config Config, db *sqlx.DB) (*gin.Engine, error) {
+	pumpConfig := websocket.PumpConfig{
+		PingInterval:   config.WS_PING_INTERVAL,
+		PongWait:       config.WS_PONG_WAIT,
+		WriteTimeout:   config.WS_WRITE_TIMEOUT,
+		MaxMessageSize: config.WS_MAX_MESSAGE_SIZE,
+	}
+	if err := pumpConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid websocket config: %w", err)
+	}
+	defaultCurrency, err := money.Lookup(config.DEFAULT_CURRENCY)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_CURRENCY: %w", err)
+	}
+	if len(config.ORDER_ID_PREFIX) > handlers.MaxOrderIDPrefixLen {
+		return nil, fmt.Errorf("ORDER_ID_PREFIX must be at most %d characters", handlers.MaxOrderIDPrefixLen)
+	}
+	// A ticker panics on a non-positive interval, taking the process down
+	if config.OUTBOX_POLL_INTERVAL <= 0 {
+		return nil, errors.New("OUTBOX_POLL_INTERVAL must be positive")
+	}
+	reconcilerConfig := donations.ReconcilerConfig{
+		Interval:          config.RECONCILE_INTERVAL,
+		MinAge:            config.RECONCILE_MIN_AGE,
+		PaymentWindow:     config.PAYMENT_WINDOW,
+		ChallengeInterval: config.RECONCILE_CHALLENGE_INTERVAL,
+	}
+	if err := reconcilerConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid reconciler config: %w", err)
+	}
+
+	// Set up the mailer used for verification links
+	mail, err := mailer.New(mailer.Config{
+		Driver:   config.MAILER,
+		Dir:      config.MAILER_DIR,
+		From:     config.MAIL_FROM,
+		SMTPHost: config.SMTP_HOST,
+		SMTPPort: config.SMTP_PORT,
+		SMTPUser: config.SMTP_USERNAME,
+		SMTPPass: config.SMTP_PASSWORD,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up mailer: %w", err)
+	}
+
+	// Set up every configured payment gateway; new donations go to the
+	// one selected for this deployment
+	gateways, err := payment.New(payment.Config{
+		Provider:            config.PAYMENT_GATEWAY,
+		MidtransServerKey:   config.MIDTRANS_SERVER_KEY,
+		MidtransProduction:  config.MIDTRANS_PRODUCTION,
+		MidtransBaseURL:     config.MIDTRANS_BASE_URL,
+		XenditSecretKey:     config.XENDIT_SECRET_KEY,
+		XenditCallbackToken: config.XENDIT_CALLBACK_TOKEN,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up payment gateway: %w", err)
+	}
+	log.Printf("Using %s payment gateway for checkouts.", gateways.Checkout().Name())
+
 	// Create and Run the hub
 	hub := websocket.NewHub()
 	go hub.Run()
 	log.Println("WebSocket Hub started.")
 
+	// Deliver committed outbox messages, such as settled donation alerts
+	dispatcher := outbox.NewDispatcher(db, config.OUTBOX_POLL_INTERVAL)
+	dispatcher.Register(outbox.TopicDonationAlert, alerts.HubSink{Hub: hub})
+	if config.ALERT_WEBHOOK_URL != "" {
+		dispatcher.Register(outbox.TopicDonationAlert, outbox.NewWebhookSink(config.ALERT_WEBHOOK_URL))
+	}
+	go dispatcher.Run()
+	log.Println("Outbox dispatcher started.")
+
 	// Set up our Gin router
 	r := gin.Default()
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	// Create an instance o the handler
-	authHandler := handlers.NewAuthHandler(db, config.JWT_SECRET)
-	creatorHandler := handlers.NewCreatorHandler(db)
-	donationHandler := handlers.NewDonationHandler(db, config.MIDTRANS_SERVER_KEY, hub)
-	wsHandler := handlers.NewWebSocketHandler(db, hub)
+	alertQueue := alerts.NewQueue(db)
+	books := ledger.NewLedger(db,
+		ledger.Schedule{PercentBPS: config.PLATFORM_FEE_BPS, FixedAmount: config.PLATFORM_FEE_FIXED, Currency: defaultCurrency.Code},
+		map[string]ledger.Schedule{
+			"midtrans": {PercentBPS: config.MIDTRANS_FEE_BPS, FixedAmount: config.MIDTRANS_FEE_FIXED, Currency: defaultCurrency.Code},
+			"xendit":   {PercentBPS: config.XENDIT_FEE_BPS, FixedAmount: config.XENDIT_FEE_FIXED, Currency: defaultCurrency.Code},
+		})
+	donationUpdater := donations.NewUpdater(db, alertQueue, books)
+	repos := repository.NewPostgres(db, alertQueue, donationUpdater)
+	authHandler := handlers.NewAuthHandler(repos.Users, repos.Sessions, mail, handlers.AuthConfig{
+		JwtSecret:       config.JWT_SECRET,
+		AccessTokenTTL:  config.ACCESS_TOKEN_TTL,
+		RefreshTokenTTL: config.REFRESH_TOKEN_TTL,
+		PublicBaseURL:   config.PUBLIC_BASE_URL,
+		FrontendBaseURL: config.FRONTEND_BASE_URL,
+		TOTPIssuer:      config.TOTP_ISSUER,
+		DeletionGrace:   config.DELETION_GRACE_PERIOD,
+	})
+	purger := accounts.NewPurger(repos.Users, config.DELETION_GRACE_PERIOD)
+	go purger.Run()
+	creatorHandler := handlers.NewCreatorHandler(repos, hub, config.OVERLAY_BASE_URL)
+	reconciler := donations.NewReconciler(db, gateways, donationUpdater, reconcilerConfig)
+	go reconciler.Run()
+	donationHandler := handlers.NewDonationHandler(repos, gateways, handlers.DonationConfig{
+		OrderIDPrefix:    config.ORDER_ID_PREFIX,
+		DefaultCurrency:  defaultCurrency.Code,
+		DefaultMinAmount: config.MIN_DONATION,
+		DefaultMaxAmount: config.MAX_DONATION,
+	})
+	payoutHandler := handlers.NewPayoutHandler(repos, money.Money{Amount: config.MIN_PAYOUT, Currency: defaultCurrency.Code})
+	feeHandler := handlers.NewFeeHandler(repos)
+	wsHandler := handlers.NewWebSocketHandler(repos, hub, pumpConfig)
 
 	// All API routes under /api
 	api := r.Group("/api")
@@ -87,26 +316,70 @@ func main() {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/login/2fa", authHandler.LoginTwoFactor)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authHandler.Logout)
+			auth.GET("/verify", authHandler.VerifyEmail)
+			auth.POST("/forgot-password", authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.POST("/restore", authHandler.RestoreAccount)
 		}
 
 		// Protected Endpoint
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware(config.JWT_SECRET))
+		protected.Use(middleware.AuthMiddleware(config.JWT_SECRET, repos.Sessions))
 		{
 			protected.GET("/me", creatorHandler.GetMyProfile)
+			protected.PATCH("/me", creatorHandler.UpdateMyProfile)
+			protected.DELETE("/me", authHandler.DeleteAccount)
+			protected.POST("/me/password", authHandler.ChangePassword)
+			protected.POST("/me/email", authHandler.ChangeEmail)
 			protected.GET("/me/donations", creatorHandler.GetMyDonations)
+			protected.GET("/me/donations/export", creatorHandler.ExportMyDonations)
+			protected.POST("/me/donations/:order_id/refund", donationHandler.RefundDonation)
+			protected.GET("/me/currencies", donationHandler.GetMyCurrencies)
+			protected.PUT("/me/currencies/:currency", donationHandler.SetMyCurrency)
+			protected.DELETE("/me/currencies/:currency", donationHandler.RemoveMyCurrency)
+			protected.POST("/me/widget-token/rotate", creatorHandler.RotateWidgetToken)
+			protected.POST("/me/verify/resend", authHandler.ResendVerification)
+			protected.POST("/me/2fa/enroll", authHandler.EnrollTwoFactor)
+			protected.POST("/me/2fa/confirm", authHandler.ConfirmTwoFactor)
+			protected.POST("/me/2fa/disable", authHandler.DisableTwoFactor)
+			protected.GET("/me/sessions", authHandler.GetMySessions)
+			protected.DELETE("/me/sessions", authHandler.RevokeOtherSessions)
+			protected.DELETE("/me/sessions/:id", authHandler.RevokeSession)
+			protected.GET("/me/balance", payoutHandler.GetMyBalance)
+			protected.GET("/me/bank-accounts", payoutHandler.GetMyBankAccounts)
+			protected.POST("/me/bank-accounts", payoutHandler.AddBankAccount)
+			protected.GET("/me/payouts", payoutHandler.GetMyPayouts)
+			protected.POST("/me/payouts", payoutHandler.RequestPayout)
+		}
+
+		// Operator Endpoint
+		admin := api.Group("/admin")
+		admin.Use(middleware.AdminMiddleware(config.ADMIN_API_KEY))
+		{
+			admin.POST("/donations/:order_id/refund", donationHandler.AdminRefundDonation)
+			admin.GET("/payouts", payoutHandler.ListPayouts)
+			admin.POST("/payouts/:id/approve", payoutHandler.ApprovePayout)
+			admin.POST("/payouts/:id/paid", payoutHandler.MarkPayoutPaid)
+			admin.POST("/payouts/:id/fail", payoutHandler.FailPayout)
+			admin.GET("/fee-schedules", feeHandler.ListFeeSchedules)
+			admin.PUT("/fee-schedules/:creator_id/:currency", feeHandler.SetFeeSchedule)
+			admin.DELETE("/fee-schedules/:creator_id/:currency", feeHandler.DeleteFeeSchedule)
+			admin.GET("/metrics/websocket", wsHandler.Stats)
 		}
 
+		// Point each provider at /webhook/payment/<gateway>; the bare path
+		// goes to the checkout gateway
 		api.POST("/webhook/payment", donationHandler.HandlePaymentNotification)
+		api.POST("/webhook/payment/:gateway", donationHandler.HandlePaymentNotification)
+		api.GET("/creators/:username", donationHandler.GetCreatorPage)
 		api.POST("/donate/:username", donationHandler.CreateDonation)
 	}
 
 	// Websocket Route
 	r.GET("/ws/:secretToken", wsHandler.ServerWs)
 
-	// Start the server
-	log.Println("Server starting on http://localhost:8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("could not start server:", err)
-	}
+	return r, nil
 }
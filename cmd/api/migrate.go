@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/migrate"
+)
+
+const migrateUsage = `usage: api migrate <command>
+
+commands:
+  up             apply all pending migrations
+  down [n]       roll back the latest n migrations (default 1)
+  status         list migrations and whether they are applied
+  baseline <n>   mark migrations up to version n as applied without running
+                 them, for a database whose tables were made by hand
+  create <name>  write a new empty migration to ` + migrate.Dir
+
+// runMigrate is the "migrate" subcommand.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	// Creating a migration only touches the source tree
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		paths, err := migrate.Create(migrate.Dir, args[1])
+		if err != nil {
+			log.Fatal("cannot create migration:", err)
+		}
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		return
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal("cannot load config:", err)
+	}
+	db, err := sqlx.Connect("pgx", config.DSN)
+	if err != nil {
+		log.Fatal("cannot connect to database:", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrate.Up(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("migration failed:", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal("down takes a positive number of migrations")
+			}
+		}
+		reverted, err := migrate.Down(db, n)
+		for _, m := range reverted {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("rollback failed:", err)
+		}
+	case "baseline":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 1 {
+			log.Fatal("baseline takes a positive migration version")
+		}
+		recorded, err := migrate.Baseline(db, version)
+		if err != nil {
+			log.Fatal("baseline failed:", err)
+		}
+		for _, m := range recorded {
+			fmt.Printf("marked %04d_%s as applied\n", m.Version, m.Name)
+		}
+	case "status":
+		statuses, err := migrate.Statuses(db)
+		if err != nil {
+			log.Fatal("cannot read migration status:", err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-45s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,45 @@
+// Package accounts runs account housekeeping in the background.
+package accounts
+
+import (
+	"log"
+	"time"
+
+	"my-platform/internal/repository"
+)
+
+// purgeInterval is how often deleted accounts past their grace period are
+// looked for. Purging a little late is harmless.
+const purgeInterval = time.Hour
+
+// Purger scrubs the personal data of accounts whose deletion grace period
+// has ended.
+type Purger struct {
+	Users repository.UserRepo
+	Grace time.Duration
+}
+
+func NewPurger(users repository.UserRepo, grace time.Duration) *Purger {
+	return &Purger{Users: users, Grace: grace}
+}
+
+// Run purges forever, once every purgeInterval.
+func (p *Purger) Run() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.PurgeOnce(); err != nil {
+			log.Println("Account purge failed:", err)
+		}
+	}
+}
+
+// PurgeOnce purges every account deleted more than Grace ago.
+func (p *Purger) PurgeOnce() error {
+	n, err := p.Users.PurgeDeleted(time.Now().Add(-p.Grace))
+	if n > 0 {
+		log.Printf("Purged %d deleted account(s)", n)
+	}
+	return err
+}
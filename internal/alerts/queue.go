@@ -0,0 +1,118 @@
+package alerts
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+	ws "my-platform/internal/websocket"
+)
+
+// ReplayBatchSize caps how many undelivered alerts are pushed to an overlay
+// at once, so a long backlog cannot overflow the client's send buffer.
+const ReplayBatchSize = 50
+
+// Alert statuses. An alert leaves the replay queue once an overlay reports
+// that it was either played on stream or skipped.
+const (
+	StatusPending   = "pending"
+	StatusDisplayed = "displayed"
+	StatusSkipped   = "skipped"
+)
+
+// Queue persists donation alerts so overlays that were offline when a
+// donation settled still receive them once they reconnect.
+type Queue struct {
+	DB *sqlx.DB
+}
+
+func NewQueue(db *sqlx.DB) *Queue {
+	return &Queue{DB: db}
+}
+
+// Enqueue stores a pending alert for a settled donation and returns the
+// payload that should be broadcast to the creator's overlays. It takes the
+// caller's transaction so the alert is only stored if the settlement is.
+func (q *Queue) Enqueue(tx sqlx.Queryer, donation models.Donation) (ws.DonationAlert, error) {
+	var alertID int
+	query := `INSERT INTO alerts (creator_id, donation_id, status)
+	          VALUES ($1, $2, 'pending')
+	          RETURNING id`
+	if err := sqlx.Get(tx, &alertID, query, donation.CreatorID, donation.ID); err != nil {
+		return ws.DonationAlert{}, err
+	}
+
+	return ws.DonationAlert{
+		ID:                alertID,
+		TargetCreatorID:   donation.CreatorID,
+		DonorName:         donation.DonorName,
+		Amount:            donation.Amount,
+		Currency:          donation.Currency,
+		DonorMessage:      donation.DonorMessage,
+		MediaType:         donation.MediaType,
+		MediaURL:          donation.MediaURL,
+		MediaStartSeconds: donation.MediaStartSeconds,
+		MediaEndSeconds:   donation.MediaEndSeconds,
+	}, nil
+}
+
+// Pending returns the creator's unacknowledged alerts, oldest first.
+func (q *Queue) Pending(creatorID int) ([]ws.DonationAlert, error) {
+	var pending []ws.DonationAlert
+	query := `SELECT
+	            a.id AS alert_id, d.donor_name, d.amount, d.currency, d.donor_message,
+	            d.media_type, d.media_url, d.media_start_seconds, d.media_end_seconds
+	          FROM alerts a
+	          INNER JOIN donations d ON a.donation_id = d.id
+	          WHERE a.creator_id = $1 AND a.status = 'pending'
+	          ORDER BY a.created_at, a.id
+	          LIMIT $2`
+	if err := q.DB.Select(&pending, query, creatorID, ReplayBatchSize); err != nil {
+		return nil, err
+	}
+
+	for i := range pending {
+		pending[i].TargetCreatorID = creatorID
+	}
+	return pending, nil
+}
+
+// MarkDelivered records that the alert was written to one of the
+// creator's overlays. Only the first delivery is kept, so a replay does not
+// move it.
+func (q *Queue) MarkDelivered(creatorID, alertID int) error {
+	query := `UPDATE alerts SET delivered_at = NOW()
+	          WHERE id = $1 AND creator_id = $2 AND delivered_at IS NULL`
+	_, err := q.DB.Exec(query, alertID, creatorID)
+	return err
+}
+
+// Resolve records the overlay's outcome for an alert, either displayed or
+// skipped. The creator ID scopes the update so an overlay can only resolve
+// its own creator's alerts. It reports false if no pending alert matched.
+// An ack also proves delivery, so delivered_at is filled in if recording
+// the send failed.
+func (q *Queue) Resolve(creatorID, alertID int, status string) (bool, error) {
+	query := `UPDATE alerts
+	          SET status = $3,
+	              delivered_at = COALESCE(delivered_at, NOW()),
+	              displayed_at = CASE WHEN $4 THEN NOW() END
+	          WHERE id = $1 AND creator_id = $2 AND status = 'pending'`
+	res, err := q.DB.Exec(query, alertID, creatorID, status, status == StatusDisplayed)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Withdraw skips the donation's alert if no overlay has resolved it yet,
+// so a refunded donation is not shown on stream after the fact.
+func (q *Queue) Withdraw(tx sqlx.Execer, donationID int) error {
+	query := `UPDATE alerts SET status = 'skipped' WHERE donation_id = $1 AND status = 'pending'`
+	_, err := tx.Exec(query, donationID)
+	return err
+}
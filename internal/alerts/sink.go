@@ -0,0 +1,69 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/outbox"
+	ws "my-platform/internal/websocket"
+)
+
+// hubSendTimeout is how long HubSink waits for the hub to take an alert
+// before the dispatcher retries it later.
+const hubSendTimeout = 5 * time.Second
+
+// outboxAlert is the outbox payload of an alert. DonationAlert keeps its
+// routing fields out of JSON, so they are carried next to it.
+type outboxAlert struct {
+	AlertID   int              `json:"alert_id"`
+	CreatorID int              `json:"creator_id"`
+	Alert     ws.DonationAlert `json:"alert"`
+}
+
+// Publish writes the alert to the outbox in the caller's transaction. The
+// outbox dispatcher broadcasts it once the transaction has committed.
+func Publish(tx sqlx.Execer, alert ws.DonationAlert) error {
+	return outbox.Write(tx, outbox.TopicDonationAlert, outboxAlert{
+		AlertID:   alert.ID,
+		CreatorID: alert.TargetCreatorID,
+		Alert:     alert,
+	})
+}
+
+// DecodeOutbox reads an alert back from an outbox message.
+func DecodeOutbox(msg outbox.Message) (ws.DonationAlert, error) {
+	var payload outboxAlert
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return ws.DonationAlert{}, err
+	}
+
+	alert := payload.Alert
+	alert.ID = payload.AlertID
+	alert.TargetCreatorID = payload.CreatorID
+	return alert, nil
+}
+
+// HubSink broadcasts outbox alerts to the creator's connected overlays.
+// Overlays that are offline pick the alert up from the queue on reconnect.
+type HubSink struct {
+	Hub *ws.Hub
+}
+
+func (s HubSink) Name() string { return "hub" }
+
+func (s HubSink) Deliver(msg outbox.Message) error {
+	alert, err := DecodeOutbox(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.Hub.BroadcastAlert <- alert:
+		return nil
+	case <-time.After(hubSendTimeout):
+		return errors.New("hub did not accept the alert in time")
+	}
+}
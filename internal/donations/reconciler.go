@@ -0,0 +1,135 @@
+package donations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+	"my-platform/internal/payment"
+)
+
+// reconcileBatchSize caps how many donations are checked per pass, so a
+// large backlog cannot hit the gateway's rate limits all at once.
+const reconcileBatchSize = 100
+
+// ReconcilerConfig controls how often and how eagerly stuck donations are
+// checked.
+type ReconcilerConfig struct {
+	// Interval between passes.
+	Interval time.Duration
+	// MinAge gives the webhook a head start; younger donations are skipped.
+	MinAge time.Duration
+	// PaymentWindow is how long the gateway lets a donor pay. Donations
+	// still pending after it are expired.
+	PaymentWindow time.Duration
+	// ChallengeInterval is how often a donation held for fraud review is
+	// checked. It stays challenged until the merchant accepts or denies
+	// it at the gateway, which can take days, so it is not checked on
+	// every pass.
+	ChallengeInterval time.Duration
+}
+
+// Validate rejects settings the reconciler cannot run with; a
+// non-positive interval would panic in its ticker.
+func (c ReconcilerConfig) Validate() error {
+	if c.Interval <= 0 || c.MinAge <= 0 || c.PaymentWindow <= 0 || c.ChallengeInterval <= 0 {
+		return errors.New("reconciler interval, minimum age, payment window and challenge interval must all be positive")
+	}
+	return nil
+}
+
+// Reconciler polls the gateways for donations still waiting on a status,
+// covering for webhooks that were never delivered.
+type Reconciler struct {
+	DB       *sqlx.DB
+	Gateways *payment.Registry
+	Updater  *Updater
+	Config   ReconcilerConfig
+}
+
+func NewReconciler(db *sqlx.DB, gateways *payment.Registry, updater *Updater, cfg ReconcilerConfig) *Reconciler {
+	return &Reconciler{DB: db, Gateways: gateways, Updater: updater, Config: cfg}
+}
+
+// Run reconciles forever, once every Config.Interval.
+func (r *Reconciler) Run() {
+	ticker := time.NewTicker(r.Config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.ReconcileOnce(); err != nil {
+			log.Println("Donation reconciliation failed:", err)
+		}
+	}
+}
+
+// ReconcileOnce checks one batch of stuck donations per registered
+// gateway. Each donation is checked with the gateway that took it, since
+// another provider would not know the order ID.
+func (r *Reconciler) ReconcileOnce() error {
+	for _, gateway := range r.Gateways.All() {
+		if err := r.reconcileGateway(gateway); err != nil {
+			return fmt.Errorf("%s: %w", gateway.Name(), err)
+		}
+	}
+	return nil
+}
+
+// reconcileGateway checks the donations the gateway has been asked about
+// least recently, never-checked ones first. Every checked donation is
+// stamped, so abandoned checkouts still inside the payment window take
+// turns with the rest instead of filling every batch.
+func (r *Reconciler) reconcileGateway(gateway payment.Gateway) error {
+	var stuck []models.Donation
+	query := `SELECT id, order_id, status, created_at FROM donations
+	          WHERE payment_gateway = $1
+	            AND created_at < $2
+	            AND (status = 'pending'
+	                 OR (status = 'challenge' AND (last_checked_at IS NULL OR last_checked_at < $3)))
+	          ORDER BY last_checked_at NULLS FIRST, created_at
+	          LIMIT $4`
+	cutoff := time.Now().Add(-r.Config.MinAge)
+	challengeCutoff := time.Now().Add(-r.Config.ChallengeInterval)
+	if err := r.DB.Select(&stuck, query, gateway.Name(), cutoff, challengeCutoff, reconcileBatchSize); err != nil {
+		return err
+	}
+
+	for _, donation := range stuck {
+		if err := r.reconcile(gateway, donation); err != nil {
+			log.Printf("Failed to reconcile donation %s: %v", donation.OrderID, err)
+		}
+
+		// Stamped even on failure, so one bad order cannot hold the front
+		// of the queue
+		if _, err := r.DB.Exec(`UPDATE donations SET last_checked_at = NOW() WHERE id = $1`, donation.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcile(gateway payment.Gateway, donation models.Donation) error {
+	expired := time.Since(donation.CreatedAt) > r.Config.PaymentWindow
+
+	txn, err := gateway.FetchStatus(donation.OrderID)
+	switch {
+	case errors.Is(err, payment.ErrNotFound):
+		// The donor never picked a payment method, so the gateway has
+		// nothing on record; give up once the window has closed
+		if !expired {
+			return nil
+		}
+		txn = &payment.Transaction{OrderID: donation.OrderID, Status: payment.StatusExpired}
+	case err != nil:
+		return err
+	case txn.Status == payment.StatusPending && expired:
+		txn.Status = payment.StatusExpired
+	}
+
+	_, err = r.Updater.Apply(*txn, SourceReconciler)
+	return err
+}
@@ -0,0 +1,224 @@
+package donations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+)
+
+var (
+	// ErrNotRefundable means the donation is not in a state that can be
+	// refunded, e.g. it was never paid or is already fully refunded.
+	ErrNotRefundable = errors.New("donation cannot be refunded")
+	// ErrInvalidRefundAmount means the amount is not positive or exceeds
+	// what is left to refund.
+	ErrInvalidRefundAmount = errors.New("invalid refund amount")
+	// ErrGateway wraps the gateway's error when it did not refund, as
+	// opposed to a failure to record a refund here.
+	ErrGateway = errors.New("gateway did not refund")
+)
+
+// RefundRequest asks to return part or all of a donation.
+type RefundRequest struct {
+	OrderID string
+	// CreatorID scopes the refund to the creator who received the
+	// donation. Refunds by an operator are not scoped.
+	CreatorID int
+	// Amount to refund in minor units of the donation's currency; zero
+	// refunds whatever is left.
+	Amount int64
+	Reason string
+	// RequestedBy is the creator's user ID. An operator refunding through
+	// the admin API is named by Operator instead.
+	RequestedBy int
+	Operator    string
+}
+
+// requester describes who asked for the refund, for logs.
+func (r RefundRequest) requester() string {
+	if r.Operator != "" {
+		return "operator " + r.Operator
+	}
+	return fmt.Sprintf("user %d", r.RequestedBy)
+}
+
+// PendingRefundTTL is how long a pending refund counts against what is
+// left to refund. One stays pending only if the process died while the
+// gateway was called, and by then the gateway's refund webhook has
+// brought the donation up to date.
+const PendingRefundTTL = 10 * time.Minute
+
+// PendingRefund is a refund reserved against a donation but not yet sent
+// to the gateway.
+type PendingRefund struct {
+	ID      int
+	OrderID string
+	Gateway payment.Gateway
+	Amount  money.Money
+	// Total is what the donation will have refunded with this one.
+	Total int64
+	// Key is the idempotency key the refund is sent to the gateway with.
+	Key string
+}
+
+// PlanRefund checks a refund request against the donation, whose
+// RefundedAmount includes any pending refunds, and works out the amount
+// to refund.
+func PlanRefund(donation models.Donation, req RefundRequest) (int64, error) {
+	if req.Operator == "" && donation.CreatorID != req.CreatorID {
+		return 0, ErrNotFound
+	}
+	if !models.CanTransitionDonation(donation.Status, models.DonationPartiallyRefunded) {
+		return 0, ErrNotRefundable
+	}
+
+	remaining := donation.Amount - donation.RefundedAmount
+	amount := req.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return 0, ErrInvalidRefundAmount
+	}
+	return amount, nil
+}
+
+// RefundKey is the idempotency key a refund is sent to the gateway with.
+// It is keyed by the new total, like the ledger entry, so a retry of the
+// same refund is recognised by the gateway.
+func RefundKey(donation models.Donation, amount int64) string {
+	return fmt.Sprintf("%s-R%d", donation.OrderID, donation.RefundedAmount+amount)
+}
+
+// DecideRefund works out the transition that brings the donation up to a
+// refunded total. It reports false if the donation is there already, as
+// when the gateway's refund webhook or a later refund got in first.
+func DecideRefund(donation models.Donation, total int64) (Result, bool) {
+	result := Result{Donation: donation, From: donation.Status, To: models.DonationPartiallyRefunded}
+	if total >= donation.Amount {
+		result.To = models.DonationRefunded
+	}
+	if donation.RefundedAmount >= total || !models.CanTransitionDonation(result.From, result.To) {
+		result.To = result.From
+		return result, false
+	}
+	return result, true
+}
+
+// Refund returns money to the donor through the gateway that took the
+// payment, looked up in gateways, and records it. Nothing is locked
+// while the gateway is called: the refund is first reserved as pending,
+// which counts against what is left, so two refunds cannot both take
+// the same amount. The donation then moves to refunded once nothing is
+// left, otherwise to partially_refunded. A gateway failure is returned
+// wrapped in ErrGateway.
+func (u *Updater) Refund(gateways *payment.Registry, req RefundRequest) (Result, error) {
+	pending, err := u.reserveRefund(gateways, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	refund, err := pending.Gateway.Refund(pending.OrderID, pending.Amount, req.Reason, pending.Key)
+	if err != nil {
+		if _, err := u.DB.Exec(`UPDATE refunds SET status = 'failed' WHERE id = $1`, pending.ID); err != nil {
+			log.Printf("Failed to release refund %d of donation %s: %v", pending.ID, pending.OrderID, err)
+		}
+		return Result{}, fmt.Errorf("%w: %w", ErrGateway, err)
+	}
+
+	result, err := u.recordRefund(pending, refund.RefundID)
+	if err != nil {
+		// The money has gone back already; the gateway's refund webhook
+		// will bring the status up to date
+		log.Printf("Refund %s of donation %s went through but was not recorded: %v", refund.RefundID, pending.OrderID, err)
+		return Result{}, err
+	}
+
+	log.Printf("Donation %s refunded %s by %s, now %s", pending.OrderID, pending.Amount, req.requester(), result.To)
+	return result, nil
+}
+
+// reserveRefund checks the request against the locked donation and
+// records the refund as pending.
+func (u *Updater) reserveRefund(gateways *payment.Registry, req RefundRequest) (PendingRefund, error) {
+	tx, err := u.DB.Beginx()
+	if err != nil {
+		return PendingRefund{}, err
+	}
+	defer tx.Rollback()
+
+	donation, err := lock(tx, req.OrderID)
+	if err != nil {
+		return PendingRefund{}, err
+	}
+	gateway, ok := gateways.Get(donation.PaymentGateway)
+	if !ok {
+		log.Printf("Donation %s was taken by %s, which is not configured", donation.OrderID, donation.PaymentGateway)
+		return PendingRefund{}, ErrNotRefundable
+	}
+
+	var pending int64
+	query := `SELECT COALESCE(SUM(amount), 0) FROM refunds
+	          WHERE donation_id = $1 AND status = 'pending' AND created_at > NOW() - make_interval(secs => $2)`
+	if err := tx.Get(&pending, query, donation.ID, PendingRefundTTL.Seconds()); err != nil {
+		return PendingRefund{}, err
+	}
+	donation.RefundedAmount += pending
+
+	amount, err := PlanRefund(donation, req)
+	if err != nil {
+		return PendingRefund{}, err
+	}
+
+	refund := PendingRefund{
+		OrderID: donation.OrderID,
+		Gateway: gateway,
+		Amount:  money.Money{Amount: amount, Currency: donation.Currency},
+		Total:   donation.RefundedAmount + amount,
+		Key:     RefundKey(donation, amount),
+	}
+	query = `INSERT INTO refunds (donation_id, amount, reason, requested_by, requested_by_operator, status)
+	         VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), 'pending')
+	         RETURNING id`
+	if err := tx.Get(&refund.ID, query, donation.ID, amount, req.Reason, req.RequestedBy, req.Operator); err != nil {
+		return PendingRefund{}, err
+	}
+
+	return refund, tx.Commit()
+}
+
+// recordRefund marks a pending refund done and books it, unless the
+// donation got there first.
+func (u *Updater) recordRefund(refund PendingRefund, refundID string) (Result, error) {
+	tx, err := u.DB.Beginx()
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	donation, err := lock(tx, refund.OrderID)
+	if err != nil {
+		return Result{}, err
+	}
+
+	query := `UPDATE refunds SET status = 'succeeded', gateway_refund_id = $1 WHERE id = $2`
+	if _, err := tx.Exec(query, refundID, refund.ID); err != nil {
+		return Result{}, err
+	}
+
+	result, ok := DecideRefund(donation, refund.Total)
+	if ok {
+		txn := payment.Transaction{OrderID: donation.OrderID, RawStatus: "refund", RefundedAmount: refund.Total}
+		if err := u.transition(tx, &result, txn, SourceRefund); err != nil {
+			return Result{}, err
+		}
+		result.Changed = true
+	}
+
+	return result, tx.Commit()
+}
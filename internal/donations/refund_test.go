@@ -0,0 +1,180 @@
+package donations
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"my-platform/internal/alerts"
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+)
+
+func TestPlanRefund(t *testing.T) {
+	settled := models.Donation{OrderID: "ORDER-1", CreatorID: 1, Amount: 25000, Status: models.DonationSettled}
+	partly := settled
+	partly.Status, partly.RefundedAmount = models.DonationPartiallyRefunded, 10000
+	refunded := settled
+	refunded.Status, refunded.RefundedAmount = models.DonationRefunded, 25000
+	pending := settled
+	pending.Status = models.DonationPending
+
+	tests := []struct {
+		name     string
+		donation models.Donation
+		req      RefundRequest
+		want     int64
+		err      error
+	}{
+		{"part", settled, RefundRequest{CreatorID: 1, Amount: 10000}, 10000, nil},
+		{"whole", settled, RefundRequest{CreatorID: 1}, 25000, nil},
+		{"the rest", partly, RefundRequest{CreatorID: 1}, 15000, nil},
+		{"more than the rest", partly, RefundRequest{CreatorID: 1, Amount: 15001}, 0, ErrInvalidRefundAmount},
+		{"negative", settled, RefundRequest{CreatorID: 1, Amount: -1}, 0, ErrInvalidRefundAmount},
+		{"another creator's", settled, RefundRequest{CreatorID: 2, Amount: 10000}, 0, ErrNotFound},
+		{"by an operator", settled, RefundRequest{Operator: "ops", Amount: 10000}, 10000, nil},
+		{"already refunded", refunded, RefundRequest{CreatorID: 1}, 0, ErrNotRefundable},
+		{"never paid", pending, RefundRequest{CreatorID: 1}, 0, ErrNotRefundable},
+	}
+	for _, tt := range tests {
+		got, err := PlanRefund(tt.donation, tt.req)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s: PlanRefund = %d, %v, want %d, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+
+	if key := RefundKey(partly, 5000); key != "ORDER-1-R15000" {
+		t.Errorf("RefundKey = %q", key)
+	}
+}
+
+func TestDecideRefund(t *testing.T) {
+	settled := models.Donation{Amount: 25000, Status: models.DonationSettled}
+	partly := settled
+	partly.Status, partly.RefundedAmount = models.DonationPartiallyRefunded, 10000
+	refunded := settled
+	refunded.Status, refunded.RefundedAmount = models.DonationRefunded, 25000
+
+	tests := []struct {
+		name     string
+		donation models.Donation
+		total    int64
+		to       string
+		ok       bool
+	}{
+		{"part", settled, 10000, models.DonationPartiallyRefunded, true},
+		{"whole", settled, 25000, models.DonationRefunded, true},
+		{"more of it", partly, 15000, models.DonationPartiallyRefunded, true},
+		{"the rest", partly, 25000, models.DonationRefunded, true},
+		// The gateway's webhook recorded this refund first
+		{"recorded already", partly, 10000, models.DonationPartiallyRefunded, false},
+		// A larger refund reserved later was recorded first
+		{"overtaken", partly, 5000, models.DonationPartiallyRefunded, false},
+		{"refunded already", refunded, 25000, models.DonationRefunded, false},
+	}
+	for _, tt := range tests {
+		result, ok := DecideRefund(tt.donation, tt.total)
+		if ok != tt.ok || result.To != tt.to || result.From != tt.donation.Status {
+			t.Errorf("%s: DecideRefund = %+v, %v, want to %s, %v", tt.name, result, ok, tt.to, tt.ok)
+		}
+	}
+}
+
+// blockingGateway refunds only when told to, so tests can act while a
+// refund waits on it.
+type blockingGateway struct {
+	called  chan string
+	release chan error
+}
+
+func (g *blockingGateway) Name() string                      { return "blocking" }
+func (g *blockingGateway) SupportsCurrency(code string) bool { return true }
+
+func (g *blockingGateway) CreateCheckout(payment.CheckoutRequest) (*payment.Checkout, error) {
+	return nil, errors.New("not supported")
+}
+
+func (g *blockingGateway) VerifyNotification(http.Header, []byte) (*payment.Transaction, error) {
+	return nil, payment.ErrInvalidNotification
+}
+
+func (g *blockingGateway) FetchStatus(string) (*payment.Transaction, error) {
+	return nil, payment.ErrNotFound
+}
+
+func (g *blockingGateway) Refund(orderID string, amount money.Money, reason, key string) (*payment.Refund, error) {
+	g.called <- key
+	if err := <-g.release; err != nil {
+		return nil, err
+	}
+	return &payment.Refund{RefundID: "RF-" + key, Amount: amount}, nil
+}
+
+// TestRefundDoesNotLockDuringGatewayCall checks the donation can be read
+// and updated while a refund waits on the gateway, that the waiting refund
+// still counts against what is left, and that a failed one frees it again.
+func TestRefundDoesNotLockDuringGatewayCall(t *testing.T) {
+	db := openTestDB(t)
+
+	var userID, creatorID int
+	db.QueryRowx(`INSERT INTO users (email, password_hash) VALUES ('c@example.com', 'x') RETURNING id`).Scan(&userID)
+	db.QueryRowx(`INSERT INTO creators (user_id, username, display_name, widget_secret_token)
+	              VALUES ($1, 'creator', 'Creator', 'token') RETURNING id`, userID).Scan(&creatorID)
+	db.MustExec(`INSERT INTO donations (creator_id, amount, donor_name, order_id, status, payment_gateway)
+	             VALUES ($1, 25000, 'Donor', 'ORDER-1', 'settled', 'blocking')`, creatorID)
+
+	gateway := &blockingGateway{called: make(chan string), release: make(chan error)}
+	gateways := payment.NewRegistry(gateway)
+	updater := NewUpdater(db, alerts.NewQueue(db), ledger.NewLedger(db, ledger.Schedule{Currency: "IDR"}, nil))
+	req := RefundRequest{OrderID: "ORDER-1", Operator: "ops", Reason: "test"}
+
+	type outcome struct {
+		result Result
+		err    error
+	}
+	done := make(chan outcome)
+	refund := func(amount int64) {
+		req := req
+		req.Amount = amount
+		result, err := updater.Refund(gateways, req)
+		done <- outcome{result, err}
+	}
+
+	go refund(20000)
+	if key := <-gateway.called; key != "ORDER-1-R20000" {
+		t.Errorf("key = %q", key)
+	}
+
+	// The row is not locked, and the pending 20000 leaves only 5000
+	tx := db.MustBegin()
+	if _, err := lock(tx, "ORDER-1"); err != nil {
+		t.Fatalf("lock while the gateway is called: %v", err)
+	}
+	tx.Rollback()
+	if _, err := updater.Refund(gateways, RefundRequest{OrderID: "ORDER-1", Operator: "ops", Reason: "test", Amount: 10000}); !errors.Is(err, ErrInvalidRefundAmount) {
+		t.Errorf("refund over the pending one: err = %v, want ErrInvalidRefundAmount", err)
+	}
+
+	gateway.release <- errors.New("gateway down")
+	if o := <-done; !errors.Is(o.err, ErrGateway) {
+		t.Fatalf("failed refund: err = %v, want ErrGateway", o.err)
+	}
+
+	go refund(0)
+	if key := <-gateway.called; key != "ORDER-1-R25000" {
+		t.Errorf("key after the failure = %q", key)
+	}
+	gateway.release <- nil
+	o := <-done
+	if o.err != nil || !o.result.Changed || o.result.To != models.DonationRefunded || o.result.Donation.RefundedAmount != 25000 {
+		t.Fatalf("refund = %+v, %v", o.result, o.err)
+	}
+
+	var statuses []string
+	db.Select(&statuses, `SELECT status FROM refunds ORDER BY id`)
+	if len(statuses) != 2 || statuses[0] != models.RefundFailed || statuses[1] != models.RefundSucceeded {
+		t.Errorf("refund statuses = %v", statuses)
+	}
+}
@@ -0,0 +1,229 @@
+// Package donations moves donations through their status state machine.
+// Every status change, whether reported by a webhook or found by polling
+// the gateway, goes through Updater.Apply.
+package donations
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/alerts"
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+)
+
+// Sources recorded in the status history.
+const (
+	SourceWebhook    = "webhook"
+	SourceReconciler = "reconciler"
+	SourceRefund     = "refund"
+)
+
+// ErrNotFound means no donation has the transaction's order ID.
+var ErrNotFound = errors.New("donation not found")
+
+// Result describes what Apply did with a gateway status.
+type Result struct {
+	Donation models.Donation
+	From     string
+	To       string
+	// Changed is false for duplicates and for transitions the state
+	// machine does not allow, e.g. a late "pending" after settlement.
+	Changed bool
+}
+
+type Updater struct {
+	DB     *sqlx.DB
+	Alerts *alerts.Queue
+	Ledger *ledger.Ledger
+}
+
+func NewUpdater(db *sqlx.DB, queue *alerts.Queue, books *ledger.Ledger) *Updater {
+	return &Updater{DB: db, Alerts: queue, Ledger: books}
+}
+
+// StatusFromGateway maps a gateway status onto a donation status.
+func StatusFromGateway(status payment.Status) string {
+	switch status {
+	case payment.StatusPaid:
+		return models.DonationSettled
+	case payment.StatusChallenge:
+		return models.DonationChallenge
+	case payment.StatusDenied:
+		return models.DonationDenied
+	case payment.StatusCancelled:
+		return models.DonationCancelled
+	case payment.StatusExpired:
+		return models.DonationExpired
+	case payment.StatusFailed:
+		return models.DonationFailed
+	case payment.StatusRefunded:
+		return models.DonationRefunded
+	case payment.StatusPartiallyRefunded:
+		return models.DonationPartiallyRefunded
+	default:
+		return models.DonationPending
+	}
+}
+
+// donationColumns are the donation columns Apply and Refund work with.
+const donationColumns = `id, creator_id, amount, currency, refunded_amount, donor_name, donor_message,
+	status, media_type, media_url, media_start_seconds, media_end_seconds, order_id, payment_gateway`
+
+// lock selects the donation for update, holding its row until tx ends.
+func lock(tx *sqlx.Tx, orderID string) (models.Donation, error) {
+	var donation models.Donation
+	query := `SELECT ` + donationColumns + ` FROM donations WHERE order_id = $1 FOR UPDATE`
+	if err := tx.Get(&donation, query, orderID); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Donation{}, ErrNotFound
+		}
+		return models.Donation{}, err
+	}
+	return donation, nil
+}
+
+// Apply moves the donation to the status the gateway reported. A
+// settlement is refused, leaving the status as it is, unless the gateway's
+// gross amount and currency match the donation's. The
+// donation row is locked for the whole transaction, so when a gateway
+// retries a notification concurrently only one call sees the old status and
+// wins; the rest see the new status and change nothing. The status update,
+// its history row and, on settlement, the queued alert and its outbox
+// message are committed together; the outbox dispatcher broadcasts it.
+func (u *Updater) Apply(txn payment.Transaction, source string) (Result, error) {
+	tx, err := u.DB.Beginx()
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	donation, err := lock(tx, txn.OrderID)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, ok := Decide(donation, txn, source)
+	if !ok {
+		return result, nil
+	}
+	if err := u.transition(tx, &result, txn, source); err != nil {
+		return Result{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Result{}, err
+	}
+
+	result.Changed = true
+	log.Printf("Donation %s moved %s -> %s (%s)", donation.OrderID, result.From, result.To, source)
+
+	return result, nil
+}
+
+// Decide works out what Apply does with a gateway status for the donation
+// as it stands. It reports false if nothing is to change: for duplicates,
+// for transitions the state machine does not allow, and for a settlement
+// whose gross amount or currency differs from the donation's.
+func Decide(donation models.Donation, txn payment.Transaction, source string) (Result, bool) {
+	result := Result{Donation: donation, From: donation.Status, To: StatusFromGateway(txn.Status)}
+
+	// A repeated partial refund status only counts if more was refunded
+	if result.From == result.To &&
+		(result.To != models.DonationPartiallyRefunded || txn.RefundedAmount <= donation.RefundedAmount) {
+		return result, false
+	}
+	if !models.CanTransitionDonation(result.From, result.To) {
+		log.Printf("Ignoring %s status %q for donation %s: %s -> %s is not allowed",
+			source, txn.RawStatus, donation.OrderID, result.From, result.To)
+		return result, false
+	}
+	// Only book what the donor actually paid; a mismatch needs a person
+	charged := money.Money{Amount: donation.Amount, Currency: donation.Currency}
+	if result.To == models.DonationSettled && txn.Gross != charged {
+		log.Printf("SECURITY: refusing to settle donation %s: %s reports %s paid, expected %s",
+			donation.OrderID, source, txn.Gross, charged)
+		result.To = result.From
+		return result, false
+	}
+	return result, true
+}
+
+// settle fixes the donation's fees and books it to the creator.
+func (u *Updater) settle(tx *sqlx.Tx, donation *models.Donation) error {
+	gross := money.Money{Amount: donation.Amount, Currency: donation.Currency}
+	fees, err := u.Ledger.FeesFor(tx, donation.CreatorID, donation.PaymentGateway, gross)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE donations SET platform_fee = $1, gateway_fee = $2, net_amount = $3 WHERE id = $4`
+	if _, err := tx.Exec(query, fees.Platform, fees.Gateway, fees.Net, donation.ID); err != nil {
+		return err
+	}
+	donation.PlatformFee = &fees.Platform
+	donation.GatewayFee = &fees.Gateway
+	donation.NetAmount = &fees.Net
+
+	return ledger.RecordDonation(ledger.Tx{Tx: tx}, *donation, fees)
+}
+
+// transition writes result.To to the locked donation along with its
+// history row and side effects: settlement books the fees and queues
+// and publishes the alert, a refund debits the creator and, if full,
+// withdraws the alert if it has not played yet.
+func (u *Updater) transition(tx *sqlx.Tx, result *Result, txn payment.Transaction, source string) error {
+	donation := result.Donation
+
+	query := `UPDATE donations
+	          SET status = $1,
+	              payment_gateway_tx_id = COALESCE(NULLIF($2, ''), payment_gateway_tx_id),
+	              refunded_amount = CASE WHEN $1 = 'refunded' THEN amount
+	                                     ELSE GREATEST(refunded_amount, $3) END
+	          WHERE id = $4
+	          RETURNING refunded_amount`
+	if err := tx.Get(&result.Donation.RefundedAmount, query, result.To, txn.TransactionID, txn.RefundedAmount, donation.ID); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO donation_status_history
+	           (donation_id, from_status, to_status, source, gateway_status, fraud_status)
+	         VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.Exec(query, donation.ID, result.From, result.To, source, txn.RawStatus, txn.FraudStatus)
+	if err != nil {
+		return err
+	}
+
+	result.Donation.Status = result.To
+
+	if refunded := result.Donation.RefundedAmount - donation.RefundedAmount; refunded > 0 {
+		ref := ledger.RefundReference(donation.OrderID, result.Donation.RefundedAmount)
+		if err := ledger.RecordRefund(ledger.Tx{Tx: tx}, donation, ref, refunded); err != nil {
+			return err
+		}
+	}
+
+	switch result.To {
+	case models.DonationSettled:
+		if err := u.settle(tx, &result.Donation); err != nil {
+			return err
+		}
+		alert, err := u.Alerts.Enqueue(tx, result.Donation)
+		if err != nil {
+			return err
+		}
+		if err := alerts.Publish(tx, alert); err != nil {
+			return err
+		}
+	case models.DonationRefunded:
+		if err := u.Alerts.Withdraw(tx, donation.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
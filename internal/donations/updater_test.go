@@ -0,0 +1,189 @@
+package donations
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"my-platform/internal/alerts"
+	"my-platform/internal/ledger"
+	"my-platform/internal/migrate"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+)
+
+// openTestDB connects to TEST_DATABASE_DSN and migrates a throwaway
+// schema, which is dropped when the test ends.
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set, skipping PostgreSQL test")
+	}
+
+	admin, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := fmt.Sprintf("donations_test_%d", time.Now().UnixNano())
+	admin.MustExec("CREATE SCHEMA " + schema)
+	t.Cleanup(func() {
+		admin.MustExec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_DSN must be a URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db, err := sqlx.Connect("pgx", u.String())
+	if err != nil {
+		t.Fatalf("connect to test schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// TestApplySettlesExactlyOnce replays the same settlement notification
+// concurrently, like Midtrans retrying a webhook, and checks that only one
+// call wins and exactly one alert is queued and published to the outbox.
+func TestApplySettlesExactlyOnce(t *testing.T) {
+	db := openTestDB(t)
+
+	var userID, creatorID int
+	db.QueryRowx(`INSERT INTO users (email, password_hash) VALUES ('c@example.com', 'x') RETURNING id`).Scan(&userID)
+	db.QueryRowx(`INSERT INTO creators (user_id, username, display_name, widget_secret_token)
+	              VALUES ($1, 'creator', 'Creator', 'token') RETURNING id`, userID).Scan(&creatorID)
+	db.MustExec(`INSERT INTO donations (creator_id, amount, donor_name, order_id, status)
+	             VALUES ($1, 25000, 'Donor', 'ORDER-1', 'pending')`, creatorID)
+
+	updater := NewUpdater(db, alerts.NewQueue(db), ledger.NewLedger(db, ledger.Schedule{PercentBPS: 500, Currency: "IDR"}, nil))
+	txn := payment.Transaction{
+		OrderID:       "ORDER-1",
+		TransactionID: "TX-1",
+		Status:        payment.StatusPaid,
+		RawStatus:     "settlement",
+		Gross:         money.Money{Amount: 25000, Currency: "IDR"},
+	}
+
+	const attempts = 20
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners int
+	)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			result, err := updater.Apply(txn, SourceWebhook)
+			if err != nil {
+				t.Errorf("Apply: %v", err)
+				return
+			}
+			if result.Changed {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if winners != 1 {
+		t.Errorf("winners = %d, want 1", winners)
+	}
+
+	var queued, published, history int
+	db.Get(&queued, `SELECT COUNT(*) FROM alerts`)
+	db.Get(&published, `SELECT COUNT(*) FROM outbox WHERE topic = 'donation.alert'`)
+	db.Get(&history, `SELECT COUNT(*) FROM donation_status_history`)
+	if queued != 1 {
+		t.Errorf("queued alerts = %d, want 1", queued)
+	}
+	if published != 1 {
+		t.Errorf("outbox messages = %d, want 1", published)
+	}
+
+	var balance int64
+	db.Get(&balance, `SELECT SUM(amount) FROM ledger_entries WHERE account = $1`, ledger.CreatorAccount(creatorID))
+	if balance != 25000-1250 {
+		t.Errorf("creator balance = %d, want %d", balance, 25000-1250)
+	}
+
+	var net int64
+	db.Get(&net, `SELECT net_amount FROM donations WHERE order_id = 'ORDER-1'`)
+	if net != balance {
+		t.Errorf("net = %d, want the creator balance %d", net, balance)
+	}
+	if history != 1 {
+		t.Errorf("status history rows = %d, want 1", history)
+	}
+
+	var status string
+	db.Get(&status, `SELECT status FROM donations WHERE order_id = 'ORDER-1'`)
+	if status != models.DonationSettled {
+		t.Errorf("status = %q, want %q", status, models.DonationSettled)
+	}
+}
+
+// TestApplyRefusesMismatchedGross checks that a settlement for less, or in
+// another currency, than the donation leaves it pending and books nothing.
+func TestApplyRefusesMismatchedGross(t *testing.T) {
+	db := openTestDB(t)
+
+	var userID, creatorID int
+	db.QueryRowx(`INSERT INTO users (email, password_hash) VALUES ('c@example.com', 'x') RETURNING id`).Scan(&userID)
+	db.QueryRowx(`INSERT INTO creators (user_id, username, display_name, widget_secret_token)
+	              VALUES ($1, 'creator', 'Creator', 'token') RETURNING id`, userID).Scan(&creatorID)
+	db.MustExec(`INSERT INTO donations (creator_id, amount, donor_name, order_id, status)
+	             VALUES ($1, 25000, 'Donor', 'ORDER-1', 'pending')`, creatorID)
+
+	updater := NewUpdater(db, alerts.NewQueue(db), ledger.NewLedger(db, ledger.Schedule{PercentBPS: 500, Currency: "IDR"}, nil))
+	for _, gross := range []money.Money{
+		{Amount: 1000, Currency: "IDR"},
+		{Amount: 25000, Currency: "USD"},
+		{},
+	} {
+		result, err := updater.Apply(payment.Transaction{
+			OrderID:   "ORDER-1",
+			Status:    payment.StatusPaid,
+			RawStatus: "settlement",
+			Gross:     gross,
+		}, SourceWebhook)
+		if err != nil {
+			t.Fatalf("Apply(%s): %v", gross, err)
+		}
+		if result.Changed || result.To != models.DonationPending {
+			t.Errorf("Apply(%s) = %+v, want unchanged", gross, result)
+		}
+	}
+
+	var status string
+	var entries int
+	db.Get(&status, `SELECT status FROM donations WHERE order_id = 'ORDER-1'`)
+	db.Get(&entries, `SELECT COUNT(*) FROM ledger_entries`)
+	if status != models.DonationPending || entries != 0 {
+		t.Errorf("status = %q with %d ledger entries, want pending and none", status, entries)
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"my-platform/internal/mailer"
+	"my-platform/internal/models"
+	"my-platform/internal/repository"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" binding:"required,email"`
+	CurrentPassword string `json:"current_password" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// deletionPending reports whether a deleted account can still be restored.
+func (h *AuthHandler) deletionPending(user models.User, now time.Time) bool {
+	return user.PurgedAt == nil && now.Before(user.DeletedAt.Add(h.DeletionGrace))
+}
+
+// currentUser loads the logged-in user and checks their password, writing
+// the error response if that fails.
+func (h *AuthHandler) currentUser(c *gin.Context, password string) (models.User, bool) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return models.User{}, false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect."})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ChangePassword sets a new password and signs the user out everywhere
+// except this session.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c, req.CurrentPassword)
+	if !ok {
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current one."})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error, please try again."})
+		return
+	}
+
+	if err := h.Users.ChangePassword(user.ID, string(passwordHash), c.GetString("sessionID")); err != nil {
+		log.Println("Failed to change password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed. Other sessions have been signed out."})
+}
+
+// ChangeEmail moves the account to a new address, which has to be verified
+// again. The old address is told about the change.
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c, req.CurrentPassword)
+	if !ok {
+		return
+	}
+	if req.NewEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "That is already your email."})
+		return
+	}
+
+	err := h.Users.ChangeEmail(user.ID, req.NewEmail)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered."})
+		return
+	} else if err != nil {
+		log.Println("Failed to change email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = req.NewEmail
+	user.EmailVerifiedAt = nil
+	if err := h.sendVerificationEmail(user); err != nil {
+		// The change stands; the user can ask for the link again
+		log.Println("Failed to send verification email:", err)
+	}
+	err = h.Mailer.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("The email address of your account was changed to %s.\n\n"+
+			"If you did not do this, reset your password and contact support right away.\n", req.NewEmail),
+	})
+	if err != nil {
+		log.Println("Failed to send email change notice:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed. Check your inbox to verify the new address."})
+}
+
+// DeleteAccount schedules the account for deletion and signs it out
+// everywhere. Its donation page goes offline at once; its personal data is
+// purged once the grace period ends, until when RestoreAccount undoes it.
+// A creator who is still owed money must be paid out first.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c, req.Password)
+	if !ok {
+		return
+	}
+
+	user, err := h.Users.ScheduleDeletion(user.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is already scheduled for deletion."})
+		return
+	} else if errors.Is(err, repository.ErrMoneyOwed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are still owed money. Wait for pending payouts and withdraw your balance, or contact support if it is below the minimum payout, before deleting your account."})
+		return
+	} else if err != nil {
+		log.Println("Failed to delete account:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Account scheduled for deletion. It can be restored until it is purged.",
+		"purge_after": user.DeletedAt.Add(h.DeletionGrace),
+	})
+}
+
+// RestoreAccount cancels a pending deletion. Deleted accounts cannot log
+// in, so it takes the email and password like Login.
+func (h *AuthHandler) RestoreAccount(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetByEmail(req.Email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Database error on restore:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password."})
+		return
+	}
+
+	if user.DeletedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is not scheduled for deletion."})
+		return
+	}
+	if !h.deletionPending(user, time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password."})
+		return
+	}
+
+	if err := h.Users.CancelDeletion(user.ID); err != nil {
+		log.Println("Failed to restore account:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account restored. You can log in again."})
+}
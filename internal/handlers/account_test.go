@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"my-platform/internal/models"
+	"my-platform/internal/repository"
+)
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	laptop := s.login("alice")
+	phone := s.login("alice")
+
+	wrong := ChangePasswordRequest{CurrentPassword: "wrong password", NewPassword: "battery staple"}
+	if w := s.do("POST", "/api/me/password", laptop.Token, wrong, nil); w.Code != http.StatusForbidden {
+		t.Errorf("wrong current password: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	short := ChangePasswordRequest{CurrentPassword: "correct horse", NewPassword: "short"}
+	if w := s.do("POST", "/api/me/password", laptop.Token, short, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("short password: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	change := ChangePasswordRequest{CurrentPassword: "correct horse", NewPassword: "battery staple"}
+	if w := s.do("POST", "/api/me/password", laptop.Token, change, nil); w.Code != http.StatusOK {
+		t.Fatalf("change: status = %d: %s", w.Code, w.Body)
+	}
+
+	// This session stays signed in, the others are signed out
+	if w := s.do("GET", "/api/me", laptop.Token, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("current session: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := s.do("GET", "/api/me", phone.Token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("other session: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w := s.do("POST", "/api/auth/login", "", LoginRequest{Email: "alice@example.com", Password: "battery staple"}, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("login with new password: status = %d", w.Code)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.register("bob", false)
+	token := s.login("alice").Token
+
+	taken := ChangeEmailRequest{NewEmail: "bob@example.com", CurrentPassword: "correct horse"}
+	if w := s.do("POST", "/api/me/email", token, taken, nil); w.Code != http.StatusConflict {
+		t.Errorf("taken email: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	wrong := ChangeEmailRequest{NewEmail: "alice@new.example.com", CurrentPassword: "wrong password"}
+	if w := s.do("POST", "/api/me/email", token, wrong, nil); w.Code != http.StatusForbidden {
+		t.Errorf("wrong password: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	change := ChangeEmailRequest{NewEmail: "alice@new.example.com", CurrentPassword: "correct horse"}
+	if w := s.do("POST", "/api/me/email", token, change, nil); w.Code != http.StatusOK {
+		t.Fatalf("change: status = %d: %s", w.Code, w.Body)
+	}
+
+	var profile ProfileResponse
+	s.do("GET", "/api/me", token, nil, &profile)
+	if profile.Email != "alice@new.example.com" || profile.EmailVerified {
+		t.Errorf("profile email = %q, verified = %v", profile.Email, profile.EmailVerified)
+	}
+	if notices := s.mail.sentTo("alice@example.com"); notices[len(notices)-1] != "Your email address was changed" {
+		t.Errorf("old address got %q", notices)
+	}
+
+	// The new address has to be verified before the page is back up
+	if w := s.do("GET", "/api/creators/alice", "", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("page before re-verification: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	s.do("GET", "/api/auth/verify?token="+s.mail.lastToken(t), "", nil, nil)
+	if w := s.do("GET", "/api/creators/alice", "", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("page after re-verification: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestUpdateMyProfileUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.register("bob", false)
+	token := s.login("alice").Token
+
+	tests := []struct {
+		name   string
+		update map[string]any
+		want   int
+	}{
+		{"taken username", map[string]any{"username": "bob"}, http.StatusConflict},
+		{"short username", map[string]any{"username": "al"}, http.StatusBadRequest},
+		{"username with slash", map[string]any{"username": "al/ice"}, http.StatusBadRequest},
+		{"blank display name", map[string]any{"display_name": "   "}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := s.do("PATCH", "/api/me", token, tt.update, nil); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	var profile ProfileResponse
+	w := s.do("PATCH", "/api/me", token, map[string]any{"username": "alice_live", "display_name": " Alice "}, &profile)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename: status = %d: %s", w.Code, w.Body)
+	}
+	if profile.Username != "alice_live" || profile.DisplayName != "Alice" {
+		t.Errorf("profile = %q, %q", profile.Username, profile.DisplayName)
+	}
+	if w := s.do("GET", "/api/creators/alice", "", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("old username: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.do("GET", "/api/creators/alice_live", "", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("new username: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDeleteAndRestoreAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+	credentials := LoginRequest{Email: "alice@example.com", Password: "correct horse"}
+
+	if w := s.do("DELETE", "/api/me", token, DeleteAccountRequest{Password: "wrong password"}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("wrong password: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := s.do("POST", "/api/auth/restore", "", credentials, nil); w.Code != http.StatusConflict {
+		t.Errorf("restore before delete: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	if w := s.do("DELETE", "/api/me", token, DeleteAccountRequest{Password: "correct horse"}, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/me", token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("session after delete: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := s.do("GET", "/api/creators/alice", "", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("page after delete: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000}, nil); w.Code != http.StatusNotFound {
+		t.Errorf("donate after delete: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.do("POST", "/api/auth/login", "", credentials, nil); w.Code != http.StatusForbidden {
+		t.Errorf("login after delete: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w := s.do("POST", "/api/auth/restore", "", credentials, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", w.Code, w.Body)
+	}
+	s.login("alice")
+	if w := s.do("GET", "/api/creators/alice", "", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("page after restore: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDeleteAccountRefusedWhileOwedMoney(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+
+	s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000}, nil)
+	s.repos.Donations.(*repository.MemoryDonations).Settle(1)
+
+	if w := s.do("DELETE", "/api/me", token, DeleteAccountRequest{Password: "correct horse"}, nil); w.Code != http.StatusConflict {
+		t.Errorf("delete with a balance: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := s.do("GET", "/api/me", token, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("session after refused delete: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// TestPurgedAccountIsGone checks that after the grace period the account
+// can neither log in nor be restored, and its email and username are free.
+func TestPurgedAccountIsGone(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+	credentials := LoginRequest{Email: "alice@example.com", Password: "correct horse"}
+
+	s.do("DELETE", "/api/me", token, DeleteAccountRequest{Password: "correct horse"}, nil)
+	if n, err := s.repos.Users.PurgeDeleted(time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("PurgeDeleted = %d, %v, want 1", n, err)
+	}
+
+	if w := s.do("POST", "/api/auth/login", "", credentials, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("login after purge: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := s.do("POST", "/api/auth/restore", "", credentials, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("restore after purge: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	s.register("alice", false)
+}
+
+// TestPurgeIgnoresSquattedPlaceholder checks that a username that looks
+// like a purged one cannot be taken, and that one taken before it was
+// reserved does not stop the purge.
+func TestPurgeIgnoresSquattedPlaceholder(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+
+	for _, username := range []string{"deleted-1", "Deleted-1"} {
+		req := RegisterRequest{Email: "squatter@example.com", Password: "correct horse", Username: username, DisplayName: "S"}
+		if w := s.do("POST", "/api/auth/register", "", req, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("register %q: status = %d, want %d", username, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := s.do("PATCH", "/api/me", token, map[string]any{"username": "deleted-1"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("rename to deleted-1: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	_, err := s.repos.Users.CreateWithCreator(
+		models.User{Email: "deleted-1@deleted.invalid"},
+		models.Creator{Username: "deleted-1", DisplayName: "Squatter", WidgetSecretToken: "squatter"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.do("DELETE", "/api/me", token, DeleteAccountRequest{Password: "correct horse"}, nil)
+	if n, err := s.repos.Users.PurgeDeleted(time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("PurgeDeleted = %d, %v, want 1", n, err)
+	}
+}
@@ -1,35 +1,71 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
 
+	"my-platform/internal/mailer"
 	"my-platform/internal/models" // Import our models package
+	"my-platform/internal/repository"
 )
 
-// AuthHandler will hold the database connection
+// AuthHandler will hold the user and session repositories
 type AuthHandler struct {
-	DB        *sqlx.DB
-	JwtSecret string
+	Users           repository.UserRepo
+	Sessions        repository.SessionRepo
+	Mailer          mailer.Mailer
+	JwtSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// PublicBaseURL is where this API is reachable, used for emailed links
+	PublicBaseURL string
+	// FrontendBaseURL is where the web app is reachable, used for emailed links
+	FrontendBaseURL string
+	// TOTPIssuer is the account name shown in authenticator apps
+	TOTPIssuer string
+	// DeletionGrace is how long a deleted account can be restored
+	DeletionGrace time.Duration
 }
 
-// NewAuthHandler creates a new handler with the DB connection
-func NewAuthHandler(db *sqlx.DB, jwtSecret string) *AuthHandler {
-	return &AuthHandler{DB: db, JwtSecret: jwtSecret}
+// AuthConfig holds the settings AuthHandler reads from the config file
+type AuthConfig struct {
+	JwtSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	PublicBaseURL   string
+	FrontendBaseURL string
+	TOTPIssuer      string
+	DeletionGrace   time.Duration
+}
+
+// NewAuthHandler creates a new handler with the repositories
+func NewAuthHandler(users repository.UserRepo, sessions repository.SessionRepo, m mailer.Mailer, cfg AuthConfig) *AuthHandler {
+	return &AuthHandler{
+		Users:           users,
+		Sessions:        sessions,
+		Mailer:          m,
+		JwtSecret:       cfg.JwtSecret,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		PublicBaseURL:   strings.TrimRight(cfg.PublicBaseURL, "/"),
+		FrontendBaseURL: strings.TrimRight(cfg.FrontendBaseURL, "/"),
+		TOTPIssuer:      cfg.TOTPIssuer,
+		DeletionGrace:   cfg.DeletionGrace,
+	}
 }
 
 // RegisterRequest defines the JSON struct we expect from the client
 type RegisterRequest struct {
 	Email       string `json:"email" binding:"required,email"`
 	Password    string `json:"password" binding:"required,min=8"`
-	Username    string `json:"username" binding:"required,min=3"`
+	Username    string `json:"username" binding:"required,min=3,max=30"`
 	DisplayName string `json:"display_name" binding:"required"`
 }
 
@@ -43,6 +79,10 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
+	if err := validateUsername(req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
 
 	// 2. Hash the password
 	// We MUST NOT store the plain-text password
@@ -53,60 +93,41 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// 3. TODO: Generate a unique widget_secret_token
-	// For now, we'll use a simple placeholder.
-	// Later, we'll use a secure random string (e.g., from a UUID library)
-	widgetToken := "temp_token_" + req.Username
-
-	// 4. Create the user and creator profile in a database transaction
-	// A transaction ensures that *both* tables are updated, or neither are.
-	tx, err := h.DB.Beginx()
-	if err != nil {
-		log.Println("Failed to begin transaction:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
-		return
-	}
-
-	// Ensure the transaction is rolled back on error
-	defer tx.Rollback()
-
-	// Step 4a: Insert into 'users' table
-	var newUser models.User
-	userQuery := `INSERT INTO users (email, password_hash) 
-	              VALUES ($1, $2) 
-				  			RETURNING id, email, created_at, updated_at`
-
-	err = tx.Get(&newUser, userQuery, req.Email, string(passwordHash))
+	// 3. Generate the widget_secret_token used in the overlay URL
+	// It must be unguessable, since it is the only credential the overlay has.
+	widgetToken, err := generateSecureToken(widgetTokenBytes)
 	if err != nil {
-		log.Println("Failed to insert new user:", err)
-		// This will fail if the email is already taken
-		c.JSON(http.StatusConflict, gin.H{"error": "Email or username may already be in use."})
+		log.Println("Widget token generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error, please try again."})
 		return
 	}
 
-	// Step 4b: Insert into 'creators' table
-	creatorQuery := `INSERT INTO creators (user_id, username, display_name, widget_secret_token)
-	                 VALUES ($1, $2, $3, $4)`
-
-	_, err = tx.Exec(creatorQuery, newUser.ID, req.Username, req.DisplayName, widgetToken)
+	// 4. Create the user and creator profile together
+	newUser, err := h.Users.CreateWithCreator(
+		models.User{Email: req.Email, PasswordHash: string(passwordHash)},
+		models.Creator{Username: req.Username, DisplayName: req.DisplayName, WidgetSecretToken: widgetToken},
+	)
 	if err != nil {
-		log.Println("Failed to insert new creator profile:", err)
-		// This will fail if the username is already taken
-		c.JSON(http.StatusConflict, gin.H{"error": "Email or username may already be in use."})
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email or username may already be in use."})
+			return
+		}
+		log.Println("Failed to create user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
 		return
 	}
 
-	// 5. Commit the transaction
-	if err := tx.Commit(); err != nil {
-		log.Println("Failed to commit transaction:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
-		return
+	// 5. Send the verification link
+	// The account stays unverified (no donation page, no payouts) until the
+	// link is opened. A failed send is not fatal, the user can ask again.
+	if err := h.sendVerificationEmail(newUser); err != nil {
+		log.Println("Failed to send verification email:", err)
 	}
 
 	// 6. Send a successful response
 	// We don't send the password hash back, just a success message.
 	c.JSON(http.StatusCreated, gin.H{
-		"message":  "User created successfully.",
+		"message":  "User created successfully. Check your email to verify your account.",
 		"user_id":  newUser.ID,
 		"email":    newUser.Email,
 		"username": req.Username,
@@ -118,13 +139,16 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-func (h *AuthHandler) createJWT(user models.User) (string, error) {
+// createJWT issues a short-lived access token tied to a session, so that
+// revoking the session also invalidates the token
+func (h *AuthHandler) createJWT(user models.User, sessionID string) (string, error) {
 	// Create the claims
 	claims := jwt.MapClaims{
 		"sub":   user.ID,
+		"sid":   sessionID,
 		"email": user.Email,
 		"iat":   time.Now().Unix(),
-		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(),
+		"exp":   time.Now().Add(h.AccessTokenTTL).Unix(),
 	}
 
 	// Create token
@@ -147,12 +171,9 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	query := `SELECT id, email, password_hash FROM users WHERE email = $1`
-	err := h.DB.Get(&user, query, req.Email)
-
+	user, err := h.Users.GetByEmail(req.Email)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password."})
 			return
 		}
@@ -162,20 +183,57 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-  // Compare stored passwordHash with the user entered password
+	// Compare stored passwordHash with the user entered password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password."})
 		return
 	}
 
-	tokenString, err := h.createJWT(user)
+	// A deleted account has to be restored first; once its grace period
+	// is over it is as good as gone
+	if user.DeletedAt != nil {
+		if !h.deletionPending(user, time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password."})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       "Account is scheduled for deletion.",
+			"purge_after": user.DeletedAt.Add(h.DeletionGrace),
+		})
+		return
+	}
+
+	// With two-factor enabled the password only earns a short-lived
+	// challenge, which LoginTwoFactor exchanges for the real token
+	if user.TOTPEnabledAt != nil {
+		challenge, err := h.signPurposeToken(purposeTwoFactor, user, challengeTTL)
+		if err != nil {
+			log.Println("Failed to create 2FA challenge:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor code required.",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		return
+	}
+
+	tokenString, refreshToken, err := h.startSession(c, user)
 	if err != nil {
-		log.Println("Failed to create JWT:", err)
+		log.Println("Failed to start session:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
 		return
 	}
 
-  // Response
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful.", "token": tokenString})
+	// Response
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful.",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+	})
 }
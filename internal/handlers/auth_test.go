@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRejectsTakenEmailAndUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+
+	tests := []struct {
+		name string
+		req  RegisterRequest
+	}{
+		{"email", RegisterRequest{Email: "alice@example.com", Password: "correct horse", Username: "alice2", DisplayName: "A"}},
+		{"username", RegisterRequest{Email: "other@example.com", Password: "correct horse", Username: "alice", DisplayName: "A"}},
+	}
+	for _, tt := range tests {
+		if w := s.do("POST", "/api/auth/register", "", tt.req, nil); w.Code != http.StatusConflict {
+			t.Errorf("taken %s: status = %d, want %d", tt.name, w.Code, http.StatusConflict)
+		}
+	}
+}
+
+// TestRegisterRejectsBadUsername checks that registration validates the
+// username like a profile update, so every page can be reached by URL.
+func TestRegisterRejectsBadUsername(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, username := range []string{"al/ice", "al ice", "alice?", "a", strings.Repeat("a", 31)} {
+		req := RegisterRequest{Email: "alice@example.com", Password: "correct horse", Username: username, DisplayName: "A"}
+		if w := s.do("POST", "/api/auth/register", "", req, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", username, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+
+	w := s.do("POST", "/api/auth/login", "", LoginRequest{Email: "alice@example.com", Password: "wrong password"}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = s.do("POST", "/api/auth/login", "", LoginRequest{Email: "nobody@example.com", Password: "correct horse"}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown email: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	tokens := s.login("alice")
+	var profile ProfileResponse
+	if w := s.do("GET", "/api/me", tokens.Token, nil, &profile); w.Code != http.StatusOK {
+		t.Fatalf("GET /me: status = %d: %s", w.Code, w.Body)
+	}
+	if profile.Username != "alice" || profile.Email != "alice@example.com" || profile.EmailVerified {
+		t.Errorf("profile = %+v", profile)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+
+	var profile ProfileResponse
+	s.do("GET", "/api/me", s.login("alice").Token, nil, &profile)
+	if !profile.EmailVerified {
+		t.Error("email not verified after opening the link")
+	}
+
+	if w := s.do("GET", "/api/auth/verify?token=garbage", "", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad token: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestRefreshRotatesAndDetectsReuse replays a refresh token after it was
+// rotated, which must revoke the whole session.
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	first := s.login("alice")
+
+	var second loginResponse
+	w := s.do("POST", "/api/auth/refresh", "", RefreshRequest{RefreshToken: first.RefreshToken}, &second)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status = %d: %s", w.Code, w.Body)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	w = s.do("POST", "/api/auth/refresh", "", RefreshRequest{RefreshToken: first.RefreshToken}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("reused token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// The session is gone, so neither the new refresh nor access token work
+	w = s.do("POST", "/api/auth/refresh", "", RefreshRequest{RefreshToken: second.RefreshToken}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after reuse: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := s.do("GET", "/api/me", second.Token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("access after reuse: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLogoutAndRevokeSession(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	laptop := s.login("alice")
+	phone := s.login("alice")
+
+	var sessions []SessionResponse
+	s.do("GET", "/api/me/sessions", laptop.Token, nil, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %d, want 2", len(sessions))
+	}
+	var phoneID string
+	for _, session := range sessions {
+		if !session.Current {
+			phoneID = session.ID
+		}
+	}
+
+	if w := s.do("DELETE", "/api/me/sessions/"+phoneID, laptop.Token, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/me", phone.Token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := s.do("DELETE", "/api/me/sessions/"+phoneID, laptop.Token, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoke twice: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	s.do("POST", "/api/auth/logout", "", RefreshRequest{RefreshToken: laptop.RefreshToken}, nil)
+	if w := s.do("GET", "/api/me", laptop.Token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	tokens := s.login("alice")
+
+	s.do("POST", "/api/auth/forgot-password", "", ForgotPasswordRequest{Email: "alice@example.com"}, nil)
+	resetToken := s.mail.lastToken(t)
+
+	reset := ResetPasswordRequest{Token: resetToken, NewPassword: "battery staple"}
+	if w := s.do("POST", "/api/auth/reset-password", "", reset, nil); w.Code != http.StatusOK {
+		t.Fatalf("reset: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/api/auth/reset-password", "", reset, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("reset twice: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Every session is signed out and only the new password works
+	if w := s.do("GET", "/api/me", tokens.Token, nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("old session: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w := s.do("POST", "/api/auth/login", "", LoginRequest{Email: "alice@example.com", Password: "battery staple"}, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("login with new password: status = %d", w.Code)
+	}
+}
@@ -1,41 +1,89 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"log"
 	"my-platform/internal/models"
+	"my-platform/internal/money"
+	ws "my-platform/internal/websocket"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/repository"
 )
 
 type CreatorHandler struct {
-	DB *sqlx.DB
+	Users          repository.UserRepo
+	Creators       repository.CreatorRepo
+	Donations      repository.DonationRepo
+	Hub            *ws.Hub
+	OverlayBaseURL string
 }
 
 type ProfileResponse struct {
-	UserID            int    `db:"user_id" json:"user_id"`
-	Username          string `db:"username" json:"username"`
-	DisplayName       string `db:"display_name" json:"display_name"`
-	WidgetSecretToken string `db:"widget_secret_token" json:"widget_secret_token"`
-	Email             string `db:"email" json:"email"`
+	UserID            int    `json:"user_id"`
+	Username          string `json:"username"`
+	DisplayName       string `json:"display_name"`
+	WidgetSecretToken string `json:"widget_secret_token"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PageSettings
 }
 
 type DonationResponse struct {
-	OrderID            string `db:"order_id" json:"order_id"`
-	AmountCents        int    `db:"amount_cents" json:"amount_cents"`
-	DonorName          string `db:"donor_name" json:"donor_name"`
-	DonorMessage       string `db:"donor_message" json:"donor_message"`
-	PaymentGatewayTxID string `db:"payment_gateway_tx_id" json:"payment_gateway_tx_id"`
-	CreatedAt          string `db:"created_at" json:"created_at"`
-	MediaType          string `db:"media_type" json:"media_type"`
-	MediaURL           string `db:"media_url" json:"media_url"`
-	MediaStartSeconds  int    `db:"media_start_seconds" json:"media_start_seconds"`
-	MediaEndSeconds    int    `db:"media_end_seconds" json:"media_end_seconds"`
+	OrderID            string    `json:"order_id"`
+	Amount             int64     `json:"amount"`
+	Currency           string    `json:"currency"`
+	RefundedAmount     int64     `json:"refunded_amount"`
+	Status             string    `json:"status"`
+	DonorName          string    `json:"donor_name"`
+	DonorMessage       string    `json:"donor_message"`
+	PaymentGatewayTxID string    `json:"payment_gateway_tx_id"`
+	CreatedAt          time.Time `json:"created_at"`
+	MediaType          string    `json:"media_type"`
+	MediaURL           string    `json:"media_url"`
+	MediaStartSeconds  int       `json:"media_start_seconds"`
+	MediaEndSeconds    int       `json:"media_end_seconds"`
+	AlertStatus        string    `json:"alert_status"`
+	// Amount is the gross; the rest is fixed at settlement
+	PlatformFee *int64 `json:"platform_fee"`
+	GatewayFee  *int64 `json:"gateway_fee"`
+	NetAmount   *int64 `json:"net_amount"`
+}
+
+func newDonationResponse(d models.CreatorDonation) DonationResponse {
+	return DonationResponse{
+		OrderID:            d.OrderID,
+		Amount:             d.Amount,
+		Currency:           d.Currency,
+		RefundedAmount:     d.RefundedAmount,
+		Status:             d.Status,
+		DonorName:          d.DonorName,
+		DonorMessage:       d.DonorMessage,
+		PaymentGatewayTxID: d.PaymentGatewayTxID,
+		CreatedAt:          d.CreatedAt,
+		MediaType:          d.MediaType,
+		MediaURL:           d.MediaURL,
+		MediaStartSeconds:  d.MediaStartSeconds,
+		MediaEndSeconds:    d.MediaEndSeconds,
+		AlertStatus:        d.AlertStatus,
+		PlatformFee:        d.PlatformFee,
+		GatewayFee:         d.GatewayFee,
+		NetAmount:          d.NetAmount,
+	}
 }
 
-func NewCreatorHandler(db *sqlx.DB) *CreatorHandler {
-	return &CreatorHandler{DB: db}
+func NewCreatorHandler(repos repository.Repos, hub *ws.Hub, overlayBaseURL string) *CreatorHandler {
+	return &CreatorHandler{
+		Users:          repos.Users,
+		Creators:       repos.Creators,
+		Donations:      repos.Donations,
+		Hub:            hub,
+		OverlayBaseURL: strings.TrimRight(overlayBaseURL, "/"),
+	}
 }
 
 func (h *CreatorHandler) GetMyProfile(c *gin.Context) {
@@ -54,23 +102,40 @@ func (h *CreatorHandler) GetMyProfile(c *gin.Context) {
 		return
 	}
 
-	// Fetch the creator profile from the database
-	var profile ProfileResponse
-	query := `SELECT 
-            c.user_id, c.username, c.display_name, c.widget_secret_token,
-            u.email
-            FROM creators c
-            INNER JOIN users u ON c.user_id = u.id
-            WHERE c.user_id = $1`
-
-	err := h.DB.Get(&profile, query, userID)
+	// Fetch the creator profile and its account
+	creator, err := h.Creators.GetByUserID(userID)
 	if err != nil {
 		log.Println("Failed to get creator profile:", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
 		return
 	}
+	h.writeProfile(c, creator)
+}
+
+// writeProfile responds with the creator's profile and account.
+func (h *CreatorHandler) writeProfile(c *gin.Context, creator models.Creator) {
+	user, err := h.Users.GetByID(creator.UserID)
+	if err != nil {
+		log.Println("Failed to get user for creator profile:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+	page, err := pageSettings(h.Donations, creator)
+	if err != nil {
+		log.Println("Failed to get goal progress:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
 
-	c.JSON(http.StatusOK, profile)
+	c.JSON(http.StatusOK, ProfileResponse{
+		UserID:            creator.UserID,
+		Username:          creator.Username,
+		DisplayName:       creator.DisplayName,
+		WidgetSecretToken: creator.WidgetSecretToken,
+		Email:             user.Email,
+		EmailVerified:     user.EmailVerifiedAt != nil,
+		PageSettings:      page,
+	})
 }
 
 func (h *CreatorHandler) GetMyDonations(c *gin.Context) {
@@ -79,11 +144,7 @@ func (h *CreatorHandler) GetMyDonations(c *gin.Context) {
 	userID := userID_any.(int)
 
 	// Fetch the creator's ID from their user_id
-	var creator models.Creator
-	query_creator := `SELECT id 
-                    FROM creators 
-                    WHERE user_id = $1`
-	err := h.DB.Get(&creator, query_creator, userID)
+	creator, err := h.Creators.GetByUserID(userID)
 	if err != nil {
 		log.Println("Failed to find creator for user_id:", userID)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
@@ -91,19 +152,108 @@ func (h *CreatorHandler) GetMyDonations(c *gin.Context) {
 	}
 
 	// Fetch all donations for this creator, newest first
-	var donations []DonationResponse
-	query_donations := `SELECT 
-                      order_id, amount_cents, donor_name, donor_message, payment_gateway_tx_id, 
-                      created_at, media_type, media_url, media_start_seconds, media_end_seconds
-                      FROM donations 
-                      WHERE creator_id = $1 AND status = 'settled'
-                      ORDER BY created_at DESC`
-	err = h.DB.Select(&donations, query_donations, creator.ID)
+	paid, err := h.Donations.ListPaidByCreator(creator.ID)
 	if err != nil {
 		log.Println("Failed to get donations:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch donations"})
 		return
 	}
 
+	donations := make([]DonationResponse, len(paid))
+	for i, d := range paid {
+		donations[i] = newDonationResponse(d)
+	}
 	c.JSON(http.StatusOK, donations)
 }
+
+// ExportMyDonations streams the creator's donations as CSV, for
+// bookkeeping.
+func (h *CreatorHandler) ExportMyDonations(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	donations, err := h.Donations.ListPaidByCreator(creator.ID)
+	if err != nil {
+		log.Println("Failed to get donations:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch donations"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="donations.csv"`)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{
+		"order_id", "created_at", "status", "donor_name", "donor_message",
+		"currency", "gross", "platform_fee", "gateway_fee", "net", "refunded",
+	})
+	for _, d := range donations {
+		w.Write([]string{
+			d.OrderID, d.CreatedAt.Format(time.RFC3339), d.Status, csvText(d.DonorName), csvText(d.DonorMessage), d.Currency,
+			formatAmount(&d.Amount, d.Currency), formatAmount(d.PlatformFee, d.Currency),
+			formatAmount(d.GatewayFee, d.Currency), formatAmount(d.NetAmount, d.Currency),
+			formatAmount(&d.RefundedAmount, d.Currency),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Println("Failed to write donations export:", err)
+	}
+}
+
+// csvText defuses a cell written by a donor. Spreadsheets run a cell that
+// starts with one of these characters as a formula, e.g. =HYPERLINK(...),
+// so it is prefixed with a quote to be shown as text.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// formatAmount prints an optional amount in major units, so spreadsheets
+// read 12.50 rather than 1250. It is empty for donations settled before
+// fees were recorded.
+func formatAmount(amount *int64, currency string) string {
+	if amount == nil {
+		return ""
+	}
+	return money.Money{Amount: *amount, Currency: currency}.Decimal()
+}
+
+// RotateWidgetToken replaces the creator's overlay token and kicks every
+// overlay still connected with the old one.
+func (h *CreatorHandler) RotateWidgetToken(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	newToken, err := generateSecureToken(widgetTokenBytes)
+	if err != nil {
+		log.Println("Widget token generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	creator, err := h.Creators.RotateWidgetToken(userID, newToken)
+	if err != nil {
+		log.Println("Failed to rotate widget token for user_id:", userID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	// Old overlays must reconnect with the new URL
+	h.Hub.Disconnect <- creator.ID
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Widget token rotated.",
+		"widget_secret_token": newToken,
+		"overlay_url":         h.OverlayBaseURL + "/" + newToken,
+	})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"testing"
+
+	"my-platform/internal/repository"
+)
+
+func TestGetMyDonationsListsOnlyPaid(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000}, nil)
+
+	var donations []DonationResponse
+	w := s.do("GET", "/api/me/donations", s.login("alice").Token, nil, &donations)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(donations) != 0 {
+		t.Errorf("donations = %d, want 0 while the only one is pending", len(donations))
+	}
+}
+
+func TestExportMyDonationsDefusesFormulas(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.do("POST", "/api/donate/alice", "", CreateDonationRequest{
+		Amount:       25000,
+		DonorName:    `=HYPERLINK("https://evil.example","click")`,
+		DonorMessage: "@SUM(1+1)",
+	}, nil)
+	s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 10000, DonorName: "Bob", DonorMessage: "gg -- love it"}, nil)
+	s.repos.Donations.(*repository.MemoryDonations).Settle(1)
+	s.repos.Donations.(*repository.MemoryDonations).Settle(2)
+
+	w := s.do("GET", "/api/me/donations/export", s.login("alice").Token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+
+	cells := map[string]string{}
+	for _, record := range records[1:] {
+		cells[record[3]] = record[4]
+	}
+	if msg, ok := cells[`'=HYPERLINK("https://evil.example","click")`]; !ok || msg != "'@SUM(1+1)" {
+		t.Errorf("formula cells were not escaped: %v", cells)
+	}
+	if msg, ok := cells["Bob"]; !ok || msg != "gg -- love it" {
+		t.Errorf("plain cells changed: %v", cells)
+	}
+}
+
+func TestRotateWidgetToken(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	token := s.login("alice").Token
+
+	var before ProfileResponse
+	s.do("GET", "/api/me", token, nil, &before)
+
+	var rotated struct {
+		WidgetSecretToken string `json:"widget_secret_token"`
+		OverlayURL        string `json:"overlay_url"`
+	}
+	if w := s.do("POST", "/api/me/widget-token/rotate", token, nil, &rotated); w.Code != http.StatusOK {
+		t.Fatalf("rotate: status = %d: %s", w.Code, w.Body)
+	}
+	if rotated.WidgetSecretToken == before.WidgetSecretToken {
+		t.Error("token did not change")
+	}
+	if rotated.OverlayURL != "http://overlay.test/"+rotated.WidgetSecretToken {
+		t.Errorf("overlay_url = %q", rotated.OverlayURL)
+	}
+
+	if _, err := s.repos.Creators.GetByWidgetToken(before.WidgetSecretToken); err == nil {
+		t.Error("old token still finds the creator")
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+)
+
+// errCurrencyNotAccepted means the creator does not take the currency.
+var errCurrencyNotAccepted = errors.New("currency not accepted")
+
+type CreatorCurrencyRequest struct {
+	// Limits in minor units of the currency. A zero MaxAmount means no
+	// upper limit.
+	MinAmount int64 `json:"min_amount" binding:"required,gt=0"`
+	MaxAmount int64 `json:"max_amount" binding:"gte=0"`
+}
+
+type CreatorCurrencyResponse struct {
+	Currency  string `json:"currency"`
+	MinAmount int64  `json:"min_amount"`
+	MaxAmount int64  `json:"max_amount"`
+}
+
+// acceptedCurrencies lists the currencies a creator takes. Creators who
+// have not picked any take the default currency.
+func (h *DonationHandler) acceptedCurrencies(creatorID int) ([]models.CreatorCurrency, error) {
+	accepted, err := h.Creators.Currencies(creatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accepted) == 0 {
+		fallback := h.DefaultCurrency
+		fallback.CreatorID = creatorID
+		accepted = append(accepted, fallback)
+	}
+	return accepted, nil
+}
+
+// acceptedCurrency picks the currency a donation is made in. An empty code
+// means the default currency if the creator takes it, else the creator's
+// first.
+func (h *DonationHandler) acceptedCurrency(creatorID int, code string) (models.CreatorCurrency, error) {
+	accepted, err := h.acceptedCurrencies(creatorID)
+	if err != nil {
+		return models.CreatorCurrency{}, err
+	}
+
+	want := strings.ToUpper(code)
+	if want == "" {
+		want = h.DefaultCurrency.Currency
+	}
+	for _, a := range accepted {
+		if a.Currency == want {
+			return a, nil
+		}
+	}
+	if code == "" {
+		return accepted[0], nil
+	}
+	return models.CreatorCurrency{}, errCurrencyNotAccepted
+}
+
+func (h *DonationHandler) GetMyCurrencies(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	accepted, err := h.acceptedCurrencies(creator.ID)
+	if err != nil {
+		log.Println("Failed to find accepted currencies:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch currencies"})
+		return
+	}
+
+	response := make([]CreatorCurrencyResponse, len(accepted))
+	for i, a := range accepted {
+		response[i] = CreatorCurrencyResponse{Currency: a.Currency, MinAmount: a.MinAmount, MaxAmount: a.MaxAmount}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetMyCurrency accepts a currency or changes its limits. The first
+// currency a creator sets replaces the default.
+func (h *DonationHandler) SetMyCurrency(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	currency, err := money.Lookup(c.Param("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown currency"})
+		return
+	}
+	if !h.Gateways.Checkout().SupportsCurrency(currency.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payments in " + currency.Code + " are not supported."})
+		return
+	}
+
+	var req CreatorCurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.MaxAmount != 0 && req.MaxAmount < req.MinAmount {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_amount must be at least min_amount."})
+		return
+	}
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	err = h.Creators.SetCurrency(models.CreatorCurrency{
+		CreatorID: creator.ID,
+		Currency:  currency.Code,
+		MinAmount: req.MinAmount,
+		MaxAmount: req.MaxAmount,
+	})
+	if err != nil {
+		log.Println("Failed to set creator currency:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreatorCurrencyResponse{Currency: currency.Code, MinAmount: req.MinAmount, MaxAmount: req.MaxAmount})
+}
+
+// RemoveMyCurrency stops accepting a currency. Removing the last one puts
+// the creator back on the default currency.
+func (h *DonationHandler) RemoveMyCurrency(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	if err := h.Creators.RemoveCurrency(creator.ID, strings.ToUpper(c.Param("currency"))); err != nil {
+		log.Println("Failed to remove creator currency:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Currency removed."})
+}
@@ -1,45 +1,70 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
-	"github.com/midtrans/midtrans-go"
-	"github.com/midtrans/midtrans-go/coreapi"
-	"github.com/midtrans/midtrans-go/snap"
+	"github.com/google/uuid"
 
+	"my-platform/internal/donations"
 	"my-platform/internal/models"
-	ws "my-platform/internal/websocket"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+	"my-platform/internal/repository"
 )
 
+// MaxOrderIDPrefixLen keeps prefix plus UUID within Midtrans' 50 character
+// order_id limit.
+const MaxOrderIDPrefixLen = 50 - 36
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header we store.
+const maxIdempotencyKeyLen = 255
+
 type DonationHandler struct {
-	DB         *sqlx.DB
-	SnapClient snap.Client
-	CoreClient coreapi.Client
-	Hub        *ws.Hub
+	Creators      repository.CreatorRepo
+	Donations     repository.DonationRepo
+	Gateways      *payment.Registry
+	Updater       repository.DonationUpdater
+	OrderIDPrefix string
+	// DefaultCurrency is what creators who have not picked their accepted
+	// currencies take donations in.
+	DefaultCurrency models.CreatorCurrency
 }
 
-func NewDonationHandler(db *sqlx.DB, serverKey string, hub *ws.Hub) *DonationHandler {
-	var s snap.Client
-	s.New(serverKey, midtrans.Sandbox)
-
-	var c coreapi.Client
-	c.New(serverKey, midtrans.Sandbox)
+// DonationConfig holds the settings of a DonationHandler.
+type DonationConfig struct {
+	OrderIDPrefix string
+	// DefaultCurrency and its limits, in minor units. A zero
+	// DefaultMaxAmount means no upper limit.
+	DefaultCurrency  string
+	DefaultMinAmount int64
+	DefaultMaxAmount int64
+}
 
+func NewDonationHandler(repos repository.Repos, gateways *payment.Registry, cfg DonationConfig) *DonationHandler {
 	return &DonationHandler{
-		DB:         db,
-		SnapClient: s,
-		CoreClient: c,
-		Hub:        hub,
+		Creators:      repos.Creators,
+		Donations:     repos.Donations,
+		Gateways:      gateways,
+		Updater:       repos.Updater,
+		OrderIDPrefix: cfg.OrderIDPrefix,
+		DefaultCurrency: models.CreatorCurrency{
+			Currency:  cfg.DefaultCurrency,
+			MinAmount: cfg.DefaultMinAmount,
+			MaxAmount: cfg.DefaultMaxAmount,
+		},
 	}
 }
 
 type CreateDonationRequest struct {
-	AmountCents       int    `json:"amount_cents" binding:"required,gt=1000"`
+	// Amount is in minor units of Currency, e.g. rupiah for IDR and cents
+	// for USD. Currency defaults to the creator's default currency.
+	Amount            int64  `json:"amount" binding:"required,gt=0"`
+	Currency          string `json:"currency"`
 	DonorName         string `json:"donor_name"`
 	DonorMessage      string `json:"donor_message"`
 	MediaType         string `json:"media_type"`
@@ -48,6 +73,23 @@ type CreateDonationRequest struct {
 	MediaEndSeconds   int    `json:"media_end_seconds"`
 }
 
+// newOrderID returns a UUIDv7 order ID. UUIDv7 sorts by creation time, and
+// order_id is also UNIQUE in the database.
+func (h *DonationHandler) newOrderID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return h.OrderIDPrefix + id.String(), nil
+}
+
+// requestFingerprint identifies a donation request body, so a reused
+// Idempotency-Key with a different body can be told apart from a retry.
+func requestFingerprint(req CreateDonationRequest) string {
+	data, _ := json.Marshal(req)
+	return hashToken(string(data))
+}
+
 func (h *DonationHandler) CreateDonation(c *gin.Context) {
 	// Get username and validate request
 	username := c.Param("username")
@@ -57,28 +99,62 @@ func (h *DonationHandler) CreateDonation(c *gin.Context) {
 		return
 	}
 
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long."})
+		return
+	}
+	fingerprint := requestFingerprint(req)
+
 	// Find creator in DB
-	var creator models.Creator
-	query := `SELECT id FROM creators WHERE username = $1`
-	err := h.DB.Get(&creator, query, username)
+	// Unverified accounts don't get a public donation page yet
+	creator, err := h.Creators.GetVerifiedByUsername(username)
 	if err != nil {
 		log.Println("Failed to find creator:", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Creator not found"})
 		return
 	}
 
+	// A retried request gets the checkout of the first one
+	if idempotencyKey != "" && h.replayDonation(c, creator.ID, idempotencyKey, fingerprint) {
+		return
+	}
+
+	// Check the amount against the limits of the creator's currency
+	accepted, err := h.acceptedCurrency(creator.ID, req.Currency)
+	if err != nil {
+		if errors.Is(err, errCurrencyNotAccepted) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This creator does not accept donations in " + req.Currency + "."})
+			return
+		}
+		log.Println("Failed to find accepted currencies:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	amount := money.Money{Amount: req.Amount, Currency: accepted.Currency}
+	gateway := h.Gateways.Checkout()
+	if !gateway.SupportsCurrency(amount.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payments in " + amount.Currency + " are not supported."})
+		return
+	}
+	if amount.Amount < accepted.MinAmount {
+		minimum := money.Money{Amount: accepted.MinAmount, Currency: accepted.Currency}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The minimum donation is " + minimum.String() + "."})
+		return
+	}
+	if accepted.MaxAmount > 0 && amount.Amount > accepted.MaxAmount {
+		maximum := money.Money{Amount: accepted.MaxAmount, Currency: accepted.Currency}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The maximum donation is " + maximum.String() + "."})
+		return
+	}
+
 	// Create unique Order ID
-	orderID := "DONATION-" + strconv.FormatInt(time.Now().Unix(), 10) + "-C" + strconv.Itoa(creator.ID)
-
-	// Save pending donation to our database
-	query = `
-		INSERT INTO donations 
-		  (creator_id, amount_cents, donor_name, donor_message, 
-		   media_type, media_url, media_start_seconds, media_end_seconds, 
-		   order_id, status)
-		VALUES 
-		  ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending')
-	`
+	orderID, err := h.newOrderID()
+	if err != nil {
+		log.Println("Order ID generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
 
 	// Handle empty donor name
 	donorName := req.DonorName
@@ -86,116 +162,239 @@ func (h *DonationHandler) CreateDonation(c *gin.Context) {
 		donorName = "Anonymous"
 	}
 
-	_, err = h.DB.Exec(query,
-		creator.ID, req.AmountCents, donorName, req.DonorMessage,
-		req.MediaType, req.MediaURL, req.MediaStartSeconds, req.MediaEndSeconds,
-		orderID,
-	)
+	// Save pending donation to our database. If a concurrent request with
+	// the same Idempotency-Key got there first, nothing is inserted.
+	pending := models.Donation{
+		CreatorID:          creator.ID,
+		Amount:             amount.Amount,
+		Currency:           amount.Currency,
+		DonorName:          donorName,
+		DonorMessage:       req.DonorMessage,
+		MediaType:          req.MediaType,
+		MediaURL:           req.MediaURL,
+		MediaStartSeconds:  req.MediaStartSeconds,
+		MediaEndSeconds:    req.MediaEndSeconds,
+		OrderID:            orderID,
+		PaymentGateway:     gateway.Name(),
+		RequestFingerprint: &fingerprint,
+	}
+	if idempotencyKey != "" {
+		pending.IdempotencyKey = &idempotencyKey
+	}
+	donationID, err := h.Donations.CreatePending(pending)
+	if errors.Is(err, repository.ErrConflict) {
+		h.replayDonation(c, creator.ID, idempotencyKey, fingerprint)
+		return
+	}
 	if err != nil {
 		log.Println("Failed to create pending donation:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
 		return
 	}
 
-	// Create Midtrans snap request
-	snapReq := &snap.Request{
-		TransactionDetails: midtrans.TransactionDetails{
-			OrderID:  orderID,
-			GrossAmt: int64(req.AmountCents),
-		},
-		CustomerDetail: &midtrans.CustomerDetails{
-			FName: donorName,
-		},
-	}
-
-	// Call Midtrans
-	snapResp, err := h.SnapClient.CreateTransaction(snapReq)
-	if snapResp == nil {
-		log.Println("Failed to create Midtrans transaction (nil response):", err)
+	// Create the checkout with the payment gateway
+	checkout, err := gateway.CreateCheckout(payment.CheckoutRequest{
+		OrderID:      orderID,
+		Amount:       amount,
+		CustomerName: donorName,
+	})
+	if err != nil {
+		log.Printf("Failed to create %s checkout: %v", gateway.Name(), err)
+		// Nobody can pay without a checkout; fail the donation and free the
+		// key so the donor can retry
+		if err := h.Donations.MarkFailed(donationID); err != nil {
+			log.Println("Failed to mark donation as failed:", err)
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment gateway error."})
 		return
 	}
-	if err != nil {
-		log.Printf("Midtrans returned a valid response but also a non-nil error: %v", err)
+
+	if err := h.Donations.SetCheckoutURL(donationID, checkout.RedirectURL); err != nil {
+		log.Println("Failed to store checkout URL:", err)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "Payment link created.",
-		"redirect_url": snapResp.RedirectURL,
+		"redirect_url": checkout.RedirectURL,
 		"order_id":     orderID,
 	})
 }
 
+// replayDonation answers a request whose Idempotency-Key was already used
+// for this creator. It reports false if the key is new.
+func (h *DonationHandler) replayDonation(c *gin.Context, creatorID int, key, fingerprint string) bool {
+	existing, err := h.Donations.GetByIdempotencyKey(creatorID, key)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Println("Failed to look up idempotency key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return true
+	}
+
+	if existing.RequestFingerprint == nil || *existing.RequestFingerprint != fingerprint {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request."})
+		return true
+	}
+	if existing.CheckoutURL == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress."})
+		return true
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Payment link created.",
+		"redirect_url": *existing.CheckoutURL,
+		"order_id":     existing.OrderID,
+	})
+	return true
+}
+
+// HandlePaymentNotification applies a gateway's webhook. The gateway is
+// named in the path, so a provider that no longer takes checkouts can still
+// report on its orders; without a name the checkout gateway is assumed, as
+// before every gateway had its own URL.
 func (h *DonationHandler) HandlePaymentNotification(c *gin.Context) {
-	// Bind notification and get OrderID
-	var notification coreapi.TransactionStatusResponse
-	if err := c.ShouldBindJSON(&notification); err != nil {
-		log.Println("Failed to bind Midtrans notification:", err)
+	gateway := h.Gateways.Checkout()
+	if name := c.Param("gateway"); name != "" {
+		var ok bool
+		if gateway, ok = h.Gateways.Get(name); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment gateway"})
+			return
+		}
+	}
+
+	// Read the raw body, gateways may sign it byte for byte
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Println("Failed to read payment notification:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification format"})
 		return
 	}
 
-	// Verify transaction with Midtrans
-	apiResp, err := h.CoreClient.CheckTransaction(notification.OrderID)
-	if apiResp == nil {
-		log.Println("Failed to verify transaction (nil response) with Midtrans Core API:", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found or API error"})
+	// Let the gateway authenticate the notification and report the status
+	txn, err := gateway.VerifyNotification(c.Request.Header, body)
+	if err != nil {
+		switch {
+		case errors.Is(err, payment.ErrInvalidNotification):
+			log.Println("Failed to parse payment notification:", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification format"})
+		case errors.Is(err, payment.ErrInvalidSignature):
+			log.Printf("SECURITY: rejected payment notification from %s: %v", c.ClientIP(), err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		default:
+			log.Println("Failed to verify transaction with payment gateway:", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found or API error"})
+		}
 		return
 	}
+
+	// Apply whatever status the gateway reported through the state machine
+	result, err := h.Updater.Apply(*txn, donations.SourceWebhook)
 	if err != nil {
-		log.Printf("Midtrans Core API returned a valid response but also a non-nil error: %v", err)
+		if errors.Is(err, donations.ErrNotFound) {
+			log.Println("Failed to find donation by order_id:", txn.OrderID)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Donation not found"})
+			return
+		}
+		log.Println("Failed to update donation status:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
 	}
 
-	// Check settlement status
-	if apiResp.TransactionStatus != "settlement" && apiResp.TransactionStatus != "capture" {
-		log.Println("Received non-settled transaction status:", apiResp.TransactionStatus)
-		c.JSON(http.StatusOK, gin.H{"status": "ok (not settled)"})
+	if !result.Changed {
+		c.JSON(http.StatusOK, gin.H{"status": "ok (unchanged)", "donation_status": result.To})
 		return
 	}
 
-	var dbErr error
-	var donation models.Donation
-	query := `SELECT id, creator_id, amount_cents, donor_name, donor_message, 
-	          status, media_type, media_url, media_start_seconds, media_end_seconds, order_id 
-	          FROM donations WHERE order_id = $1`
-	dbErr = h.DB.Get(&donation, query, apiResp.OrderID)
-	if dbErr != nil {
-		log.Println("Failed to find donation by order_id:", apiResp.OrderID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Donation not found"})
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "donation_status": result.To})
+}
+
+type RefundDonationRequest struct {
+	// Amount in minor units of the donation's currency. Zero or omitted
+	// refunds whatever is left.
+	Amount int64  `json:"amount" binding:"gte=0"`
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// RefundDonation returns part or all of one of the creator's donations to
+// the donor.
+func (h *DonationHandler) RefundDonation(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	var req RefundDonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
 
-	if donation.Status == "settled" {
-		log.Println("Duplicate webhook, already settled:", apiResp.TransactionID)
-		c.JSON(http.StatusOK, gin.H{"status": "ok (duplicate)"})
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
 		return
 	}
 
-	query = `
-		UPDATE donations SET status = 'settled', payment_gateway_tx_id = $1
-		WHERE order_id = $2
-	`
-	_, dbErr = h.DB.Exec(query, apiResp.TransactionID, apiResp.OrderID)
-	if dbErr != nil {
-		log.Println("Failed to update donation status:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	h.refund(c, donations.RefundRequest{
+		OrderID:     c.Param("order_id"),
+		CreatorID:   creator.ID,
+		Amount:      req.Amount,
+		Reason:      req.Reason,
+		RequestedBy: userID,
+	})
+}
+
+type AdminRefundDonationRequest struct {
+	RefundDonationRequest
+	// Operator names the person refunding, since the admin key is shared.
+	Operator string `json:"operator" binding:"required,max=100"`
+}
+
+// AdminRefundDonation lets an operator refund any creator's donation, e.g.
+// after a chargeback dispute or a fraud report.
+func (h *DonationHandler) AdminRefundDonation(c *gin.Context) {
+	var req AdminRefundDonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
 
-	log.Printf("SUCCESS: Saved new donation %s for creator %d", apiResp.TransactionID, donation.CreatorID)
+	h.refund(c, donations.RefundRequest{
+		OrderID:  c.Param("order_id"),
+		Amount:   req.Amount,
+		Reason:   req.Reason,
+		Operator: req.Operator,
+	})
+}
 
-	alert := ws.DonationAlert{
-		TargetCreatorID:   donation.CreatorID,
-		DonorName:         donation.DonorName,
-		AmountCents:       donation.AmountCents,
-		DonorMessage:      donation.DonorMessage,
-		MediaType:         donation.MediaType,
-		MediaURL:          donation.MediaURL,
-		MediaStartSeconds: donation.MediaStartSeconds,
-		MediaEndSeconds:   donation.MediaEndSeconds,
+// refund carries out a refund request and writes the response.
+func (h *DonationHandler) refund(c *gin.Context, req donations.RefundRequest) {
+	result, err := h.Updater.Refund(h.Gateways, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, donations.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Donation not found"})
+		case errors.Is(err, donations.ErrNotRefundable):
+			c.JSON(http.StatusConflict, gin.H{"error": "This donation cannot be refunded."})
+		case errors.Is(err, donations.ErrInvalidRefundAmount):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be positive and at most the amount not yet refunded."})
+		case errors.Is(err, donations.ErrGateway):
+			log.Printf("Failed to refund donation %s: %v", c.Param("order_id"), err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Refund failed, please try again later."})
+		default:
+			log.Printf("Failed to record refund of donation %s: %v", c.Param("order_id"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		}
+		return
 	}
 
-	h.Hub.BroadcastAlert <- alert
-
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	c.JSON(http.StatusOK, gin.H{
+		"order_id":        result.Donation.OrderID,
+		"status":          result.To,
+		"amount":          result.Donation.Amount,
+		"currency":        result.Donation.Currency,
+		"refunded_amount": result.Donation.RefundedAmount,
+	})
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/payment"
+)
+
+type donateResponse struct {
+	RedirectURL string `json:"redirect_url"`
+	OrderID     string `json:"order_id"`
+	Error       string `json:"error"`
+}
+
+func TestCreateDonationNeedsVerifiedCreator(t *testing.T) {
+	s := newTestServer(t)
+	s.register("unverified", false)
+
+	w := s.do("POST", "/api/donate/unverified", "", CreateDonationRequest{Amount: 25000}, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unverified creator: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = s.do("POST", "/api/donate/nobody", "", CreateDonationRequest{Amount: 25000}, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown creator: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCreateDonation(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+
+	var resp donateResponse
+	w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000, DonorMessage: "hi"}, &resp)
+	if w.Code != http.StatusOK {
+		t.Fatalf("donate: status = %d: %s", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(resp.OrderID, "TEST-") || resp.RedirectURL != "https://pay.example.com/"+resp.OrderID {
+		t.Errorf("response = %+v", resp)
+	}
+
+	checkout := s.gateway.checkouts[0]
+	if checkout.Amount.Amount != 25000 || checkout.Amount.Currency != "IDR" || checkout.CustomerName != "Anonymous" {
+		t.Errorf("checkout = %+v", checkout)
+	}
+}
+
+func TestCreateDonationChecksCurrencyLimits(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 999}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("below default minimum: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 500, Currency: "USD"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("currency not accepted: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	token := s.login("alice").Token
+	w := s.do("PUT", "/api/me/currencies/USD", token, CreatorCurrencyRequest{MinAmount: 300, MaxAmount: 10000}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set currency: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 500, Currency: "usd"}, nil); w.Code != http.StatusOK {
+		t.Errorf("accepted currency: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 20000, Currency: "USD"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("above maximum: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// The creator replaced the default, so IDR is no longer taken
+	if w := s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000, Currency: "IDR"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("replaced default: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCreateDonationIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	req := CreateDonationRequest{Amount: 25000, DonorName: "Bob"}
+
+	var first, second donateResponse
+	s.do("POST", "/api/donate/alice", "", req, &first, "Idempotency-Key", "key-1")
+	w := s.do("POST", "/api/donate/alice", "", req, &second, "Idempotency-Key", "key-1")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: status = %d, replayed = %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if second.OrderID != first.OrderID || len(s.gateway.checkouts) != 1 {
+		t.Errorf("retry made a new donation: %s vs %s, %d checkouts", second.OrderID, first.OrderID, len(s.gateway.checkouts))
+	}
+
+	req.Amount = 50000
+	if w := s.do("POST", "/api/donate/alice", "", req, nil, "Idempotency-Key", "key-1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different body: status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+// TestCreateDonationGatewayFailure checks a failed checkout frees the
+// Idempotency-Key, so the donor's retry goes through.
+func TestCreateDonationGatewayFailure(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	req := CreateDonationRequest{Amount: 25000}
+
+	s.gateway.failing = true
+	if w := s.do("POST", "/api/donate/alice", "", req, nil, "Idempotency-Key", "key-1"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("gateway down: status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	s.gateway.failing = false
+	w := s.do("POST", "/api/donate/alice", "", req, nil, "Idempotency-Key", "key-1")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry: status = %d, replayed = %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestHandlePaymentNotification(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	var resp donateResponse
+	s.do("POST", "/api/donate/alice", "", CreateDonationRequest{Amount: 25000}, &resp)
+	paid := fakeNotification{OrderID: resp.OrderID, Status: payment.StatusPaid, Amount: 25000, Currency: "IDR"}
+
+	if w := s.do("POST", "/api/webhook/payment/fake", "", paid, nil, "X-Fake-Signature", "forged"); w.Code != http.StatusUnauthorized {
+		t.Errorf("forged: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := s.do("POST", "/api/webhook/payment/other", "", paid, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown gateway: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.notify(fakeNotification{OrderID: "TEST-missing", Status: payment.StatusPaid}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown order: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// A settlement for less than was charged is not booked
+	short := paid
+	short.Amount = 1000
+	var body struct {
+		Status         string `json:"status"`
+		DonationStatus string `json:"donation_status"`
+	}
+	s.do("POST", "/api/webhook/payment/fake", "", short, &body, "X-Fake-Signature", fakeSignature)
+	if body.DonationStatus != models.DonationPending {
+		t.Errorf("short settlement: donation_status = %q, want pending", body.DonationStatus)
+	}
+
+	s.do("POST", "/api/webhook/payment", "", paid, &body, "X-Fake-Signature", fakeSignature)
+	if body.Status != "ok" || body.DonationStatus != models.DonationSettled {
+		t.Errorf("settle: %+v", body)
+	}
+	s.do("POST", "/api/webhook/payment/fake", "", paid, &body, "X-Fake-Signature", fakeSignature)
+	if body.Status != "ok (unchanged)" {
+		t.Errorf("repeat: status = %q", body.Status)
+	}
+
+	var donations []DonationResponse
+	s.do("GET", "/api/me/donations", s.login("alice").Token, nil, &donations)
+	if len(donations) != 1 || donations[0].Status != models.DonationSettled || donations[0].AlertStatus != "pending" ||
+		donations[0].NetAmount == nil || *donations[0].NetAmount != 25000 {
+		t.Errorf("donations = %+v", donations)
+	}
+}
+
+func TestRefundDonation(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.register("carol", true)
+	orderID := s.donate("alice", 25000)
+	token := s.login("alice").Token
+	path := "/api/me/donations/" + orderID + "/refund"
+
+	if w := s.do("POST", path, s.login("carol").Token, RefundDonationRequest{Reason: "mine now"}, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other creator: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.do("POST", path, token, RefundDonationRequest{Amount: 30000, Reason: "too much"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("over the amount: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var body struct {
+		Status         string `json:"status"`
+		RefundedAmount int64  `json:"refunded_amount"`
+	}
+	if w := s.do("POST", path, token, RefundDonationRequest{Amount: 10000, Reason: "typo"}, &body); w.Code != http.StatusOK {
+		t.Fatalf("partial: status = %d: %s", w.Code, w.Body)
+	}
+	if body.Status != models.DonationPartiallyRefunded || body.RefundedAmount != 10000 {
+		t.Errorf("partial: %+v", body)
+	}
+	if refund := s.gateway.refunds[0]; refund.Amount.Amount != 10000 || refund.IdempotencyKey != orderID+"-R10000" {
+		t.Errorf("gateway refund = %+v", refund)
+	}
+
+	s.gateway.failing = true
+	if w := s.do("POST", path, token, RefundDonationRequest{Reason: "rest"}, nil); w.Code != http.StatusBadGateway {
+		t.Errorf("gateway down: status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	s.gateway.failing = false
+
+	if w := s.admin("POST", "/api/admin/donations/"+orderID+"/refund", RefundDonationRequest{Reason: "rest"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("admin without operator: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	req := AdminRefundDonationRequest{RefundDonationRequest: RefundDonationRequest{Reason: "chargeback"}, Operator: "ops"}
+	if w := s.admin("POST", "/api/admin/donations/"+orderID+"/refund", req, &body); w.Code != http.StatusOK {
+		t.Fatalf("admin: status = %d: %s", w.Code, w.Body)
+	}
+	if body.Status != models.DonationRefunded || body.RefundedAmount != 25000 {
+		t.Errorf("admin: %+v", body)
+	}
+	if w := s.do("POST", path, token, RefundDonationRequest{Reason: "again"}, nil); w.Code != http.StatusConflict {
+		t.Errorf("refunded: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	var balances []ledger.Balance
+	s.do("GET", "/api/me/balance", token, nil, &balances)
+	if len(balances) != 1 || balances[0].Available != 0 || balances[0].Refunded != 25000 {
+		t.Errorf("balances = %+v", balances)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/repository"
+)
+
+// FeeHandler lets operators set the platform fee per currency, globally or
+// per creator. Fees are fixed when a donation settles, so changes only
+// affect later donations.
+type FeeHandler struct {
+	FeeSchedules repository.FeeScheduleRepo
+}
+
+func NewFeeHandler(repos repository.Repos) *FeeHandler {
+	return &FeeHandler{FeeSchedules: repos.FeeSchedules}
+}
+
+type FeeScheduleRequest struct {
+	PercentBPS int `json:"percent_bps" binding:"gte=0,lte=10000"`
+	// FixedAmount is in minor units of the schedule's currency.
+	FixedAmount int64 `json:"fixed_amount" binding:"gte=0"`
+}
+
+type FeeScheduleResponse struct {
+	// CreatorID is null for the global schedule.
+	CreatorID   *int      `json:"creator_id"`
+	Currency    string    `json:"currency"`
+	PercentBPS  int       `json:"percent_bps"`
+	FixedAmount int64     `json:"fixed_amount"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newFeeScheduleResponse(s models.FeeSchedule) FeeScheduleResponse {
+	return FeeScheduleResponse{
+		CreatorID:   s.CreatorID,
+		Currency:    s.Currency,
+		PercentBPS:  s.PercentBPS,
+		FixedAmount: s.FixedAmount,
+		UpdatedAt:   s.UpdatedAt,
+	}
+}
+
+// feeScheduleScope reads the :creator_id and :currency parameters, where a
+// creator_id of "default" means the global schedule.
+func feeScheduleScope(c *gin.Context) (*int, string, bool) {
+	currency, err := money.Lookup(c.Param("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown currency"})
+		return nil, "", false
+	}
+
+	param := c.Param("creator_id")
+	if param == "default" {
+		return nil, currency.Code, true
+	}
+	creatorID, err := strconv.Atoi(param)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "creator_id must be a number or \"default\""})
+		return nil, "", false
+	}
+	return &creatorID, currency.Code, true
+}
+
+func (h *FeeHandler) ListFeeSchedules(c *gin.Context) {
+	schedules, err := h.FeeSchedules.List()
+	if err != nil {
+		log.Println("Failed to list fee schedules:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch fee schedules"})
+		return
+	}
+
+	response := make([]FeeScheduleResponse, len(schedules))
+	for i, s := range schedules {
+		response[i] = newFeeScheduleResponse(s)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *FeeHandler) SetFeeSchedule(c *gin.Context) {
+	creatorID, currency, ok := feeScheduleScope(c)
+	if !ok {
+		return
+	}
+
+	var req FeeScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	schedule, err := h.FeeSchedules.Set(models.FeeSchedule{
+		CreatorID:   creatorID,
+		Currency:    currency,
+		PercentBPS:  req.PercentBPS,
+		FixedAmount: req.FixedAmount,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator not found"})
+		return
+	} else if err != nil {
+		log.Println("Failed to set fee schedule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, newFeeScheduleResponse(schedule))
+}
+
+// DeleteFeeSchedule drops an override. Without a creator schedule the
+// global one applies, and without a global one the configured default.
+func (h *FeeHandler) DeleteFeeSchedule(c *gin.Context) {
+	creatorID, currency, ok := feeScheduleScope(c)
+	if !ok {
+		return
+	}
+
+	if err := h.FeeSchedules.Delete(creatorID, currency); err != nil {
+		log.Println("Failed to delete fee schedule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Fee schedule removed."})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestFeeSchedules(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.register("carol", true)
+	alice, err := s.repos.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator, _ := s.repos.Creators.GetByUserID(alice.ID)
+	creatorPath := "/api/admin/fee-schedules/" + strconv.Itoa(creator.ID) + "/idr"
+
+	if w := s.admin("PUT", "/api/admin/fee-schedules/default/XYZ", FeeScheduleRequest{PercentBPS: 500}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown currency: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := s.admin("PUT", "/api/admin/fee-schedules/default/IDR", FeeScheduleRequest{PercentBPS: 10001}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("over 100%%: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := s.admin("PUT", "/api/admin/fee-schedules/999/IDR", FeeScheduleRequest{PercentBPS: 500}, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown creator: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var schedule FeeScheduleResponse
+	if w := s.admin("PUT", "/api/admin/fee-schedules/default/IDR", FeeScheduleRequest{PercentBPS: 500, FixedAmount: 1000}, &schedule); w.Code != http.StatusOK {
+		t.Fatalf("set global: status = %d: %s", w.Code, w.Body)
+	}
+	if schedule.CreatorID != nil || schedule.Currency != "IDR" || schedule.PercentBPS != 500 {
+		t.Errorf("global = %+v", schedule)
+	}
+	s.admin("PUT", creatorPath, FeeScheduleRequest{PercentBPS: 200}, nil)
+	s.admin("PUT", creatorPath, FeeScheduleRequest{PercentBPS: 100}, nil)
+
+	var schedules []FeeScheduleResponse
+	s.admin("GET", "/api/admin/fee-schedules", nil, &schedules)
+	if len(schedules) != 2 || schedules[0].CreatorID != nil ||
+		schedules[1].CreatorID == nil || *schedules[1].CreatorID != creator.ID || schedules[1].PercentBPS != 100 {
+		t.Errorf("schedules = %+v", schedules)
+	}
+
+	// Alice pays her own 1%, carol the global 5% plus 1000
+	s.donate("alice", 100000)
+	s.donate("carol", 100000)
+	if b := s.balance(s.login("alice").Token); b.PlatformFee != 1000 || b.Available != 99000 {
+		t.Errorf("alice = %+v", b)
+	}
+	if b := s.balance(s.login("carol").Token); b.PlatformFee != 6000 || b.Available != 94000 {
+		t.Errorf("carol = %+v", b)
+	}
+
+	if w := s.admin("DELETE", creatorPath, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	s.admin("GET", "/api/admin/fee-schedules", nil, &schedules)
+	if len(schedules) != 1 || schedules[0].CreatorID != nil {
+		t.Errorf("after delete = %+v", schedules)
+	}
+	if w := s.admin("DELETE", "/api/admin/fee-schedules/alice/IDR", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad creator_id: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
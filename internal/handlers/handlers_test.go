@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/mailer"
+	"my-platform/internal/middleware"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+	"my-platform/internal/repository"
+	ws "my-platform/internal/websocket"
+)
+
+const (
+	testJWTSecret   = "test-secret"
+	testAdminAPIKey = "test-admin-key"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// recordingMailer keeps sent mail so tests can follow emailed links.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenParam = regexp.MustCompile(`token=([^\s]+)`)
+
+// lastToken returns the token query parameter of the last link mailed.
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if match := tokenParam.FindStringSubmatch(m.sent[i].Body); match != nil {
+			token, _ := url.QueryUnescape(match[1])
+			return token
+		}
+	}
+	t.Fatal("no link mailed")
+	return ""
+}
+
+// sentTo returns the subjects of the mail sent to the address.
+func (m *recordingMailer) sentTo(to string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subjects []string
+	for _, msg := range m.sent {
+		if msg.To == to {
+			subjects = append(subjects, msg.Subject)
+		}
+	}
+	return subjects
+}
+
+// fakeGateway hands out checkout URLs for IDR and USD, or fails every
+// checkout and refund when failing is set. Its notifications are a
+// fakeNotification signed with the X-Fake-Signature header.
+type fakeGateway struct {
+	mu        sync.Mutex
+	failing   bool
+	checkouts []payment.CheckoutRequest
+	refunds   []fakeRefund
+}
+
+type fakeNotification struct {
+	OrderID  string         `json:"order_id"`
+	Status   payment.Status `json:"status"`
+	Amount   int64          `json:"amount"`
+	Currency string         `json:"currency"`
+}
+
+type fakeRefund struct {
+	OrderID        string
+	Amount         money.Money
+	IdempotencyKey string
+}
+
+const fakeSignature = "signed"
+
+func (g *fakeGateway) Name() string { return "fake" }
+
+func (g *fakeGateway) SupportsCurrency(code string) bool { return code == "IDR" || code == "USD" }
+
+func (g *fakeGateway) CreateCheckout(req payment.CheckoutRequest) (*payment.Checkout, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failing {
+		return nil, errors.New("gateway down")
+	}
+	g.checkouts = append(g.checkouts, req)
+	return &payment.Checkout{RedirectURL: "https://pay.example.com/" + req.OrderID, Token: req.OrderID}, nil
+}
+
+func (g *fakeGateway) VerifyNotification(header http.Header, body []byte) (*payment.Transaction, error) {
+	var n fakeNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, payment.ErrInvalidNotification
+	}
+	if header.Get("X-Fake-Signature") != fakeSignature {
+		return nil, payment.ErrInvalidSignature
+	}
+	return &payment.Transaction{
+		OrderID:   n.OrderID,
+		Status:    n.Status,
+		RawStatus: string(n.Status),
+		Gross:     money.Money{Amount: n.Amount, Currency: n.Currency},
+	}, nil
+}
+
+func (g *fakeGateway) FetchStatus(string) (*payment.Transaction, error) {
+	return nil, payment.ErrNotFound
+}
+
+func (g *fakeGateway) Refund(orderID string, amount money.Money, reason, idempotencyKey string) (*payment.Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failing {
+		return nil, errors.New("gateway down")
+	}
+	g.refunds = append(g.refunds, fakeRefund{OrderID: orderID, Amount: amount, IdempotencyKey: idempotencyKey})
+	return &payment.Refund{RefundID: fmt.Sprintf("refund-%d", len(g.refunds)), Amount: amount}, nil
+}
+
+// testServer is the API routes these tests exercise, wired to in-memory
+// repositories.
+type testServer struct {
+	t       *testing.T
+	router  *gin.Engine
+	repos   repository.Repos
+	mail    *recordingMailer
+	gateway *fakeGateway
+	hub     *ws.Hub
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s := &testServer{
+		t:       t,
+		router:  gin.New(),
+		repos:   repository.NewMemory(),
+		mail:    &recordingMailer{},
+		gateway: &fakeGateway{},
+		hub:     ws.NewHub(),
+	}
+	go s.hub.Run()
+
+	auth := NewAuthHandler(s.repos.Users, s.repos.Sessions, s.mail, AuthConfig{
+		JwtSecret:       testJWTSecret,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: time.Hour,
+		PublicBaseURL:   "http://api.test",
+		FrontendBaseURL: "http://app.test",
+		TOTPIssuer:      "Test",
+		DeletionGrace:   24 * time.Hour,
+	})
+	creators := NewCreatorHandler(s.repos, s.hub, "http://overlay.test")
+	donations := NewDonationHandler(s.repos, payment.NewRegistry(s.gateway), DonationConfig{
+		OrderIDPrefix:    "TEST-",
+		DefaultCurrency:  "IDR",
+		DefaultMinAmount: 1000,
+	})
+	payouts := NewPayoutHandler(s.repos, money.Money{Amount: 50000, Currency: "IDR"})
+	fees := NewFeeHandler(s.repos)
+	overlay := NewWebSocketHandler(s.repos, s.hub, ws.DefaultPumpConfig())
+
+	api := s.router.Group("/api")
+	api.POST("/auth/register", auth.Register)
+	api.POST("/auth/login", auth.Login)
+	api.POST("/auth/refresh", auth.Refresh)
+	api.POST("/auth/logout", auth.Logout)
+	api.GET("/auth/verify", auth.VerifyEmail)
+	api.POST("/auth/forgot-password", auth.ForgotPassword)
+	api.POST("/auth/reset-password", auth.ResetPassword)
+	api.POST("/auth/restore", auth.RestoreAccount)
+	api.GET("/creators/:username", donations.GetCreatorPage)
+	api.POST("/donate/:username", donations.CreateDonation)
+	api.POST("/webhook/payment", donations.HandlePaymentNotification)
+	api.POST("/webhook/payment/:gateway", donations.HandlePaymentNotification)
+
+	protected := api.Group("/")
+	protected.Use(middleware.AuthMiddleware(testJWTSecret, s.repos.Sessions))
+	protected.GET("/me", creators.GetMyProfile)
+	protected.PATCH("/me", creators.UpdateMyProfile)
+	protected.DELETE("/me", auth.DeleteAccount)
+	protected.POST("/me/password", auth.ChangePassword)
+	protected.POST("/me/email", auth.ChangeEmail)
+	protected.GET("/me/donations", creators.GetMyDonations)
+	protected.GET("/me/donations/export", creators.ExportMyDonations)
+	protected.POST("/me/widget-token/rotate", creators.RotateWidgetToken)
+	protected.GET("/me/currencies", donations.GetMyCurrencies)
+	protected.PUT("/me/currencies/:currency", donations.SetMyCurrency)
+	protected.GET("/me/sessions", auth.GetMySessions)
+	protected.DELETE("/me/sessions/:id", auth.RevokeSession)
+	protected.POST("/me/donations/:order_id/refund", donations.RefundDonation)
+	protected.GET("/me/balance", payouts.GetMyBalance)
+	protected.GET("/me/bank-accounts", payouts.GetMyBankAccounts)
+	protected.POST("/me/bank-accounts", payouts.AddBankAccount)
+	protected.GET("/me/payouts", payouts.GetMyPayouts)
+	protected.POST("/me/payouts", payouts.RequestPayout)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AdminMiddleware(testAdminAPIKey))
+	admin.POST("/donations/:order_id/refund", donations.AdminRefundDonation)
+	admin.GET("/payouts", payouts.ListPayouts)
+	admin.POST("/payouts/:id/approve", payouts.ApprovePayout)
+	admin.POST("/payouts/:id/paid", payouts.MarkPayoutPaid)
+	admin.POST("/payouts/:id/fail", payouts.FailPayout)
+	admin.GET("/fee-schedules", fees.ListFeeSchedules)
+	admin.PUT("/fee-schedules/:creator_id/:currency", fees.SetFeeSchedule)
+	admin.DELETE("/fee-schedules/:creator_id/:currency", fees.DeleteFeeSchedule)
+	admin.GET("/metrics/websocket", overlay.Stats)
+
+	s.router.GET("/ws/:secretToken", overlay.ServerWs)
+
+	return s
+}
+
+// do sends a JSON request and decodes the JSON response into out, if
+// given. header alternates names and values.
+func (s *testServer) do(method, path, token string, body any, out any, header ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decode %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+type loginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// register creates a creator and, if verified, opens the emailed link.
+func (s *testServer) register(username string, verified bool) {
+	s.t.Helper()
+
+	w := s.do("POST", "/api/auth/register", "", RegisterRequest{
+		Email:       username + "@example.com",
+		Password:    "correct horse",
+		Username:    username,
+		DisplayName: "Creator " + username,
+	}, nil)
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("register %s: status %d: %s", username, w.Code, w.Body)
+	}
+	if verified {
+		w := s.do("GET", "/api/auth/verify?token="+url.QueryEscape(s.mail.lastToken(s.t)), "", nil, nil)
+		if w.Code != http.StatusOK {
+			s.t.Fatalf("verify %s: status %d: %s", username, w.Code, w.Body)
+		}
+	}
+}
+
+// admin sends a request with the admin key.
+func (s *testServer) admin(method, path string, body any, out any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.do(method, path, "", body, out, "X-Admin-Key", testAdminAPIKey)
+}
+
+// donate makes a donation to the creator and has the gateway report it
+// paid, returning its order ID.
+func (s *testServer) donate(username string, amount int64) string {
+	s.t.Helper()
+
+	var resp struct {
+		OrderID string `json:"order_id"`
+	}
+	w := s.do("POST", "/api/donate/"+username, "", CreateDonationRequest{Amount: amount, DonorName: "Bob", DonorMessage: "hi"}, &resp)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("donate to %s: status %d: %s", username, w.Code, w.Body)
+	}
+	w = s.notify(fakeNotification{OrderID: resp.OrderID, Status: payment.StatusPaid, Amount: amount, Currency: "IDR"})
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("settle %s: status %d: %s", resp.OrderID, w.Code, w.Body)
+	}
+	return resp.OrderID
+}
+
+// notify sends a signed webhook from the fake gateway.
+func (s *testServer) notify(n fakeNotification) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.do("POST", "/api/webhook/payment/fake", "", n, nil, "X-Fake-Signature", fakeSignature)
+}
+
+// login returns the tokens for a user made by register.
+func (s *testServer) login(username string) loginResponse {
+	s.t.Helper()
+
+	var resp loginResponse
+	w := s.do("POST", "/api/auth/login", "", LoginRequest{Email: username + "@example.com", Password: "correct horse"}, &resp)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("login %s: status %d: %s", username, w.Code, w.Body)
+	}
+	return resp
+}
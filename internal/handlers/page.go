@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/repository"
+)
+
+// UpdateProfileRequest changes the fields that are present and leaves the
+// rest alone.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=100"`
+	Username    *string `json:"username" binding:"omitempty,min=3,max=30"`
+
+	Bio *string `json:"bio" binding:"omitempty,max=500"`
+	// An empty AvatarURL or AccentColor removes it
+	AvatarURL   *string              `json:"avatar_url" binding:"omitempty,max=2048"`
+	SocialLinks *[]models.SocialLink `json:"social_links" binding:"omitempty,max=10"`
+	Theme       *string              `json:"theme" binding:"omitempty,oneof=light dark"`
+	AccentColor *string              `json:"accent_color" binding:"omitempty,hexcolor"`
+	Goal        *GoalRequest         `json:"goal"`
+}
+
+// GoalRequest sets the donation goal. A zero Amount removes it.
+type GoalRequest struct {
+	Title    string `json:"title" binding:"max=100"`
+	Amount   int64  `json:"amount" binding:"gte=0"`
+	Currency string `json:"currency"`
+}
+
+// PageSettings is how a creator's donation page looks.
+type PageSettings struct {
+	Bio         string              `json:"bio"`
+	AvatarURL   *string             `json:"avatar_url"`
+	SocialLinks []models.SocialLink `json:"social_links"`
+	Theme       string              `json:"theme"`
+	AccentColor *string             `json:"accent_color"`
+	Goal        *GoalResponse       `json:"goal"`
+}
+
+// GoalResponse reports progress towards a goal. Raised counts settled
+// donations in the goal's currency since the goal was set, before fees.
+type GoalResponse struct {
+	Title    string `json:"title"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Raised   int64  `json:"raised"`
+}
+
+// CreatorPageResponse is a creator's public profile. It must never carry
+// the widget token or the email.
+type CreatorPageResponse struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	PageSettings
+	Currencies []CreatorCurrencyResponse `json:"currencies"`
+}
+
+// pageSettings builds the page of a creator, looking up goal progress.
+func pageSettings(donations repository.DonationRepo, creator models.Creator) (PageSettings, error) {
+	page := PageSettings{
+		Bio:         creator.Bio,
+		AvatarURL:   creator.AvatarURL,
+		SocialLinks: creator.SocialLinks,
+		Theme:       creator.Theme,
+		AccentColor: creator.AccentColor,
+	}
+	if page.SocialLinks == nil {
+		page.SocialLinks = []models.SocialLink{}
+	}
+
+	if creator.GoalAmount != nil && creator.GoalCurrency != nil && creator.GoalStartedAt != nil {
+		raised, err := donations.SettledTotalSince(creator.ID, *creator.GoalCurrency, *creator.GoalStartedAt)
+		if err != nil {
+			return PageSettings{}, err
+		}
+		page.Goal = &GoalResponse{
+			Amount:   *creator.GoalAmount,
+			Currency: *creator.GoalCurrency,
+			Raised:   raised,
+		}
+		if creator.GoalTitle != nil {
+			page.Goal.Title = *creator.GoalTitle
+		}
+	}
+	return page, nil
+}
+
+// usernamePattern keeps usernames safe to put in a URL path.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateUsername checks a username chosen at registration or on the
+// profile. The error is meant for the client.
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username may only contain letters, digits, '_' and '-'")
+	}
+	// Purged accounts were once renamed deleted-<id>
+	if strings.HasPrefix(strings.ToLower(username), "deleted-") {
+		return errors.New("usernames starting with 'deleted-' are reserved")
+	}
+	return nil
+}
+
+// applyProfileUpdate copies the fields present in req onto the creator.
+// The error is meant for the client.
+func applyProfileUpdate(creator *models.Creator, req UpdateProfileRequest, now time.Time) error {
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if displayName == "" {
+			return errors.New("display_name cannot be empty")
+		}
+		creator.DisplayName = displayName
+	}
+
+	if req.Username != nil {
+		if err := validateUsername(*req.Username); err != nil {
+			return err
+		}
+		creator.Username = *req.Username
+	}
+
+	if req.Bio != nil {
+		creator.Bio = strings.TrimSpace(*req.Bio)
+	}
+
+	if req.AvatarURL != nil {
+		creator.AvatarURL = nil
+		if *req.AvatarURL != "" {
+			if !isHTTPURL(*req.AvatarURL) {
+				return errors.New("avatar_url must be an http or https URL")
+			}
+			creator.AvatarURL = req.AvatarURL
+		}
+	}
+
+	if req.SocialLinks != nil {
+		links := models.SocialLinks{}
+		for _, link := range *req.SocialLinks {
+			label := strings.TrimSpace(link.Label)
+			if label == "" || len(label) > 32 {
+				return errors.New("each social link needs a label of at most 32 characters")
+			}
+			if len(link.URL) > 2048 || !isHTTPURL(link.URL) {
+				return errors.New("each social link needs an http or https URL")
+			}
+			links = append(links, models.SocialLink{Label: label, URL: link.URL})
+		}
+		creator.SocialLinks = links
+	}
+
+	if req.Theme != nil {
+		creator.Theme = *req.Theme
+	}
+
+	if req.AccentColor != nil {
+		creator.AccentColor = nil
+		if *req.AccentColor != "" {
+			color := strings.ToLower(*req.AccentColor)
+			creator.AccentColor = &color
+		}
+	}
+
+	if req.Goal != nil {
+		if req.Goal.Amount == 0 {
+			creator.GoalTitle, creator.GoalAmount, creator.GoalCurrency, creator.GoalStartedAt = nil, nil, nil, nil
+			return nil
+		}
+
+		currency, err := money.Lookup(req.Goal.Currency)
+		if err != nil {
+			return errors.New("goal currency is not supported")
+		}
+		title := strings.TrimSpace(req.Goal.Title)
+
+		// Progress starts over only when the goal itself changes
+		changed := creator.GoalAmount == nil || *creator.GoalAmount != req.Goal.Amount ||
+			creator.GoalCurrency == nil || *creator.GoalCurrency != currency.Code
+		if changed || creator.GoalStartedAt == nil {
+			creator.GoalStartedAt = &now
+		}
+		creator.GoalTitle = &title
+		creator.GoalAmount = &req.Goal.Amount
+		creator.GoalCurrency = &currency.Code
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// UpdateMyProfile edits the creator's name, username and donation page.
+func (h *CreatorHandler) UpdateMyProfile(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return
+	}
+
+	if err := applyProfileUpdate(&creator, req, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creator, err = h.Creators.UpdateProfile(creator)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken."})
+		return
+	} else if err != nil {
+		log.Println("Failed to update creator profile:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	h.writeProfile(c, creator)
+}
+
+// GetCreatorPage returns the public profile donors see before donating.
+// Like donating, it is only available once the creator verified their
+// email.
+func (h *DonationHandler) GetCreatorPage(c *gin.Context) {
+	creator, err := h.Creators.GetVerifiedByUsername(c.Param("username"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator not found"})
+		return
+	} else if err != nil {
+		log.Println("Failed to get creator page:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	page, err := pageSettings(h.Donations, creator)
+	if err != nil {
+		log.Println("Failed to get goal progress:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	accepted, err := h.acceptedCurrencies(creator.ID)
+	if err != nil {
+		log.Println("Failed to get accepted currencies:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	currencies := make([]CreatorCurrencyResponse, len(accepted))
+	for i, a := range accepted {
+		currencies[i] = CreatorCurrencyResponse{Currency: a.Currency, MinAmount: a.MinAmount, MaxAmount: a.MaxAmount}
+	}
+
+	c.JSON(http.StatusOK, CreatorPageResponse{
+		Username:     creator.Username,
+		DisplayName:  creator.DisplayName,
+		PageSettings: page,
+		Currencies:   currencies,
+	})
+}
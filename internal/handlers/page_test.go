@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreatorPage(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+
+	update := map[string]any{
+		"bio":          "  I stream speedruns  ",
+		"avatar_url":   "https://cdn.example.com/alice.png",
+		"social_links": []map[string]string{{"label": "YouTube", "url": "https://youtube.com/@alice"}},
+		"theme":        "dark",
+		"accent_color": "#FF8800",
+		"goal":         map[string]any{"title": "New mic", "amount": 1500000, "currency": "idr"},
+	}
+	var profile ProfileResponse
+	if w := s.do("PATCH", "/api/me", token, update, &profile); w.Code != http.StatusOK {
+		t.Fatalf("PATCH /me: status = %d: %s", w.Code, w.Body)
+	}
+	if profile.Bio != "I stream speedruns" || profile.Theme != "dark" || *profile.AccentColor != "#ff8800" {
+		t.Errorf("profile = %+v", profile.PageSettings)
+	}
+
+	w := s.do("GET", "/api/creators/alice", "", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /creators/alice: status = %d: %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); strings.Contains(body, profile.WidgetSecretToken) || strings.Contains(body, "alice@example.com") {
+		t.Errorf("public page leaks the widget token or email: %s", body)
+	}
+
+	var page CreatorPageResponse
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if page.DisplayName != "Creator alice" || *page.AvatarURL != "https://cdn.example.com/alice.png" ||
+		len(page.SocialLinks) != 1 || page.SocialLinks[0].Label != "YouTube" {
+		t.Errorf("page = %+v", page)
+	}
+	if page.Goal == nil || page.Goal.Amount != 1500000 || page.Goal.Currency != "IDR" || page.Goal.Raised != 0 {
+		t.Errorf("goal = %+v", page.Goal)
+	}
+	if len(page.Currencies) != 1 || page.Currencies[0].Currency != "IDR" || page.Currencies[0].MinAmount != 1000 {
+		t.Errorf("currencies = %+v", page.Currencies)
+	}
+}
+
+func TestUpdateMyProfileKeepsAbsentFields(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+
+	s.do("PATCH", "/api/me", token, map[string]any{
+		"bio":  "hello",
+		"goal": map[string]any{"amount": 50000, "currency": "IDR"},
+	}, nil)
+
+	var profile ProfileResponse
+	s.do("PATCH", "/api/me", token, map[string]any{"theme": "dark"}, &profile)
+	if profile.Bio != "hello" || profile.Goal == nil || profile.Theme != "dark" {
+		t.Errorf("profile = %+v", profile.PageSettings)
+	}
+
+	s.do("PATCH", "/api/me", token, map[string]any{"goal": map[string]any{"amount": 0}}, &profile)
+	if profile.Goal != nil {
+		t.Errorf("goal = %+v, want removed", profile.Goal)
+	}
+}
+
+func TestUpdateMyProfileValidates(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	token := s.login("alice").Token
+
+	tests := []struct {
+		name   string
+		update map[string]any
+	}{
+		{"script avatar", map[string]any{"avatar_url": "javascript:alert(1)"}},
+		{"unknown theme", map[string]any{"theme": "neon"}},
+		{"bad color", map[string]any{"accent_color": "orange"}},
+		{"long bio", map[string]any{"bio": strings.Repeat("x", 501)}},
+		{"link without label", map[string]any{"social_links": []map[string]string{{"url": "https://x.com/alice"}}}},
+		{"ftp link", map[string]any{"social_links": []map[string]string{{"label": "files", "url": "ftp://example.com"}}}},
+		{"goal currency", map[string]any{"goal": map[string]any{"amount": 100, "currency": "XXX"}}},
+	}
+	for _, tt := range tests {
+		if w := s.do("PATCH", "/api/me", token, tt.update, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCreatorPageNeedsVerifiedCreator(t *testing.T) {
+	s := newTestServer(t)
+	s.register("unverified", false)
+
+	for _, username := range []string{"unverified", "nobody"} {
+		if w := s.do("GET", "/api/creators/"+username, "", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", username, w.Code, http.StatusNotFound)
+		}
+	}
+}
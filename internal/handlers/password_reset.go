@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"my-platform/internal/mailer"
+	"my-platform/internal/models"
+	"my-platform/internal/repository"
+)
+
+const (
+	// resetTokenTTL is how long an emailed reset link stays valid.
+	resetTokenTTL = time.Hour
+	// maxResetsPerHour limits reset emails sent to a single address.
+	maxResetsPerHour = 3
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// forgotPasswordResponse is sent whether or not the email exists, so the
+// endpoint cannot be used to find out who has an account.
+var forgotPasswordResponse = gin.H{"message": "If that email is registered, a reset link has been sent."}
+
+// ForgotPassword emails a single-use reset link to the address, if it
+// belongs to an account and the hourly limit has not been reached.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetByEmail(req.Email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Database error on forgot password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+			return
+		}
+		c.JSON(http.StatusOK, forgotPasswordResponse)
+		return
+	}
+
+	recent, err := h.Users.CountPasswordResetsSince(user.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		log.Println("Failed to count recent password resets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	if recent >= maxResetsPerHour {
+		log.Printf("Password reset rate limit reached for user %d", user.ID)
+		c.JSON(http.StatusOK, forgotPasswordResponse)
+		return
+	}
+
+	token, err := generateSecureToken(resetTokenBytes)
+	if err != nil {
+		log.Println("Reset token generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	err = h.Users.CreatePasswordReset(models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	})
+	if err != nil {
+		log.Println("Failed to store password reset:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	link := h.FrontendBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. Open the link below to choose a new one.\n\n%s\n\n"+
+			"The link expires in %d minutes and can be used once. If this wasn't you, you can ignore this email.\n",
+			link, int(resetTokenTTL.Minutes())),
+	})
+	if err != nil {
+		log.Println("Failed to send password reset email:", err)
+	}
+
+	c.JSON(http.StatusOK, forgotPasswordResponse)
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. On
+// success every reset token of the user is spent and every session revoked.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error, please try again."})
+		return
+	}
+
+	// The token is spent, along with any other outstanding ones, and every
+	// session revoked in the same transaction as the new password
+	_, err = h.Users.RedeemPasswordReset(hashToken(req.Token), string(passwordHash), time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link."})
+			return
+		}
+		log.Println("Failed to reset password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset. Please log in again."})
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/repository"
+)
+
+type PayoutHandler struct {
+	Users        repository.UserRepo
+	Creators     repository.CreatorRepo
+	BankAccounts repository.BankAccountRepo
+	Payouts      repository.PayoutRepo
+	// MinPayout is the smallest payout in its currency; payouts in other
+	// currencies only need to be positive.
+	MinPayout money.Money
+}
+
+func NewPayoutHandler(repos repository.Repos, minPayout money.Money) *PayoutHandler {
+	return &PayoutHandler{
+		Users:        repos.Users,
+		Creators:     repos.Creators,
+		BankAccounts: repos.BankAccounts,
+		Payouts:      repos.Payouts,
+		MinPayout:    minPayout,
+	}
+}
+
+type BankAccountRequest struct {
+	BankCode          string `json:"bank_code" binding:"required,max=32"`
+	AccountNumber     string `json:"account_number" binding:"required,numeric,min=5,max=34"`
+	AccountHolderName string `json:"account_holder_name" binding:"required,max=100"`
+}
+
+type BankAccountResponse struct {
+	ID                int       `json:"id"`
+	BankCode          string    `json:"bank_code"`
+	AccountNumber     string    `json:"account_number"`
+	AccountHolderName string    `json:"account_holder_name"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type PayoutRequest struct {
+	BankAccountID int    `json:"bank_account_id" binding:"required"`
+	Amount        int64  `json:"amount" binding:"required,gt=0"`
+	Currency      string `json:"currency" binding:"required"`
+}
+
+type PayoutResponse struct {
+	ID            int        `json:"id"`
+	CreatorID     int        `json:"creator_id"`
+	BankAccountID int        `json:"bank_account_id"`
+	Amount        int64      `json:"amount"`
+	Currency      string     `json:"currency"`
+	Status        string     `json:"status"`
+	FailureReason *string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ApprovedAt    *time.Time `json:"approved_at,omitempty"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+}
+
+func newPayoutResponses(payouts []models.Payout) []PayoutResponse {
+	response := make([]PayoutResponse, len(payouts))
+	for i, p := range payouts {
+		response[i] = PayoutResponse{
+			ID:            p.ID,
+			CreatorID:     p.CreatorID,
+			BankAccountID: p.BankAccountID,
+			Amount:        p.Amount,
+			Currency:      p.Currency,
+			Status:        p.Status,
+			FailureReason: p.FailureReason,
+			CreatedAt:     p.CreatedAt,
+			ApprovedAt:    p.ApprovedAt,
+			PaidAt:        p.PaidAt,
+		}
+	}
+	return response
+}
+
+type FailPayoutRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// maskAccountNumber hides all but the last four digits.
+func maskAccountNumber(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	masked := make([]byte, len(number))
+	for i := range masked {
+		masked[i] = '*'
+	}
+	copy(masked[len(number)-4:], number[len(number)-4:])
+	return string(masked)
+}
+
+// creatorForUser looks up the logged-in user's creator ID, answering the
+// request itself if there is none.
+func (h *PayoutHandler) creatorForUser(c *gin.Context) (int, bool) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	creator, err := h.Creators.GetByUserID(userID)
+	if err != nil {
+		log.Println("Failed to find creator for user_id:", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Creator profile not found"})
+		return 0, false
+	}
+	return creator.ID, true
+}
+
+// GetMyBalance returns what the creator is owed and their lifetime totals,
+// one entry per currency.
+func (h *PayoutHandler) GetMyBalance(c *gin.Context) {
+	creatorID, ok := h.creatorForUser(c)
+	if !ok {
+		return
+	}
+
+	balances, err := h.Payouts.Balances(creatorID)
+	if err != nil {
+		log.Println("Failed to compute balance:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, balances)
+}
+
+func (h *PayoutHandler) GetMyBankAccounts(c *gin.Context) {
+	creatorID, ok := h.creatorForUser(c)
+	if !ok {
+		return
+	}
+
+	accounts, err := h.BankAccounts.ListByCreator(creatorID)
+	if err != nil {
+		log.Println("Failed to get bank accounts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch bank accounts"})
+		return
+	}
+
+	response := make([]BankAccountResponse, len(accounts))
+	for i, a := range accounts {
+		response[i] = BankAccountResponse{
+			ID:                a.ID,
+			BankCode:          a.BankCode,
+			AccountNumber:     maskAccountNumber(a.AccountNumber),
+			AccountHolderName: a.AccountHolderName,
+			CreatedAt:         a.CreatedAt,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PayoutHandler) AddBankAccount(c *gin.Context) {
+	creatorID, ok := h.creatorForUser(c)
+	if !ok {
+		return
+	}
+
+	var req BankAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	account, err := h.BankAccounts.Create(models.BankAccount{
+		CreatorID:         creatorID,
+		BankCode:          req.BankCode,
+		AccountNumber:     req.AccountNumber,
+		AccountHolderName: req.AccountHolderName,
+	})
+	if err != nil {
+		log.Println("Failed to add bank account:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusCreated, BankAccountResponse{
+		ID:                account.ID,
+		BankCode:          req.BankCode,
+		AccountNumber:     maskAccountNumber(req.AccountNumber),
+		AccountHolderName: req.AccountHolderName,
+		CreatedAt:         account.CreatedAt,
+	})
+}
+
+func (h *PayoutHandler) GetMyPayouts(c *gin.Context) {
+	creatorID, ok := h.creatorForUser(c)
+	if !ok {
+		return
+	}
+
+	payouts, err := h.Payouts.ListByCreator(creatorID)
+	if err != nil {
+		log.Println("Failed to get payouts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch payouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newPayoutResponses(payouts))
+}
+
+// RequestPayout reserves part of the available balance for a transfer to
+// one of the creator's bank accounts. Only verified accounts can withdraw.
+func (h *PayoutHandler) RequestPayout(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	var req PayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	amount, err := money.New(req.Amount, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown currency"})
+		return
+	}
+	if amount.Currency == h.MinPayout.Currency && amount.Amount < h.MinPayout.Amount {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payouts must be at least " + h.MinPayout.String() + "."})
+		return
+	}
+
+	creatorID, ok := h.creatorForUser(c)
+	if !ok {
+		return
+	}
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email before requesting a payout."})
+		return
+	}
+
+	payout, err := h.Payouts.Request(creatorID, req.BankAccountID, amount)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown bank account."})
+		return
+	} else if errors.Is(err, repository.ErrInsufficientFunds) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount exceeds your available balance.", "available": h.available(creatorID, amount.Currency)})
+		return
+	} else if err != nil {
+		log.Println("Failed to create payout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":       payout.ID,
+		"status":   payout.Status,
+		"amount":   payout.Amount,
+		"currency": payout.Currency,
+	})
+}
+
+// available is what the creator can withdraw in the currency, for telling
+// them why a payout was refused.
+func (h *PayoutHandler) available(creatorID int, currency string) int64 {
+	balances, err := h.Payouts.Balances(creatorID)
+	if err != nil {
+		log.Println("Failed to compute balance:", err)
+		return 0
+	}
+	for _, b := range balances {
+		if b.Currency == currency {
+			return b.Available
+		}
+	}
+	return 0
+}
+
+// ListPayouts lets admins review payouts, optionally filtered by status.
+func (h *PayoutHandler) ListPayouts(c *gin.Context) {
+	payouts, err := h.Payouts.List(c.Query("status"))
+	if err != nil {
+		log.Println("Failed to list payouts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch payouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newPayoutResponses(payouts))
+}
+
+func (h *PayoutHandler) ApprovePayout(c *gin.Context) {
+	h.movePayout(c, models.PayoutApproved, "")
+}
+
+func (h *PayoutHandler) MarkPayoutPaid(c *gin.Context) {
+	h.movePayout(c, models.PayoutPaid, "")
+}
+
+func (h *PayoutHandler) FailPayout(c *gin.Context) {
+	var req FailPayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	h.movePayout(c, models.PayoutFailed, req.Reason)
+}
+
+// movePayout applies an admin decision to a payout and books it: paid
+// moves the money out of transit, failed returns it to the creator.
+func (h *PayoutHandler) movePayout(c *gin.Context, to, reason string) {
+	payoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout ID"})
+		return
+	}
+
+	payout, err := h.Payouts.Move(payoutID, to, reason)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+		return
+	} else if errors.Is(err, repository.ErrInvalidTransition) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payout is " + payout.Status + " and cannot become " + to + "."})
+		return
+	} else if err != nil {
+		log.Println("Failed to move payout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	log.Printf("Payout %d moved %s -> %s", payout.ID, payout.Status, to)
+	c.JSON(http.StatusOK, gin.H{"id": payout.ID, "status": to})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+)
+
+// balance returns the creator's IDR balance.
+func (s *testServer) balance(token string) ledger.Balance {
+	s.t.Helper()
+
+	var balances []ledger.Balance
+	if w := s.do("GET", "/api/me/balance", token, nil, &balances); w.Code != http.StatusOK {
+		s.t.Fatalf("balance: status %d: %s", w.Code, w.Body)
+	}
+	for _, b := range balances {
+		if b.Currency == "IDR" {
+			return b
+		}
+	}
+	return ledger.Balance{Currency: "IDR"}
+}
+
+// addBankAccount adds a bank account for the creator and returns its ID.
+func (s *testServer) addBankAccount(token string) int {
+	s.t.Helper()
+
+	var account BankAccountResponse
+	w := s.do("POST", "/api/me/bank-accounts", token, BankAccountRequest{
+		BankCode:          "BCA",
+		AccountNumber:     "1234567890",
+		AccountHolderName: "Alice",
+	}, &account)
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("add bank account: status %d: %s", w.Code, w.Body)
+	}
+	return account.ID
+}
+
+func TestBankAccounts(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.register("carol", true)
+	token := s.login("alice").Token
+	s.addBankAccount(token)
+
+	if w := s.do("POST", "/api/me/bank-accounts", token, BankAccountRequest{BankCode: "BCA", AccountNumber: "12ab5", AccountHolderName: "Alice"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("non-numeric account: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var accounts []BankAccountResponse
+	s.do("GET", "/api/me/bank-accounts", token, nil, &accounts)
+	if len(accounts) != 1 || accounts[0].AccountNumber != "******7890" {
+		t.Errorf("accounts = %+v", accounts)
+	}
+	s.do("GET", "/api/me/bank-accounts", s.login("carol").Token, nil, &accounts)
+	if len(accounts) != 0 {
+		t.Errorf("carol sees %d accounts", len(accounts))
+	}
+}
+
+func TestPayoutLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.donate("alice", 120000)
+	token := s.login("alice").Token
+	accountID := s.addBankAccount(token)
+
+	if w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID + 1, Amount: 60000, Currency: "IDR"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown bank account: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 1000, Currency: "IDR"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("below the minimum: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var paid, failed struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	if w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 60000, Currency: "IDR"}, &paid); w.Code != http.StatusCreated {
+		t.Fatalf("request: status = %d: %s", w.Code, w.Body)
+	}
+	if paid.Status != models.PayoutRequested {
+		t.Errorf("status = %q", paid.Status)
+	}
+	if b := s.balance(token); b.Available != 60000 || b.InTransit != 60000 {
+		t.Errorf("after request: %+v", b)
+	}
+
+	var listed []PayoutResponse
+	s.admin("GET", "/api/admin/payouts?status=requested", nil, &listed)
+	if len(listed) != 1 || listed[0].ID != paid.ID || listed[0].BankAccountID != accountID {
+		t.Errorf("requested payouts = %+v", listed)
+	}
+
+	approve := fmt.Sprintf("/api/admin/payouts/%d/approve", paid.ID)
+	if w := s.admin("POST", approve, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d: %s", w.Code, w.Body)
+	}
+	if w := s.admin("POST", approve, nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("approve twice: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := s.admin("POST", fmt.Sprintf("/api/admin/payouts/%d/paid", paid.ID), nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("paid: status = %d: %s", w.Code, w.Body)
+	}
+	if b := s.balance(token); b.Available != 60000 || b.InTransit != 0 || b.PaidOut != 60000 {
+		t.Errorf("after paid: %+v", b)
+	}
+
+	if w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 50000, Currency: "IDR"}, &failed); w.Code != http.StatusCreated {
+		t.Fatalf("second request: status = %d: %s", w.Code, w.Body)
+	}
+	fail := fmt.Sprintf("/api/admin/payouts/%d/fail", failed.ID)
+	if w := s.admin("POST", fail, nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("fail without reason: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := s.admin("POST", fail, FailPayoutRequest{Reason: "account closed"}, nil); w.Code != http.StatusOK {
+		t.Fatalf("fail: status = %d: %s", w.Code, w.Body)
+	}
+	if b := s.balance(token); b.Available != 60000 || b.InTransit != 0 || b.PaidOut != 60000 {
+		t.Errorf("after failed: %+v", b)
+	}
+
+	s.do("GET", "/api/me/payouts", token, nil, &listed)
+	if len(listed) != 2 || listed[0].Status != models.PayoutFailed || listed[0].FailureReason == nil ||
+		listed[1].Status != models.PayoutPaid || listed[1].PaidAt == nil {
+		t.Errorf("my payouts = %+v", listed)
+	}
+
+	if w := s.admin("POST", "/api/admin/payouts/999/approve", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown payout: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := s.do("GET", "/api/admin/payouts", "", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("no admin key: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequestPayoutNeedsVerifiedEmail(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", false)
+	token := s.login("alice").Token
+	accountID := s.addBankAccount(token)
+
+	var resp struct {
+		Error string `json:"error"`
+	}
+	w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 60000, Currency: "IDR"}, &resp)
+	if w.Code != http.StatusForbidden || !strings.Contains(resp.Error, "verify your email") {
+		t.Errorf("status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestPayoutRefusesMoreThanAvailable(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	s.donate("alice", 100000)
+	token := s.login("alice").Token
+	accountID := s.addBankAccount(token)
+
+	var first struct {
+		ID int `json:"id"`
+	}
+	s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 60000, Currency: "IDR"}, &first)
+
+	var resp struct {
+		Error     string `json:"error"`
+		Available int64  `json:"available"`
+	}
+	w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 50000, Currency: "IDR"}, &resp)
+	if w.Code != http.StatusBadRequest || resp.Available != 40000 {
+		t.Errorf("over the balance: status = %d: %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 60000, Currency: "USD"}, &resp)
+	if w.Code != http.StatusBadRequest || resp.Available != 0 {
+		t.Errorf("no USD balance: status = %d: %s", w.Code, w.Body)
+	}
+
+	// A failed payout frees its amount again
+	s.admin("POST", fmt.Sprintf("/api/admin/payouts/%d/fail", first.ID), FailPayoutRequest{Reason: "bounced"}, nil)
+	if w := s.do("POST", "/api/me/payouts", token, PayoutRequest{BankAccountID: accountID, Amount: 100000, Currency: "IDR"}, nil); w.Code != http.StatusCreated {
+		t.Errorf("after the failure: status = %d: %s", w.Code, w.Body)
+	}
+	if b := s.balance(token); b.Available != 0 || b.InTransit != 100000 {
+		t.Errorf("balance = %+v", b)
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"my-platform/internal/models"
+	"my-platform/internal/repository"
+)
+
+// A refresh token is "<session id>.<secret>". The session ID lets us find
+// the row directly; only a hash of the secret is stored.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type SessionResponse struct {
+	ID         string    `db:"id" json:"id"`
+	UserAgent  string    `db:"user_agent" json:"user_agent"`
+	IPAddress  string    `db:"ip_address" json:"ip_address"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	LastUsedAt time.Time `db:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time `db:"expires_at" json:"expires_at"`
+	Current    bool      `db:"-" json:"current"`
+}
+
+func splitRefreshToken(token string) (sessionID, secret string, ok bool) {
+	sessionID, secret, ok = strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return "", "", false
+	}
+	return sessionID, secret, true
+}
+
+// startSession stores a new session for the user and returns the access
+// token and refresh token to hand to the client.
+func (h *AuthHandler) startSession(c *gin.Context, user models.User) (string, string, error) {
+	secret, err := generateSecureToken(refreshTokenBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	sessionID := uuid.NewString()
+	err = h.Sessions.Create(models.Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(secret),
+		UserAgent:        c.Request.UserAgent(),
+		IPAddress:        c.ClientIP(),
+		ExpiresAt:        time.Now().Add(h.RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := h.createJWT(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, sessionID + "." + secret, nil
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh
+// token is rotated on every use; presenting an already rotated one means it
+// was copied, so the whole session is revoked.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	sessionID, secret, ok := splitRefreshToken(req.RefreshToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		return
+	}
+
+	newSecret, err := generateSecureToken(refreshTokenBytes)
+	if err != nil {
+		log.Println("Refresh token generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	session, err := h.Sessions.Rotate(sessionID, hashToken(secret), hashToken(newSecret), c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		case errors.Is(err, repository.ErrSessionInactive):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again."})
+		case errors.Is(err, repository.ErrTokenReused):
+			log.Printf("SECURITY: refresh token reuse detected for session %s, revoking", sessionID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		default:
+			log.Println("Failed to rotate refresh token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		}
+		return
+	}
+
+	user, err := h.Users.GetByID(session.UserID)
+	if err != nil {
+		log.Println("Failed to load user for session:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		return
+	}
+
+	accessToken, err := h.createJWT(user, session.ID)
+	if err != nil {
+		log.Println("Failed to create JWT:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": session.ID + "." + newSecret,
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+	})
+}
+
+// Logout revokes the session the refresh token belongs to. It takes the
+// refresh token rather than the access token so it still works once the
+// access token has expired.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	sessionID, secret, ok := splitRefreshToken(req.RefreshToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token."})
+		return
+	}
+
+	if err := h.Sessions.RevokeByToken(sessionID, hashToken(secret)); err != nil {
+		log.Println("Failed to revoke session on logout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	// Logging out an unknown or already revoked session is not an error
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out."})
+}
+
+// GetMySessions lists the user's active sessions, flagging the one making
+// this request.
+func (h *AuthHandler) GetMySessions(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+	currentSessionID := c.GetString("sessionID")
+
+	active, err := h.Sessions.ListActive(userID)
+	if err != nil {
+		log.Println("Failed to get sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch sessions"})
+		return
+	}
+
+	sessions := make([]SessionResponse, len(active))
+	for i, s := range active {
+		sessions[i] = SessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == currentSessionID,
+		}
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession ends one of the user's sessions, e.g. a lost device.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	sessionID := c.Param("id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.Sessions.Revoke(sessionID, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		log.Println("Failed to revoke session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked."})
+}
+
+// RevokeOtherSessions signs the user out everywhere except this session.
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	if err := h.Sessions.RevokeAll(userID, c.GetString("sessionID")); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All other sessions revoked."})
+}
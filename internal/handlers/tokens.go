@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"my-platform/internal/models"
+)
+
+// Token sizes in random bytes, both giving 256 bits of entropy.
+const (
+	widgetTokenBytes  = 32
+	refreshTokenBytes = 32
+	resetTokenBytes   = 32
+)
+
+// generateSecureToken returns n cryptographically random bytes encoded as
+// URL-safe base64, so the result can be used directly in a URL path.
+func generateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hex digest of a token. Only the digest is
+// stored, so a database leak does not expose usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Purposes of the single-use JWTs mailed or handed out by AuthHandler. The
+// purpose claim stops e.g. a verification link from being used as a login.
+const (
+	purposeVerifyEmail = "verify_email"
+	purposeTwoFactor   = "two_factor"
+)
+
+var errWrongPurpose = errors.New("token has the wrong purpose")
+
+// signPurposeToken issues a short-lived JWT bound to a user, their current
+// email and a purpose. Binding the email means a link stops working once the
+// address changes.
+func (h *AuthHandler) signPurposeToken(purpose string, user models.User, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":     user.ID,
+		"email":   user.Email,
+		"purpose": purpose,
+		"iat":     time.Now().Unix(),
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(h.JwtSecret))
+}
+
+// parsePurposeToken validates a token from signPurposeToken and returns the
+// user ID and email it was issued for.
+func (h *AuthHandler) parsePurposeToken(tokenString, purpose string) (int, string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(h.JwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return 0, "", err
+	}
+
+	if claims["purpose"] != purpose {
+		return 0, "", errWrongPurpose
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, "", jwt.ErrTokenInvalidClaims
+	}
+	email, _ := claims["email"].(string)
+
+	return int(userID), email, nil
+}
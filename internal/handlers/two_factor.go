@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"my-platform/internal/models"
+	"my-platform/internal/totp"
+)
+
+const (
+	// challengeTTL is how long the user has to enter their code after the
+	// password step of a two-factor login.
+	challengeTTL = 5 * time.Minute
+	// maxTwoFactorAttempts wrong codes lock two-factor logins for
+	// twoFactorLockout, so a 6 digit code cannot be brute forced.
+	maxTwoFactorAttempts = 5
+	twoFactorLockout     = 15 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var errTwoFactorLocked = errors.New("too many invalid two-factor codes")
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest completes a login started with Login. Code may be
+// a code from the authenticator app or one of the recovery codes.
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// generateRecoveryCode returns a code like "k7q2m-x9dfa". Recovery codes
+// are compared lowercased and without the dash.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// verifySecondFactor accepts either a current TOTP code that has not been
+// used before or an unused recovery code, and counts failed attempts.
+func (h *AuthHandler) verifySecondFactor(user models.User, code string) (bool, error) {
+	if user.TOTPLockedUntil != nil && time.Now().Before(*user.TOTPLockedUntil) {
+		return false, errTwoFactorLocked
+	}
+
+	if step, ok := totp.Verify(*user.TOTPSecret, code, time.Now()); ok {
+		// Claiming the step atomically stops the same code from being replayed
+		claimed, err := h.Users.ClaimTOTPStep(user.ID, step)
+		if err != nil || claimed {
+			return claimed, err
+		}
+	} else {
+		used, err := h.Users.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return false, err
+		}
+		if used {
+			log.Printf("User %d signed in with a recovery code", user.ID)
+			return true, nil
+		}
+	}
+
+	// Wrong code: count it, and lock after too many in a row
+	if err := h.Users.RecordTwoFactorFailure(user.ID, maxTwoFactorAttempts, twoFactorLockout); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// EnrollTwoFactor starts enrollment by generating a secret. It is not
+// enforced until confirmed with ConfirmTwoFactor.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user for 2FA enrollment:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled."})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Println("TOTP secret generation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	if err := h.Users.SetTOTPSecret(userID, secret); err != nil {
+		log.Println("Failed to store TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(secret, h.TOTPIssuer, user.Email),
+	})
+}
+
+// ConfirmTwoFactor turns two-factor on once the user proves their app
+// produces valid codes, and returns the recovery codes. They are only
+// shown this once.
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user for 2FA confirmation:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled."})
+		return
+	}
+	if user.TOTPSecret == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first."})
+		return
+	}
+
+	step, ok := totp.Verify(*user.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code."})
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			log.Println("Recovery code generation error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+			return
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := h.Users.EnableTwoFactor(userID, step, hashes); err != nil {
+		log.Println("Failed to enable 2FA:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled.",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns two-factor off. It asks for both the password and
+// a code, so a stolen session alone cannot downgrade the account.
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user for disabling 2FA:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled."})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code."})
+		return
+	}
+
+	ok, err := h.verifySecondFactor(user, req.Code)
+	if err == errTwoFactorLocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later."})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code."})
+		return
+	}
+
+	if err := h.Users.DisableTwoFactor(userID); err != nil {
+		log.Println("Failed to disable 2FA:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled."})
+}
+
+// LoginTwoFactor is the second step of a login for accounts with
+// two-factor enabled. Only here is the session and real token issued.
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	userID, email, err := h.parsePurposeToken(req.ChallengeToken, purposeTwoFactor)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, please log in again."})
+		return
+	}
+
+	// Matching the email as well ignores challenges issued to a previous address
+	user, err := h.Users.GetByID(userID)
+	if err != nil || user.Email != email || user.TOTPEnabledAt == nil || user.TOTPSecret == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, please log in again."})
+		return
+	}
+
+	ok, err := h.verifySecondFactor(user, req.Code)
+	if err == errTwoFactorLocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later."})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code."})
+		return
+	}
+
+	tokenString, refreshToken, err := h.startSession(c, user)
+	if err != nil {
+		log.Println("Failed to start session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful.",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+	})
+}
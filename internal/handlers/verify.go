@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"my-platform/internal/mailer"
+	"my-platform/internal/models"
+	"my-platform/internal/repository"
+)
+
+// verificationTTL is how long an emailed verification link stays valid.
+const verificationTTL = 48 * time.Hour
+
+// sendVerificationEmail mails the user a signed link to GET /api/auth/verify.
+func (h *AuthHandler) sendVerificationEmail(user models.User) error {
+	token, err := h.signPurposeToken(purposeVerifyEmail, user, verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.PublicBaseURL + "/api/auth/verify?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Please confirm your email address by opening the link below.\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+			link, int(verificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail completes registration from the link in the verification email.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token required"})
+		return
+	}
+
+	userID, email, err := h.parsePurposeToken(token, purposeVerifyEmail)
+	if err != nil {
+		log.Println("Invalid verification token:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link."})
+		return
+	}
+
+	// Matching the email as well ignores links sent to a previous address
+	if err := h.Users.MarkEmailVerified(userID, email); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link."})
+			return
+		}
+		log.Println("Failed to verify email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified."})
+}
+
+// ResendVerification mails a fresh verification link to the logged-in user.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID_any, _ := c.Get("userID")
+	userID := userID_any.(int)
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		log.Println("Failed to find user for verification:", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified."})
+		return
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		log.Println("Failed to send verification email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send verification email."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent."})
+}
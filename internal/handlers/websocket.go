@@ -1,14 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/jmoiron/sqlx"
 
-	"my-platform/internal/models"
+	"my-platform/internal/alerts"
+	"my-platform/internal/repository"
 	ws "my-platform/internal/websocket"
 )
 
@@ -19,20 +21,20 @@ var upgrader = websocket.Upgrader{
 }
 
 type WebSocketHandler struct {
-	DB  *sqlx.DB
-	Hub *ws.Hub
+	Creators repository.CreatorRepo
+	Hub      *ws.Hub
+	Alerts   repository.AlertRepo
+	Pump     ws.PumpConfig
 }
 
-func NewWebSocketHandler(db *sqlx.DB, hub *ws.Hub) *WebSocketHandler {
-	return &WebSocketHandler{DB: db, Hub: hub}
+func NewWebSocketHandler(repos repository.Repos, hub *ws.Hub, pump ws.PumpConfig) *WebSocketHandler {
+	return &WebSocketHandler{Creators: repos.Creators, Hub: hub, Alerts: repos.Alerts, Pump: pump}
 }
 
 func (h *WebSocketHandler) ServerWs(c *gin.Context) {
 	secretToken := c.Param("secretToken")
 
-	var creator models.Creator
-	query := `SELECT id FROM creators WHERE widget_secret_token = $1`
-	err := h.DB.Get(&creator, query, secretToken)
+	creator, err := h.Creators.GetByWidgetToken(secretToken)
 	if err != nil {
 		log.Println("Invalid WebSocket secret token:", secretToken)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -56,20 +58,91 @@ func (h *WebSocketHandler) ServerWs(c *gin.Context) {
 
 	go h.writePump(client)
 	go h.readPump(client)
+
+	// Registering first means nothing settled from now on is missed; an alert
+	// may then arrive twice, which the overlay dedupes by message ID.
+	h.replayPending(client)
+}
+
+// replayPending sends alerts that were queued while the overlay was offline.
+func (h *WebSocketHandler) replayPending(client *ws.Client) {
+	pending, err := h.Alerts.Pending(client.CreatorID)
+	if err != nil {
+		log.Println("Failed to load pending alerts:", err)
+		return
+	}
+
+	for _, alert := range pending {
+		h.send(client, ws.NewAlertMessage(alert))
+	}
+
+	if len(pending) > 0 {
+		log.Printf("Replayed %d pending alert(s) to creator %d", len(pending), client.CreatorID)
+	}
+}
+
+// send queues a message for a single connection through the hub, which
+// drops it if the client has disconnected in the meantime.
+func (h *WebSocketHandler) send(client *ws.Client, msg ws.Message) {
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Failed to marshal websocket message:", err)
+		return
+	}
+	h.Hub.Deliver <- ws.Delivery{Client: client, Data: jsonData}
+}
+
+// markDelivered records that an alert reached the overlay. It is only
+// displayed once the overlay acks it.
+func (h *WebSocketHandler) markDelivered(client *ws.Client, alertID int) {
+	if err := h.Alerts.MarkDelivered(client.CreatorID, alertID); err != nil {
+		log.Printf("Failed to record delivery of alert %d: %v", alertID, err)
+	}
+}
+
+// Stats reports how many overlays are connected and how many dead
+// connections the heartbeat has reaped since startup.
+func (h *WebSocketHandler) Stats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Hub.Stats())
 }
 
 func (h *WebSocketHandler) writePump(client *ws.Client) {
+	ticker := time.NewTicker(h.Pump.PingInterval)
 	defer func() {
+		ticker.Stop()
 		client.Conn.Close()
 	}()
 
-	for message := range client.Send {
-		if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			return
+	for {
+		select {
+		case message, ok := <-client.Send:
+			client.Conn.SetWriteDeadline(time.Now().Add(h.Pump.WriteTimeout))
+			if !ok {
+				// The hub closed the channel
+				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				if ws.IsTimeout(err) {
+					client.Hub.RecordReaped(client)
+				}
+				return
+			}
+			if alertID, ok := ws.AlertID(message); ok {
+				h.markDelivered(client, alertID)
+			}
+
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(h.Pump.WriteTimeout))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if ws.IsTimeout(err) {
+					client.Hub.RecordReaped(client)
+				}
+				return
+			}
 		}
 	}
-
-	client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 }
 
 func (h *WebSocketHandler) readPump(client *ws.Client) {
@@ -78,12 +151,64 @@ func (h *WebSocketHandler) readPump(client *ws.Client) {
 		client.Conn.Close()
 	}()
 
+	// Every pong pushes the read deadline forward; a client that stops
+	// answering pings hits the deadline and is unregistered.
+	client.Conn.SetReadLimit(h.Pump.MaxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(h.Pump.PongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(h.Pump.PongWait))
+	})
+
 	for {
-		if _, _, err := client.Conn.ReadMessage(); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		_, data, err := client.Conn.ReadMessage()
+		if err != nil {
+			if ws.IsTimeout(err) {
+				client.Hub.RecordReaped(client)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("readPump error: %v", err)
 			}
 			break
 		}
+
+		var msg ws.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("Ignoring malformed message from creator %d overlay: %v", client.CreatorID, err)
+			h.send(client, ws.Message{Type: ws.TypeError, Error: "malformed message"})
+			continue
+		}
+
+		h.handleMessage(client, msg)
+	}
+}
+
+// handleMessage acts on a single message sent by the overlay.
+func (h *WebSocketHandler) handleMessage(client *ws.Client, msg ws.Message) {
+	switch msg.Type {
+	case ws.TypeAck, ws.TypeSkip:
+		status := alerts.StatusDisplayed
+		if msg.Type == ws.TypeSkip {
+			status = alerts.StatusSkipped
+		}
+
+		// Only a resolved alert is taken off the replay queue
+		resolved, err := h.Alerts.Resolve(client.CreatorID, msg.ID, status)
+		if err != nil {
+			log.Println("Failed to record alert status:", err)
+			h.send(client, ws.Message{Type: ws.TypeError, ID: msg.ID, Error: "could not record alert status"})
+			return
+		}
+		if !resolved {
+			// Duplicate acks are expected after a replay, so this is not an error
+			log.Printf("No pending alert %d for creator %d", msg.ID, client.CreatorID)
+		}
+
+	case ws.TypeReplayRequest:
+		h.replayPending(client)
+
+	case ws.TypePing:
+		h.send(client, ws.Message{Type: ws.TypePong, ID: msg.ID})
+
+	default:
+		h.send(client, ws.Message{Type: ws.TypeError, ID: msg.ID, Error: "unknown message type"})
 	}
 }
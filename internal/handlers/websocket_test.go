@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"my-platform/internal/alerts"
+	ws "my-platform/internal/websocket"
+)
+
+// dialOverlay connects to the overlay websocket with the widget token.
+func dialOverlay(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/"+token, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) ws.Message {
+	t.Helper()
+	var msg ws.Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return msg
+}
+
+func TestOverlayReplaysAndAcksAlerts(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice", true)
+	first := s.donate("alice", 25000)
+	s.donate("alice", 30000)
+	server := httptest.NewServer(s.router)
+	defer server.Close()
+
+	if w := s.do("GET", "/ws/not-a-token", "", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	token := s.login("alice").Token
+	var profile ProfileResponse
+	s.do("GET", "/api/me", token, nil, &profile)
+
+	conn := dialOverlay(t, server, profile.WidgetSecretToken)
+	replayed := []ws.Message{readMessage(t, conn), readMessage(t, conn)}
+	for i, amount := range []int64{25000, 30000} {
+		if msg := replayed[i]; msg.Type != ws.TypeAlert || msg.Alert == nil || msg.Alert.Amount != amount || msg.Alert.DonorName != "Bob" {
+			t.Fatalf("alert %d = %+v", i, msg)
+		}
+	}
+
+	conn.WriteJSON(ws.Message{Type: ws.TypeAck, ID: replayed[0].ID})
+	// Messages are handled in order, so the pong means the ack was recorded
+	conn.WriteJSON(ws.Message{Type: ws.TypePing, ID: 7})
+	if msg := readMessage(t, conn); msg.Type != ws.TypePong || msg.ID != 7 {
+		t.Fatalf("ping: got %+v", msg)
+	}
+
+	var donations []DonationResponse
+	s.do("GET", "/api/me/donations", token, nil, &donations)
+	for _, d := range donations {
+		want := alerts.StatusPending
+		if d.OrderID == first {
+			want = alerts.StatusDisplayed
+		}
+		if d.AlertStatus != want {
+			t.Errorf("%s: alert_status = %q, want %q", d.OrderID, d.AlertStatus, want)
+		}
+	}
+
+	conn.WriteJSON(ws.Message{Type: ws.TypeReplayRequest})
+	if msg := readMessage(t, conn); msg.ID != replayed[1].ID {
+		t.Errorf("replay: got %+v, want only alert %d", msg, replayed[1].ID)
+	}
+
+	conn.WriteJSON(ws.Message{Type: "dance"})
+	if msg := readMessage(t, conn); msg.Type != ws.TypeError {
+		t.Errorf("unknown type: got %+v", msg)
+	}
+
+	var stats ws.Stats
+	s.admin("GET", "/api/admin/metrics/websocket", nil, &stats)
+	if stats.ConnectedClients != 1 {
+		t.Errorf("connected = %d, want 1", stats.ConnectedClients)
+	}
+}
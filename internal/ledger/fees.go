@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/money"
+)
+
+// Schedule is a fee of a percentage plus a fixed amount per donation. The
+// fixed part is in minor units of Currency and only applies to donations
+// in that currency.
+type Schedule struct {
+	PercentBPS  int    `db:"percent_bps" json:"percent_bps"`
+	FixedAmount int64  `db:"fixed_amount" json:"fixed_amount"`
+	Currency    string `db:"currency" json:"currency"`
+}
+
+// Fee applies the schedule to an amount. The percentage is rounded down in
+// the creator's favour and the fee never exceeds the amount.
+func (s Schedule) Fee(amount money.Money) int64 {
+	fee := amount.Amount * int64(s.PercentBPS) / 10000
+	if s.Currency == amount.Currency {
+		fee += s.FixedAmount
+	}
+	return max(0, min(fee, amount.Amount))
+}
+
+// Fees splits a donation's gross amount, in its minor units.
+type Fees struct {
+	Platform int64
+	Gateway  int64
+	Net      int64
+}
+
+// FeesFor works out the fees of a donation at settlement. The platform fee
+// comes from the creator's own schedule for the currency, else the global
+// one in the database, else the configured default; the gateway fee is
+// configured per gateway.
+func (l *Ledger) FeesFor(tx sqlx.Queryer, creatorID int, gateway string, gross money.Money) (Fees, error) {
+	schedule := l.PlatformFee
+	query := `SELECT percent_bps, fixed_amount, currency FROM fee_schedules
+	          WHERE (creator_id = $1 OR creator_id IS NULL) AND currency = $2
+	          ORDER BY creator_id NULLS LAST
+	          LIMIT 1`
+	err := sqlx.Get(tx, &schedule, query, creatorID, gross.Currency)
+	if err != nil && err != sql.ErrNoRows {
+		return Fees{}, err
+	}
+
+	return Split(schedule, l.GatewayFees[gateway], gross), nil
+}
+
+// Split applies the platform and gateway schedules to a donation's gross
+// amount.
+func Split(platform, gateway Schedule, gross money.Money) Fees {
+	fees := Fees{
+		Platform: platform.Fee(gross),
+		Gateway:  gateway.Fee(gross),
+	}
+	// Both fees come out of the same gross, so cap the second one
+	fees.Gateway = min(fees.Gateway, gross.Amount-fees.Platform)
+	fees.Net = gross.Amount - fees.Platform - fees.Gateway
+	return fees
+}
@@ -0,0 +1,56 @@
+package ledger
+
+import (
+	"testing"
+
+	"my-platform/internal/money"
+)
+
+func TestScheduleFee(t *testing.T) {
+	idr := func(amount int64) money.Money { return money.Money{Amount: amount, Currency: "IDR"} }
+	tests := []struct {
+		name     string
+		schedule Schedule
+		amount   money.Money
+		want     int64
+	}{
+		{"percentage", Schedule{PercentBPS: 500, Currency: "IDR"}, idr(100000), 5000},
+		// 2.5% of 999 is 24.975
+		{"rounds down", Schedule{PercentBPS: 250, Currency: "IDR"}, idr(999), 24},
+		{"fixed part", Schedule{PercentBPS: 500, FixedAmount: 1000, Currency: "IDR"}, idr(100000), 6000},
+		{"fixed part in another currency", Schedule{PercentBPS: 500, FixedAmount: 1000, Currency: "IDR"}, money.Money{Amount: 10000, Currency: "USD"}, 500},
+		{"more than the amount", Schedule{PercentBPS: 500, FixedAmount: 5000, Currency: "IDR"}, idr(3000), 3000},
+		{"everything", Schedule{PercentBPS: 10000, Currency: "IDR"}, idr(3000), 3000},
+		{"none", Schedule{}, idr(3000), 0},
+		{"zero amount", Schedule{FixedAmount: 1000, Currency: "IDR"}, idr(0), 0},
+	}
+	for _, tt := range tests {
+		if got := tt.schedule.Fee(tt.amount); got != tt.want {
+			t.Errorf("%s: Fee(%s) = %d, want %d", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	gross := money.Money{Amount: 10000, Currency: "IDR"}
+	tests := []struct {
+		name              string
+		platform, gateway Schedule
+		want              Fees
+	}{
+		{"both", Schedule{PercentBPS: 500, Currency: "IDR"}, Schedule{PercentBPS: 290, FixedAmount: 100, Currency: "IDR"}, Fees{Platform: 500, Gateway: 390, Net: 9110}},
+		{"no fees", Schedule{}, Schedule{}, Fees{Net: 10000}},
+		// The gateway only gets what the platform fee left
+		{"gateway capped", Schedule{PercentBPS: 6000, Currency: "IDR"}, Schedule{PercentBPS: 5000, Currency: "IDR"}, Fees{Platform: 6000, Gateway: 4000}},
+		{"platform takes it all", Schedule{FixedAmount: 20000, Currency: "IDR"}, Schedule{FixedAmount: 100, Currency: "IDR"}, Fees{Platform: 10000}},
+	}
+	for _, tt := range tests {
+		got := Split(tt.platform, tt.gateway, gross)
+		if got != tt.want {
+			t.Errorf("%s: Split = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got.Platform+got.Gateway+got.Net != gross.Amount {
+			t.Errorf("%s: %+v does not add up to %d", tt.name, got, gross.Amount)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package ledger keeps the double-entry books of what creators are owed.
+//
+// Every money movement is a transaction of two or more entries that sum to
+// zero. Amounts are signed minor units of the transaction's currency: a
+// positive amount credits an account and a negative amount debits it. A
+// creator's account is a liability of the platform, so its sum per currency
+// is what the creator is owed in that currency.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+// Transaction kinds. Together with a reference, a kind identifies a
+// transaction, so posting the same event twice is a no-op.
+const (
+	KindDonation       = "donation"
+	KindPlatformFee    = "platform_fee"
+	KindGatewayFee     = "gateway_fee"
+	KindRefund         = "refund"
+	KindPayout         = "payout"
+	KindPayoutPaid     = "payout_paid"
+	KindPayoutReversal = "payout_reversal"
+)
+
+// Platform-wide accounts.
+const (
+	// AccountPlatformFees collects the platform's cut.
+	AccountPlatformFees = "platform:fees"
+	// AccountPayoutsInTransit holds requested payouts until they are
+	// paid or fail, so the money cannot be requested twice.
+	AccountPayoutsInTransit = "payouts:in_transit"
+	// AccountPayoutsSent is money that has left for creators' banks.
+	AccountPayoutsSent = "payouts:sent"
+)
+
+// ErrUnbalanced means a transaction's entries do not sum to zero.
+var ErrUnbalanced = errors.New("ledger: entries do not balance")
+
+// CreatorAccount is what the platform owes a creator.
+func CreatorAccount(creatorID int) string {
+	return "creator:" + strconv.Itoa(creatorID)
+}
+
+// GatewayAccount is money held for us by a payment gateway.
+func GatewayAccount(gateway string) string {
+	return "gateway:" + gateway
+}
+
+// Entry is one leg of a transaction.
+type Entry struct {
+	Account string `db:"account"`
+	Amount  int64  `db:"amount"`
+}
+
+// Balance summarises a creator's account in one currency.
+type Balance struct {
+	Currency string `db:"currency" json:"currency"`
+	// Available can be paid out. It goes negative if refunds exceed what
+	// was left after payouts.
+	Available int64 `db:"available" json:"available"`
+	// InTransit is requested or approved but not yet paid out.
+	InTransit int64 `db:"in_transit" json:"in_transit"`
+	// Lifetime totals, all positive.
+	Donated     int64 `db:"donated" json:"donated"`
+	PlatformFee int64 `db:"platform_fee" json:"platform_fee"`
+	GatewayFee  int64 `db:"gateway_fee" json:"gateway_fee"`
+	Refunded    int64 `db:"refunded" json:"refunded"`
+	PaidOut     int64 `db:"paid_out" json:"paid_out"`
+}
+
+type Ledger struct {
+	DB *sqlx.DB
+	// PlatformFee applies when no fee schedule is stored.
+	PlatformFee Schedule
+	// GatewayFees are what each gateway keeps, keyed by gateway name.
+	GatewayFees map[string]Schedule
+}
+
+func NewLedger(db *sqlx.DB, platformFee Schedule, gatewayFees map[string]Schedule) *Ledger {
+	return &Ledger{DB: db, PlatformFee: platformFee, GatewayFees: gatewayFees}
+}
+
+// Journal is where transactions are posted: the database inside the
+// caller's transaction, or memory for tests.
+type Journal interface {
+	// Post records a balanced transaction. It reports false if a
+	// transaction with the same kind and reference exists.
+	Post(kind, reference, currency string, entries ...Entry) (bool, error)
+}
+
+// Tx is a Journal that posts inside a database transaction.
+type Tx struct {
+	Tx sqlx.Ext
+}
+
+func (t Tx) Post(kind, reference, currency string, entries ...Entry) (bool, error) {
+	return Post(t.Tx, kind, reference, currency, entries...)
+}
+
+// checkBalanced rejects a transaction whose entries do not sum to zero.
+func checkBalanced(kind, reference string, entries []Entry) error {
+	var sum int64
+	for _, e := range entries {
+		sum += e.Amount
+	}
+	if sum != 0 || len(entries) < 2 {
+		return fmt.Errorf("%w: %s %s", ErrUnbalanced, kind, reference)
+	}
+	return nil
+}
+
+// Post records a balanced transaction inside the caller's transaction. It
+// reports false if a transaction with the same kind and reference exists.
+func Post(tx sqlx.Ext, kind, reference, currency string, entries ...Entry) (bool, error) {
+	if err := checkBalanced(kind, reference, entries); err != nil {
+		return false, err
+	}
+
+	var ids []int64
+	query := `INSERT INTO ledger_transactions (kind, reference, currency) VALUES ($1, $2, $3)
+	          ON CONFLICT (kind, reference) DO NOTHING
+	          RETURNING id`
+	if err := sqlx.Select(tx, &ids, query, kind, reference, currency); err != nil {
+		return false, err
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	for _, e := range entries {
+		query := `INSERT INTO ledger_entries (transaction_id, account, amount) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(query, ids[0], e.Account, e.Amount); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// RecordDonation credits the creator with a settled donation and takes the
+// platform and gateway fees out of it. The gateway fee goes back to the
+// gateway account, since the gateway never hands that part over.
+func RecordDonation(j Journal, donation models.Donation, fees Fees) error {
+	ref := donation.OrderID
+	creator := CreatorAccount(donation.CreatorID)
+	gateway := GatewayAccount(donation.PaymentGateway)
+
+	_, err := j.Post(KindDonation, ref, donation.Currency,
+		Entry{gateway, -donation.Amount},
+		Entry{creator, donation.Amount},
+	)
+	if err != nil {
+		return err
+	}
+
+	if fees.Platform > 0 {
+		_, err = j.Post(KindPlatformFee, ref, donation.Currency,
+			Entry{creator, -fees.Platform},
+			Entry{AccountPlatformFees, fees.Platform},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if fees.Gateway > 0 {
+		_, err = j.Post(KindGatewayFee, ref, donation.Currency,
+			Entry{creator, -fees.Gateway},
+			Entry{gateway, fees.Gateway},
+		)
+	}
+	return err
+}
+
+// RefundReference is the reference of a refund's transaction. It is keyed
+// by the donation's new refunded total, so each refund is booked once.
+func RefundReference(orderID string, refundedTotal int64) string {
+	return orderID + ":" + strconv.FormatInt(refundedTotal, 10)
+}
+
+// RecordRefund debits the creator for money returned to a donor. The
+// fees are not returned. The reference must be unique per refund.
+func RecordRefund(j Journal, donation models.Donation, reference string, amount int64) error {
+	_, err := j.Post(KindRefund, reference, donation.Currency,
+		Entry{CreatorAccount(donation.CreatorID), -amount},
+		Entry{GatewayAccount(donation.PaymentGateway), amount},
+	)
+	return err
+}
+
+// Balances returns the creator's balance and lifetime totals in every
+// currency they have been paid or have payouts in.
+func Balances(db sqlx.Queryer, creatorID int) ([]Balance, error) {
+	balances := []Balance{}
+	query := `WITH ledger AS (
+	            SELECT t.currency,
+	              SUM(e.amount) AS available,
+	              COALESCE(SUM(e.amount) FILTER (WHERE t.kind = 'donation'), 0) AS donated,
+	              COALESCE(-SUM(e.amount) FILTER (WHERE t.kind = 'platform_fee'), 0) AS platform_fee,
+	              COALESCE(-SUM(e.amount) FILTER (WHERE t.kind = 'gateway_fee'), 0) AS gateway_fee,
+	              COALESCE(-SUM(e.amount) FILTER (WHERE t.kind = 'refund'), 0) AS refunded
+	            FROM ledger_entries e
+	            INNER JOIN ledger_transactions t ON e.transaction_id = t.id
+	            WHERE e.account = $1
+	            GROUP BY t.currency
+	          ), payouts AS (
+	            SELECT currency,
+	              COALESCE(SUM(amount) FILTER (WHERE status IN ('requested', 'approved')), 0) AS in_transit,
+	              COALESCE(SUM(amount) FILTER (WHERE status = 'paid'), 0) AS paid_out
+	            FROM payouts WHERE creator_id = $2
+	            GROUP BY currency
+	          )
+	          SELECT COALESCE(l.currency, p.currency) AS currency,
+	            COALESCE(l.available, 0) AS available, COALESCE(l.donated, 0) AS donated,
+	            COALESCE(l.platform_fee, 0) AS platform_fee, COALESCE(l.gateway_fee, 0) AS gateway_fee,
+	            COALESCE(l.refunded, 0) AS refunded,
+	            COALESCE(p.in_transit, 0) AS in_transit, COALESCE(p.paid_out, 0) AS paid_out
+	          FROM ledger l
+	          FULL OUTER JOIN payouts p ON p.currency = l.currency
+	          ORDER BY 1`
+	if err := sqlx.Select(db, &balances, query, CreatorAccount(creatorID), creatorID); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// AvailableTx is the creator's available balance in a currency as seen
+// inside tx. Lock the creator row first so concurrent payout requests are
+// serialised.
+func AvailableTx(tx sqlx.Queryer, creatorID int, currency string) (int64, error) {
+	var available int64
+	query := `SELECT COALESCE(SUM(e.amount), 0) FROM ledger_entries e
+	          INNER JOIN ledger_transactions t ON e.transaction_id = t.id
+	          WHERE e.account = $1 AND t.currency = $2`
+	err := sqlx.Get(tx, &available, query, CreatorAccount(creatorID), currency)
+	return available, err
+}
+
+// payoutRef is the reference of a payout's ledger transactions.
+func payoutRef(payoutID int) string {
+	return "payout-" + strconv.Itoa(payoutID)
+}
+
+// RecordPayoutRequest moves the amount out of the creator's available
+// balance into transit.
+func RecordPayoutRequest(j Journal, payout models.Payout) error {
+	_, err := j.Post(KindPayout, payoutRef(payout.ID), payout.Currency,
+		Entry{CreatorAccount(payout.CreatorID), -payout.Amount},
+		Entry{AccountPayoutsInTransit, payout.Amount},
+	)
+	return err
+}
+
+// RecordPayoutPaid records that the money reached the creator's bank.
+func RecordPayoutPaid(j Journal, payout models.Payout) error {
+	_, err := j.Post(KindPayoutPaid, payoutRef(payout.ID), payout.Currency,
+		Entry{AccountPayoutsInTransit, -payout.Amount},
+		Entry{AccountPayoutsSent, payout.Amount},
+	)
+	return err
+}
+
+// RecordPayoutFailed gives a failed payout back to the creator.
+func RecordPayoutFailed(j Journal, payout models.Payout) error {
+	_, err := j.Post(KindPayoutReversal, payoutRef(payout.ID), payout.Currency,
+		Entry{AccountPayoutsInTransit, -payout.Amount},
+		Entry{CreatorAccount(payout.CreatorID), payout.Amount},
+	)
+	return err
+}
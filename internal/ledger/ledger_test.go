@@ -0,0 +1,227 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"my-platform/internal/migrate"
+	"my-platform/internal/models"
+)
+
+func TestPostRejectsUnbalanced(t *testing.T) {
+	tests := map[string][]Entry{
+		"unbalanced":   {{"creator:1", 100}, {"gateway:fake", -90}},
+		"single entry": {{"creator:1", 0}},
+		"no entries":   nil,
+	}
+	for name, entries := range tests {
+		// The check comes before any query, so no database is needed
+		if _, err := Post(nil, KindDonation, "ORDER-1", "IDR", entries...); !errors.Is(err, ErrUnbalanced) {
+			t.Errorf("%s: Post error = %v, want ErrUnbalanced", name, err)
+		}
+		var m Memory
+		if _, err := m.Post(KindDonation, "ORDER-1", "IDR", entries...); !errors.Is(err, ErrUnbalanced) {
+			t.Errorf("%s: Memory.Post error = %v, want ErrUnbalanced", name, err)
+		}
+		if len(m.Transactions) != 0 {
+			t.Errorf("%s: posted %d transactions", name, len(m.Transactions))
+		}
+	}
+}
+
+func TestPostIgnoresRepeatedReference(t *testing.T) {
+	var m Memory
+	entries := []Entry{{"gateway:fake", -100}, {"creator:1", 100}}
+	if posted, err := m.Post(KindDonation, "ORDER-1", "IDR", entries...); !posted || err != nil {
+		t.Fatalf("first post = %v, %v", posted, err)
+	}
+	if posted, err := m.Post(KindDonation, "ORDER-1", "IDR", entries...); posted || err != nil {
+		t.Errorf("repeat post = %v, %v, want false, nil", posted, err)
+	}
+	if posted, _ := m.Post(KindPlatformFee, "ORDER-1", "IDR", entries...); !posted {
+		t.Error("another kind with the same reference was not posted")
+	}
+}
+
+// sums returns the balance of each account in IDR.
+func sums(m *Memory, accounts ...string) []int64 {
+	var got []int64
+	for _, a := range accounts {
+		got = append(got, m.Sum(a, "IDR"))
+	}
+	return got
+}
+
+func TestPayoutEntries(t *testing.T) {
+	var m Memory
+	creator, gateway := CreatorAccount(1), GatewayAccount("fake")
+	donation := models.Donation{OrderID: "ORDER-1", CreatorID: 1, Amount: 100000, Currency: "IDR", PaymentGateway: "fake"}
+	if err := RecordDonation(&m, donation, Fees{Platform: 5000, Gateway: 2000, Net: 93000}); err != nil {
+		t.Fatal(err)
+	}
+	accounts := []string{creator, gateway, AccountPlatformFees, AccountPayoutsInTransit, AccountPayoutsSent}
+
+	steps := []struct {
+		name   string
+		record func(Journal, models.Payout) error
+		payout models.Payout
+		want   []int64
+	}{
+		{"request", RecordPayoutRequest, models.Payout{ID: 1, CreatorID: 1, Amount: 60000, Currency: "IDR"}, []int64{33000, -98000, 5000, 60000, 0}},
+		{"paid", RecordPayoutPaid, models.Payout{ID: 1, CreatorID: 1, Amount: 60000, Currency: "IDR"}, []int64{33000, -98000, 5000, 0, 60000}},
+		{"request", RecordPayoutRequest, models.Payout{ID: 2, CreatorID: 1, Amount: 30000, Currency: "IDR"}, []int64{3000, -98000, 5000, 30000, 60000}},
+		{"failed", RecordPayoutFailed, models.Payout{ID: 2, CreatorID: 1, Amount: 30000, Currency: "IDR"}, []int64{33000, -98000, 5000, 0, 60000}},
+		// A webhook or admin retry books nothing twice
+		{"failed again", RecordPayoutFailed, models.Payout{ID: 2, CreatorID: 1, Amount: 30000, Currency: "IDR"}, []int64{33000, -98000, 5000, 0, 60000}},
+	}
+	for _, step := range steps {
+		if err := step.record(&m, step.payout); err != nil {
+			t.Fatalf("%s payout %d: %v", step.name, step.payout.ID, err)
+		}
+		if got := sums(&m, accounts...); fmt.Sprint(got) != fmt.Sprint(step.want) {
+			t.Errorf("after %s payout %d: %v = %v, want %v", step.name, step.payout.ID, accounts, got, step.want)
+		}
+	}
+}
+
+func TestMemoryBalances(t *testing.T) {
+	var m Memory
+	donation := models.Donation{OrderID: "ORDER-1", CreatorID: 1, Amount: 100000, Currency: "IDR", PaymentGateway: "fake"}
+	RecordDonation(&m, donation, Fees{Platform: 5000, Gateway: 2000, Net: 93000})
+	RecordRefund(&m, donation, RefundReference(donation.OrderID, 10000), 10000)
+	payouts := []models.Payout{
+		{ID: 1, CreatorID: 1, Amount: 20000, Currency: "IDR", Status: models.PayoutPaid},
+		{ID: 2, CreatorID: 1, Amount: 30000, Currency: "IDR", Status: models.PayoutApproved},
+		{ID: 3, CreatorID: 1, Amount: 40000, Currency: "IDR", Status: models.PayoutFailed},
+		{ID: 4, CreatorID: 2, Amount: 50000, Currency: "IDR", Status: models.PayoutRequested},
+		{ID: 5, CreatorID: 1, Amount: 1000, Currency: "USD", Status: models.PayoutFailed},
+	}
+	for _, p := range payouts[:4] {
+		RecordPayoutRequest(&m, p)
+	}
+	RecordPayoutPaid(&m, payouts[0])
+	RecordPayoutFailed(&m, payouts[2])
+
+	got := m.Balances(1, payouts)
+	want := []Balance{
+		{Currency: "IDR", Available: 33000, InTransit: 30000, Donated: 100000, PlatformFee: 5000, GatewayFee: 2000, Refunded: 10000, PaidOut: 20000},
+		{Currency: "USD"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Balances = %+v, want %+v", got, want)
+	}
+	if got := m.Balances(3, nil); len(got) != 0 {
+		t.Errorf("Balances of a creator with nothing = %+v", got)
+	}
+}
+
+// openTestDB connects to TEST_DATABASE_DSN and migrates a throwaway
+// schema, which is dropped when the test ends.
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set, skipping PostgreSQL test")
+	}
+
+	admin, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := fmt.Sprintf("ledger_test_%d", time.Now().UnixNano())
+	admin.MustExec("CREATE SCHEMA " + schema)
+	t.Cleanup(func() {
+		admin.MustExec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_DSN must be a URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db, err := sqlx.Connect("pgx", u.String())
+	if err != nil {
+		t.Fatalf("connect to test schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// TestBalancesMatchMemory books the same history in PostgreSQL and in
+// Memory and checks Balances and AvailableTx agree with it.
+func TestBalancesMatchMemory(t *testing.T) {
+	db := openTestDB(t)
+
+	var userID, creatorID, accountID int
+	db.QueryRowx(`INSERT INTO users (email, password_hash) VALUES ('c@example.com', 'x') RETURNING id`).Scan(&userID)
+	db.QueryRowx(`INSERT INTO creators (user_id, username, display_name, widget_secret_token)
+	              VALUES ($1, 'creator', 'Creator', 'token') RETURNING id`, userID).Scan(&creatorID)
+	db.QueryRowx(`INSERT INTO bank_accounts (creator_id, bank_code, account_number, account_holder_name)
+	              VALUES ($1, 'BCA', '1234567890', 'Creator') RETURNING id`, creatorID).Scan(&accountID)
+
+	var m Memory
+	tx := db.MustBegin()
+	donation := models.Donation{OrderID: "ORDER-1", CreatorID: creatorID, Amount: 100000, Currency: "IDR", PaymentGateway: "fake"}
+	fees := Fees{Platform: 5000, Gateway: 2000, Net: 93000}
+	var payouts []models.Payout
+	for _, j := range []Journal{Tx{Tx: tx}, &m} {
+		if err := RecordDonation(j, donation, fees); err != nil {
+			t.Fatal(err)
+		}
+		if err := RecordRefund(j, donation, RefundReference(donation.OrderID, 10000), 10000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, status := range []string{models.PayoutPaid, models.PayoutRequested, models.PayoutFailed} {
+		payout := models.Payout{CreatorID: creatorID, BankAccountID: accountID, Amount: int64(i+1) * 10000, Currency: "IDR", Status: status}
+		tx.QueryRowx(`INSERT INTO payouts (creator_id, bank_account_id, amount, currency, status)
+		              VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			creatorID, accountID, payout.Amount, payout.Currency, status).Scan(&payout.ID)
+		payouts = append(payouts, payout)
+		for _, j := range []Journal{Tx{Tx: tx}, &m} {
+			RecordPayoutRequest(j, payout)
+			switch status {
+			case models.PayoutPaid:
+				RecordPayoutPaid(j, payout)
+			case models.PayoutFailed:
+				RecordPayoutFailed(j, payout)
+			}
+		}
+	}
+
+	available, err := AvailableTx(tx, creatorID, "IDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := m.Sum(CreatorAccount(creatorID), "IDR"); available != want || available != 53000 {
+		t.Errorf("AvailableTx = %d, memory says %d, want 53000", available, want)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Balances(db, creatorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := m.Balances(creatorID, payouts); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Balances = %+v, memory says %+v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package ledger
+
+import (
+	"slices"
+	"sort"
+
+	"my-platform/internal/models"
+)
+
+// Transaction is a posted transaction as Memory keeps it.
+type Transaction struct {
+	Kind      string
+	Reference string
+	Currency  string
+	Entries   []Entry
+}
+
+// Memory is a Journal kept in memory, for tests. It is not safe for
+// concurrent use; the memory repositories hold their lock around it.
+type Memory struct {
+	Transactions []Transaction
+}
+
+func (m *Memory) Post(kind, reference, currency string, entries ...Entry) (bool, error) {
+	if err := checkBalanced(kind, reference, entries); err != nil {
+		return false, err
+	}
+	for _, t := range m.Transactions {
+		if t.Kind == kind && t.Reference == reference {
+			return false, nil
+		}
+	}
+	m.Transactions = append(m.Transactions, Transaction{
+		Kind:      kind,
+		Reference: reference,
+		Currency:  currency,
+		Entries:   slices.Clone(entries),
+	})
+	return true, nil
+}
+
+// Sum adds up the account's entries in the currency, counting only
+// transactions of the given kinds if any are given.
+func (m *Memory) Sum(account, currency string, kinds ...string) int64 {
+	var sum int64
+	for _, t := range m.Transactions {
+		if t.Currency != currency || (len(kinds) > 0 && !slices.Contains(kinds, t.Kind)) {
+			continue
+		}
+		for _, e := range t.Entries {
+			if e.Account == account {
+				sum += e.Amount
+			}
+		}
+	}
+	return sum
+}
+
+// Balances works out what Balances returns from the database, given the
+// creator's payouts.
+func (m *Memory) Balances(creatorID int, payouts []models.Payout) []Balance {
+	account := CreatorAccount(creatorID)
+
+	var currencies []string
+	for _, t := range m.Transactions {
+		for _, e := range t.Entries {
+			if e.Account == account && !slices.Contains(currencies, t.Currency) {
+				currencies = append(currencies, t.Currency)
+			}
+		}
+	}
+	for _, p := range payouts {
+		if p.CreatorID == creatorID && !slices.Contains(currencies, p.Currency) {
+			currencies = append(currencies, p.Currency)
+		}
+	}
+	sort.Strings(currencies)
+
+	balances := []Balance{}
+	for _, currency := range currencies {
+		b := Balance{
+			Currency:    currency,
+			Available:   m.Sum(account, currency),
+			Donated:     m.Sum(account, currency, KindDonation),
+			PlatformFee: -m.Sum(account, currency, KindPlatformFee),
+			GatewayFee:  -m.Sum(account, currency, KindGatewayFee),
+			Refunded:    -m.Sum(account, currency, KindRefund),
+		}
+		for _, p := range payouts {
+			if p.CreatorID != creatorID || p.Currency != currency {
+				continue
+			}
+			switch p.Status {
+			case models.PayoutRequested, models.PayoutApproved:
+				b.InTransit += p.Amount
+			case models.PayoutPaid:
+				b.PaidOut += p.Amount
+			}
+		}
+		balances = append(balances, b)
+	}
+	return balances
+}
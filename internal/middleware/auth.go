@@ -7,9 +7,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+
+	"my-platform/internal/repository"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware validates the access token and checks that the session it
+// was issued for has not been revoked or expired.
+func AuthMiddleware(jwtSecret string, sessions repository.SessionRepo) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Authorization Header
 		authHeader := c.GetHeader("Authorization")
@@ -54,7 +58,26 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 			userID := int(userIDFloat)
 
+			sessionID, ok := claims["sid"].(string)
+			if !ok {
+				log.Println("Missing 'sid' claim in token")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return
+			}
+
+			active, err := sessions.Active(sessionID, userID)
+			if err != nil {
+				log.Println("Failed to check session:", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error."})
+				return
+			}
+			if !active {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				return
+			}
+
 			c.Set("userID", userID)
+			c.Set("sessionID", sessionID)
 			c.Next()
 		} else {
 			log.Println("Token claims invalid")
@@ -0,0 +1,366 @@
+// Package migrate versions the database schema. Migrations are SQL files
+// embedded in the binary, named NNNN_name.up.sql and NNNN_name.down.sql,
+// and run in version order; schema_migrations records which have run.
+//
+// A database whose tables were made by hand before migrations existed is
+// adopted with Baseline: compare its schema with the migrations, record
+// the ones it already matches as applied, and let Up run the rest.
+package migrate
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Dir is where Create writes new migrations, relative to the repository
+// root.
+const Dir = "internal/migrate/sql"
+
+// lockID is the advisory lock held while migrating, so two instances
+// starting at once do not run the same migration twice.
+const lockID = 4_715_902_318
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+var filePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ErrUnknownVersion means the database has a migration applied that this
+// binary does not know, usually because it is older than the schema.
+var ErrUnknownVersion = errors.New("applied migration is not in this binary")
+
+// ErrAlreadyMigrated means Baseline was asked to adopt a database that
+// already records applied migrations.
+var ErrAlreadyMigrated = errors.New("database already has applied migrations")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it has been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load returns the embedded migrations in version order.
+func Load() ([]Migration, error) {
+	return load(files, "sql")
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := filePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// applied returns when each applied version ran. A database that has never
+// been migrated has none.
+func applied(q sqlx.Queryer) (map[int64]time.Time, error) {
+	var exists bool
+	if err := sqlx.Get(q, &exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`); err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]time.Time)
+	if !exists {
+		return versions, nil
+	}
+
+	var rows []struct {
+		Version   int64     `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := sqlx.Select(q, &rows, `SELECT version, applied_at FROM schema_migrations`); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		versions[row.Version] = row.AppliedAt
+	}
+	return versions, nil
+}
+
+// Statuses lists every migration in this binary and whether it has been
+// applied. Versions applied by a newer binary are left out, so an older
+// binary can still serve after a rollback.
+func Statuses(db *sqlx.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	versions, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+		if at, ok := versions[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func Pending(db *sqlx.DB) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, oldest first, and returns those it
+// applied. Each runs in its own transaction, so a failure keeps the ones
+// before it.
+func Up(db *sqlx.DB) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for {
+		migration, err := step(db, migrations, true)
+		if err != nil || migration == nil {
+			return done, err
+		}
+		done = append(done, *migration)
+	}
+}
+
+// Down rolls back the latest n applied migrations and returns those it
+// rolled back.
+func Down(db *sqlx.DB, n int) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := 0; i < n; i++ {
+		migration, err := step(db, migrations, false)
+		if err != nil || migration == nil {
+			return done, err
+		}
+		done = append(done, *migration)
+	}
+	return done, nil
+}
+
+// step applies the oldest pending migration, or rolls back the latest
+// applied one, in a transaction holding the migration lock. It returns nil
+// if there was nothing to do.
+func step(db *sqlx.DB, migrations []Migration, up bool) (*Migration, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(createTable); err != nil {
+		return nil, err
+	}
+	versions, err := applied(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var next *Migration
+	if up {
+		for i := range migrations {
+			if _, ok := versions[migrations[i].Version]; !ok {
+				next = &migrations[i]
+				break
+			}
+		}
+	} else if latest, ok := latestVersion(versions); ok {
+		for i := range migrations {
+			if migrations[i].Version == latest {
+				next = &migrations[i]
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%w: version %d", ErrUnknownVersion, latest)
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	if up {
+		if _, err := tx.Exec(next.Up); err != nil {
+			return nil, fmt.Errorf("migration %d_%s up: %w", next.Version, next.Name, err)
+		}
+		query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+		if _, err := tx.Exec(query, next.Version, next.Name); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := tx.Exec(next.Down); err != nil {
+			return nil, fmt.Errorf("migration %d_%s down: %w", next.Version, next.Name, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, next.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	return next, tx.Commit()
+}
+
+// Baseline records every migration up to and including version as applied
+// without running it, for a database whose schema already matches them,
+// and returns those it recorded. It refuses a database that has any
+// migration recorded, so it cannot hide a half-migrated schema.
+func Baseline(db *sqlx.DB, version int64) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, m := range migrations {
+		known = known || m.Version == version
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: version %d", ErrUnknownVersion, version)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(createTable); err != nil {
+		return nil, err
+	}
+	versions, err := applied(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		return nil, ErrAlreadyMigrated
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+		if _, err := tx.Exec(query, m.Version, m.Name); err != nil {
+			return nil, err
+		}
+		done = append(done, m)
+	}
+	return done, tx.Commit()
+}
+
+func latestVersion(versions map[int64]time.Time) (int64, bool) {
+	var latest int64
+	found := false
+	for version := range versions {
+		if !found || version > latest {
+			latest, found = version, true
+		}
+	}
+	return latest, found
+}
+
+// Create writes an empty up and down migration to dir, numbered after the
+// latest one there, and returns their paths.
+func Create(dir, name string) ([]string, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("migration name %q must be lowercase letters, digits and underscores", name)
+	}
+	existing, err := load(os.DirFS(dir), ".")
+	if err != nil {
+		return nil, err
+	}
+	var version int64 = 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		file := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+		body := fmt.Sprintf("-- %s: %s\n", name, direction)
+		if err := os.WriteFile(file, []byte(body), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, file)
+	}
+	return paths, nil
+}
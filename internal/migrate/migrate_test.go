@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// TestLoad checks the embedded migrations are numbered 1, 2, 3... with no
+// gaps, so two branches adding the same version fail here first.
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d_%s: want version %d", m.Version, m.Name, i+1)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := Create(dir, "add_things")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("created %d files, want 2", len(paths))
+	}
+
+	paths, err = Create(dir, "add_more_things")
+	if err != nil {
+		t.Fatalf("second Create: %v", err)
+	}
+	if want := dir + "/0002_add_more_things.up.sql"; paths[0] != want {
+		t.Errorf("path = %s, want %s", paths[0], want)
+	}
+
+	if _, err := Create(dir, "Bad Name"); err == nil {
+		t.Error("Create accepted a name with spaces")
+	}
+}
+
+// TestUpDownUp migrates a throwaway schema all the way down and up again,
+// so every down file is exercised.
+func TestUpDownUp(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set, skipping PostgreSQL test")
+	}
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	// Pin one connection so search_path sticks
+	db.SetMaxOpenConns(1)
+	db.MustExec(`CREATE SCHEMA migrate_test`)
+	defer db.MustExec(`DROP SCHEMA migrate_test CASCADE`)
+	db.MustExec(`SET search_path TO migrate_test`)
+
+	migrations, _ := Load()
+	applied, err := Up(db)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d, want %d", len(applied), len(migrations))
+	}
+	if pending, _ := Pending(db); len(pending) != 0 {
+		t.Errorf("pending after Up = %d, want 0", len(pending))
+	}
+
+	reverted, err := Down(db, len(migrations)+1)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Errorf("rolled back %d, want %d", len(reverted), len(migrations))
+	}
+
+	if _, err := Up(db); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+}
+
+// TestBaseline adopts a schema made by hand: the baselined migrations are
+// recorded without running, and Up applies only the rest.
+func TestBaseline(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set, skipping PostgreSQL test")
+	}
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	db.MustExec(`CREATE SCHEMA migrate_baseline_test`)
+	defer db.MustExec(`DROP SCHEMA migrate_baseline_test CASCADE`)
+	db.MustExec(`SET search_path TO migrate_baseline_test`)
+
+	// The tables of 0001 and 0002, as made by hand in the dashboard
+	migrations, _ := Load()
+	db.MustExec(migrations[0].Up)
+	db.MustExec(migrations[1].Up)
+
+	if _, err := Baseline(db, 999_999); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Baseline(unknown) err = %v, want ErrUnknownVersion", err)
+	}
+
+	recorded, err := Baseline(db, 2)
+	if err != nil {
+		t.Fatalf("Baseline: %v", err)
+	}
+	if len(recorded) != 2 {
+		t.Errorf("recorded %d, want 2", len(recorded))
+	}
+	if _, err := Baseline(db, 2); !errors.Is(err, ErrAlreadyMigrated) {
+		t.Errorf("second Baseline err = %v, want ErrAlreadyMigrated", err)
+	}
+
+	applied, err := Up(db)
+	if err != nil {
+		t.Fatalf("Up after Baseline: %v", err)
+	}
+	if len(applied) != len(migrations)-2 || applied[0].Version != 3 {
+		t.Errorf("Up applied %d starting at %d, want %d starting at 3", len(applied), applied[0].Version, len(migrations)-2)
+	}
+}
@@ -0,0 +1,18 @@
+CREATE TABLE users (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	email_verified_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE creators (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	username TEXT NOT NULL UNIQUE,
+	display_name TEXT NOT NULL,
+	widget_secret_token TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
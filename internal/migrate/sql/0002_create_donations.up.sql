@@ -0,0 +1,30 @@
+CREATE TABLE donations (
+	id SERIAL PRIMARY KEY,
+	creator_id INT NOT NULL REFERENCES creators (id),
+	amount BIGINT NOT NULL,
+	donor_name TEXT NOT NULL DEFAULT '',
+	donor_message TEXT NOT NULL DEFAULT '',
+	payment_gateway_tx_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	media_type TEXT NOT NULL DEFAULT '',
+	media_url TEXT NOT NULL DEFAULT '',
+	media_start_seconds INT NOT NULL DEFAULT 0,
+	media_end_seconds INT NOT NULL DEFAULT 0,
+	order_id TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX donations_creator_id_created_at_idx ON donations (creator_id, created_at DESC);
+
+CREATE TABLE alerts (
+	id SERIAL PRIMARY KEY,
+	creator_id INT NOT NULL REFERENCES creators (id),
+	donation_id INT NOT NULL REFERENCES donations (id),
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	delivered_at TIMESTAMPTZ,
+	displayed_at TIMESTAMPTZ
+);
+
+CREATE INDEX alerts_creator_id_status_idx ON alerts (creator_id, status);
+CREATE INDEX alerts_donation_id_idx ON alerts (donation_id);
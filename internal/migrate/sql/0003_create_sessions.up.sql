@@ -0,0 +1,13 @@
+CREATE TABLE sessions (
+	id UUID PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	refresh_token_hash TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
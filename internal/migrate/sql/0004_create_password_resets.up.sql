@@ -0,0 +1,10 @@
+CREATE TABLE password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX password_resets_user_id_created_at_idx ON password_resets (user_id, created_at);
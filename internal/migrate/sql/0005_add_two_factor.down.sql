@@ -0,0 +1,8 @@
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+	DROP COLUMN totp_secret,
+	DROP COLUMN totp_enabled_at,
+	DROP COLUMN totp_last_step,
+	DROP COLUMN totp_failed_attempts,
+	DROP COLUMN totp_locked_until;
@@ -0,0 +1,16 @@
+ALTER TABLE users
+	ADD COLUMN totp_secret TEXT,
+	ADD COLUMN totp_enabled_at TIMESTAMPTZ,
+	ADD COLUMN totp_last_step BIGINT,
+	ADD COLUMN totp_failed_attempts INT NOT NULL DEFAULT 0,
+	ADD COLUMN totp_locked_until TIMESTAMPTZ;
+
+CREATE TABLE recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1 @@
+DROP TABLE donation_status_history;
@@ -0,0 +1,12 @@
+CREATE TABLE donation_status_history (
+	id SERIAL PRIMARY KEY,
+	donation_id INT NOT NULL REFERENCES donations (id),
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	source TEXT NOT NULL,
+	gateway_status TEXT NOT NULL DEFAULT '',
+	fraud_status TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX donation_status_history_donation_id_idx ON donation_status_history (donation_id);
@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+	id BIGSERIAL PRIMARY KEY,
+	topic TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	dispatched_at TIMESTAMPTZ
+);
+
+-- The dispatcher only ever looks at undelivered messages
+CREATE INDEX outbox_undispatched_idx ON outbox (next_attempt_at) WHERE dispatched_at IS NULL;
@@ -0,0 +1,8 @@
+DROP INDEX donations_status_created_at_idx;
+DROP INDEX donations_creator_id_idempotency_key_idx;
+
+ALTER TABLE donations
+	DROP COLUMN payment_gateway,
+	DROP COLUMN idempotency_key,
+	DROP COLUMN request_fingerprint,
+	DROP COLUMN checkout_url;
@@ -0,0 +1,11 @@
+ALTER TABLE donations
+	ADD COLUMN payment_gateway TEXT NOT NULL DEFAULT 'midtrans',
+	ADD COLUMN idempotency_key TEXT,
+	ADD COLUMN request_fingerprint TEXT,
+	ADD COLUMN checkout_url TEXT;
+
+CREATE UNIQUE INDEX donations_creator_id_idempotency_key_idx
+	ON donations (creator_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- The reconciler polls donations still waiting on the gateway
+CREATE INDEX donations_status_created_at_idx ON donations (status, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE refunds;
+
+ALTER TABLE donations DROP COLUMN refunded_amount;
@@ -0,0 +1,13 @@
+ALTER TABLE donations ADD COLUMN refunded_amount BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE refunds (
+	id SERIAL PRIMARY KEY,
+	donation_id INT NOT NULL REFERENCES donations (id),
+	gateway_refund_id TEXT NOT NULL DEFAULT '',
+	amount BIGINT NOT NULL,
+	reason TEXT NOT NULL,
+	requested_by INT NOT NULL REFERENCES users (id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX refunds_donation_id_idx ON refunds (donation_id);
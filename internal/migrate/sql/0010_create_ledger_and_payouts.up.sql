@@ -0,0 +1,44 @@
+CREATE TABLE ledger_transactions (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	reference TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (kind, reference)
+);
+
+CREATE TABLE ledger_entries (
+	id BIGSERIAL PRIMARY KEY,
+	transaction_id BIGINT NOT NULL REFERENCES ledger_transactions (id),
+	account TEXT NOT NULL,
+	amount BIGINT NOT NULL
+);
+
+CREATE INDEX ledger_entries_account_idx ON ledger_entries (account);
+CREATE INDEX ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+
+CREATE TABLE bank_accounts (
+	id SERIAL PRIMARY KEY,
+	creator_id INT NOT NULL REFERENCES creators (id),
+	bank_code TEXT NOT NULL,
+	account_number TEXT NOT NULL,
+	account_holder_name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX bank_accounts_creator_id_idx ON bank_accounts (creator_id);
+
+CREATE TABLE payouts (
+	id SERIAL PRIMARY KEY,
+	creator_id INT NOT NULL REFERENCES creators (id),
+	bank_account_id INT NOT NULL REFERENCES bank_accounts (id),
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	status TEXT NOT NULL,
+	failure_reason TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	approved_at TIMESTAMPTZ,
+	paid_at TIMESTAMPTZ
+);
+
+CREATE INDEX payouts_creator_id_created_at_idx ON payouts (creator_id, created_at DESC);
+CREATE INDEX payouts_status_idx ON payouts (status);
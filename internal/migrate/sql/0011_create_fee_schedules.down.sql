@@ -0,0 +1,6 @@
+DROP TABLE fee_schedules;
+
+ALTER TABLE donations
+	DROP COLUMN platform_fee,
+	DROP COLUMN gateway_fee,
+	DROP COLUMN net_amount;
@@ -0,0 +1,14 @@
+ALTER TABLE donations
+	ADD COLUMN platform_fee BIGINT,
+	ADD COLUMN gateway_fee BIGINT,
+	ADD COLUMN net_amount BIGINT;
+
+-- A null creator_id is the global schedule
+CREATE TABLE fee_schedules (
+	id SERIAL PRIMARY KEY,
+	creator_id INT UNIQUE NULLS NOT DISTINCT REFERENCES creators (id),
+	percent_bps INT NOT NULL CHECK (percent_bps BETWEEN 0 AND 10000),
+	fixed_amount BIGINT NOT NULL DEFAULT 0 CHECK (fixed_amount >= 0),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+DROP TABLE creator_currencies;
+
+ALTER TABLE fee_schedules
+	DROP CONSTRAINT fee_schedules_creator_id_currency_key,
+	DROP COLUMN currency,
+	ADD CONSTRAINT fee_schedules_creator_id_key UNIQUE NULLS NOT DISTINCT (creator_id);
+
+ALTER TABLE payouts DROP COLUMN currency;
+ALTER TABLE ledger_transactions DROP COLUMN currency;
+ALTER TABLE donations DROP COLUMN currency;
@@ -0,0 +1,18 @@
+ALTER TABLE donations ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR';
+ALTER TABLE ledger_transactions ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR';
+ALTER TABLE payouts ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR';
+
+-- Schedules are per currency, since a fixed fee is in its minor units
+ALTER TABLE fee_schedules
+	ADD COLUMN currency TEXT NOT NULL DEFAULT 'IDR',
+	DROP CONSTRAINT fee_schedules_creator_id_key,
+	ADD CONSTRAINT fee_schedules_creator_id_currency_key UNIQUE NULLS NOT DISTINCT (creator_id, currency);
+
+CREATE TABLE creator_currencies (
+	creator_id INT NOT NULL REFERENCES creators (id),
+	currency TEXT NOT NULL,
+	min_amount BIGINT NOT NULL CHECK (min_amount > 0),
+	max_amount BIGINT NOT NULL DEFAULT 0 CHECK (max_amount >= 0),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (creator_id, currency)
+);
@@ -0,0 +1,10 @@
+ALTER TABLE creators
+	DROP COLUMN goal_started_at,
+	DROP COLUMN goal_currency,
+	DROP COLUMN goal_amount,
+	DROP COLUMN goal_title,
+	DROP COLUMN accent_color,
+	DROP COLUMN theme,
+	DROP COLUMN social_links,
+	DROP COLUMN avatar_url,
+	DROP COLUMN bio;
@@ -0,0 +1,12 @@
+-- What donors see on a creator's public donation page
+ALTER TABLE creators
+	ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+	ADD COLUMN avatar_url TEXT,
+	ADD COLUMN social_links JSONB NOT NULL DEFAULT '[]',
+	ADD COLUMN theme TEXT NOT NULL DEFAULT 'light',
+	ADD COLUMN accent_color TEXT,
+	-- Donations count towards the goal from goal_started_at on
+	ADD COLUMN goal_title TEXT,
+	ADD COLUMN goal_amount BIGINT CHECK (goal_amount > 0),
+	ADD COLUMN goal_currency TEXT,
+	ADD COLUMN goal_started_at TIMESTAMPTZ;
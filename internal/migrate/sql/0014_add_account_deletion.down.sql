@@ -0,0 +1,5 @@
+DROP INDEX users_pending_purge_idx;
+
+ALTER TABLE users
+	DROP COLUMN purged_at,
+	DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+-- Deleted accounts can be restored until the grace period ends, after
+-- which their personal data is scrubbed and purged_at is set
+ALTER TABLE users
+	ADD COLUMN deleted_at TIMESTAMPTZ,
+	ADD COLUMN purged_at TIMESTAMPTZ;
+
+CREATE INDEX users_pending_purge_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
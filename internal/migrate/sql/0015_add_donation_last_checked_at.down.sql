@@ -0,0 +1,3 @@
+DROP INDEX donations_reconcile_idx;
+
+ALTER TABLE donations DROP COLUMN last_checked_at;
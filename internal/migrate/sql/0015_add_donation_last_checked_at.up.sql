@@ -0,0 +1,6 @@
+-- The reconciler checks the donations it has looked at least recently
+-- first, so a backlog of abandoned checkouts cannot starve a paid one
+ALTER TABLE donations ADD COLUMN last_checked_at TIMESTAMPTZ;
+
+CREATE INDEX donations_reconcile_idx ON donations (payment_gateway, last_checked_at NULLS FIRST)
+	WHERE status IN ('pending', 'challenge');
@@ -0,0 +1,6 @@
+DELETE FROM refunds WHERE requested_by IS NULL;
+
+ALTER TABLE refunds
+	DROP CONSTRAINT refunds_requester_check,
+	DROP COLUMN requested_by_operator,
+	ALTER COLUMN requested_by SET NOT NULL;
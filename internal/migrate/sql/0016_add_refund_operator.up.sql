@@ -0,0 +1,6 @@
+-- Operators refund through the admin API, which has no user behind it, so
+-- a refund names either the creator's user or the operator
+ALTER TABLE refunds
+	ALTER COLUMN requested_by DROP NOT NULL,
+	ADD COLUMN requested_by_operator TEXT,
+	ADD CONSTRAINT refunds_requester_check CHECK (requested_by IS NOT NULL OR requested_by_operator IS NOT NULL);
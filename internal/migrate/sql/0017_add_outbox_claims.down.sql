@@ -0,0 +1,3 @@
+DROP TABLE outbox_deliveries;
+
+ALTER TABLE outbox DROP COLUMN claimed_until;
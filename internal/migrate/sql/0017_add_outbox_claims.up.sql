@@ -0,0 +1,10 @@
+-- A dispatcher delivering a message holds it until then
+ALTER TABLE outbox ADD COLUMN claimed_until TIMESTAMPTZ;
+
+-- Sinks that accepted a message, so retries only go to the ones that failed
+CREATE TABLE outbox_deliveries (
+	message_id BIGINT NOT NULL REFERENCES outbox (id) ON DELETE CASCADE,
+	sink TEXT NOT NULL,
+	delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (message_id, sink)
+);
@@ -0,0 +1,3 @@
+DELETE FROM refunds WHERE status <> 'succeeded';
+
+ALTER TABLE refunds DROP COLUMN status;
@@ -0,0 +1,8 @@
+-- A refund is reserved as pending before the gateway is called, so the
+-- donation is not locked while the gateway answers. Refunds recorded
+-- before this went through.
+ALTER TABLE refunds
+	ADD COLUMN status TEXT NOT NULL DEFAULT 'succeeded'
+		CHECK (status IN ('pending', 'succeeded', 'failed'));
+
+CREATE INDEX refunds_pending_idx ON refunds (donation_id) WHERE status = 'pending';
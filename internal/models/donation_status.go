@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Donation statuses. Every donation starts pending; settled is the only
+// status in which it counts towards the creator and triggers an alert.
+const (
+	DonationPending           = "pending"
+	DonationChallenge         = "challenge" // paid, but held for fraud review
+	DonationSettled           = "settled"
+	DonationDenied            = "denied"
+	DonationCancelled         = "cancelled"
+	DonationExpired           = "expired"
+	DonationFailed            = "failed"
+	DonationPartiallyRefunded = "partially_refunded"
+	DonationRefunded          = "refunded"
+)
+
+// donationTransitions lists where each status may move next. Statuses
+// without an entry are final.
+var donationTransitions = map[string][]string{
+	DonationPending: {
+		DonationChallenge, DonationSettled, DonationDenied,
+		DonationCancelled, DonationExpired, DonationFailed,
+	},
+	DonationChallenge: {DonationSettled, DonationDenied, DonationCancelled},
+	DonationSettled:   {DonationPartiallyRefunded, DonationRefunded},
+	// A second partial refund keeps the status but is still a transition
+	DonationPartiallyRefunded: {DonationPartiallyRefunded, DonationRefunded},
+}
+
+// CanTransitionDonation reports whether a donation may move from one
+// status to another.
+func CanTransitionDonation(from, to string) bool {
+	for _, next := range donationTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DonationStatusChange is one row of a donation's status history.
+type DonationStatusChange struct {
+	ID            int       `db:"id"`
+	DonationID    int       `db:"donation_id"`
+	FromStatus    string    `db:"from_status"`
+	ToStatus      string    `db:"to_status"`
+	Source        string    `db:"source"`
+	GatewayStatus string    `db:"gateway_status"`
+	FraudStatus   string    `db:"fraud_status"`
+	CreatedAt     time.Time `db:"created_at"`
+}
@@ -1,15 +1,34 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // We use 'db' tags for sqlx to automatically map
 // User represents a user's authentication details.
 type User struct {
-	ID           int       `db:"id"`
-	Email        string    `db:"email"`
-	PasswordHash string    `db:"password_hash"`
-	CreatedAt    time.Time `db:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at"`
+	ID              int        `db:"id"`
+	Email           string     `db:"email"`
+	PasswordHash    string     `db:"password_hash"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at"`
+
+	// Two-factor authentication. The secret is set during enrollment and
+	// only enforced once TOTPEnabledAt is set by the confirmation step.
+	TOTPSecret         *string    `db:"totp_secret"`
+	TOTPEnabledAt      *time.Time `db:"totp_enabled_at"`
+	TOTPLastStep       *int64     `db:"totp_last_step"`
+	TOTPFailedAttempts int        `db:"totp_failed_attempts"`
+	TOTPLockedUntil    *time.Time `db:"totp_locked_until"`
+
+	// DeletedAt is when the user asked to delete the account. It can be
+	// restored until the grace period ends and it is purged.
+	DeletedAt *time.Time `db:"deleted_at"`
+	PurgedAt  *time.Time `db:"purged_at"`
 }
 
 // Creator represents a creator's public profile and settings.
@@ -19,15 +38,59 @@ type Creator struct {
 	Username          string    `db:"username" json:"username"`
 	DisplayName       string    `db:"display_name" json:"display_name"`
 	WidgetSecretToken string    `db:"widget_secret_token" json:"widget_secret_token"`
-	CreatedAt         time.Time `db:"created_at" json:"created_at`
-	UpdatedAt         time.Time `db:"updated_at" json:"updated_at`
+	CreatedAt         time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
+
+	// Donation page
+	Bio           string      `db:"bio" json:"bio"`
+	AvatarURL     *string     `db:"avatar_url" json:"avatar_url"`
+	SocialLinks   SocialLinks `db:"social_links" json:"social_links"`
+	Theme         string      `db:"theme" json:"theme"`
+	AccentColor   *string     `db:"accent_color" json:"accent_color"`
+	GoalTitle     *string     `db:"goal_title" json:"goal_title"`
+	GoalAmount    *int64      `db:"goal_amount" json:"goal_amount"`
+	GoalCurrency  *string     `db:"goal_currency" json:"goal_currency"`
+	GoalStartedAt *time.Time  `db:"goal_started_at" json:"goal_started_at"`
+}
+
+// SocialLink is a link on a creator's donation page.
+type SocialLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// SocialLinks is stored as a JSON array.
+type SocialLinks []SocialLink
+
+func (l SocialLinks) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+func (l *SocialLinks) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case nil:
+		*l = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into SocialLinks", src)
+	}
 }
 
 // Donation represents a single completed donation.
 type Donation struct {
 	ID                 int       `db:"id"`
 	CreatorID          int       `db:"creator_id"`
-	AmountCents        int       `db:"amount_cents"`
+	Amount             int64     `db:"amount"` // in minor units of Currency
+	Currency           string    `db:"currency"`
+	RefundedAmount     int64     `db:"refunded_amount"`
 	DonorName          string    `db:"donor_name"`
 	DonorMessage       string    `db:"donor_message"`
 	PaymentGatewayTxID string    `db:"payment_gateway_tx_id"`
@@ -38,4 +101,99 @@ type Donation struct {
 	MediaStartSeconds  int       `db:"media_start_seconds"`
 	MediaEndSeconds    int       `db:"media_end_seconds"`
 	OrderID            string    `db:"order_id"`
+	PaymentGateway     string    `db:"payment_gateway"`
+	IdempotencyKey     *string   `db:"idempotency_key"`
+	RequestFingerprint *string   `db:"request_fingerprint"`
+	CheckoutURL        *string   `db:"checkout_url"`
+	// Fees are fixed at settlement; net is what the creator receives
+	PlatformFee *int64 `db:"platform_fee"`
+	GatewayFee  *int64 `db:"gateway_fee"`
+	NetAmount   *int64 `db:"net_amount"`
+}
+
+// CreatorDonation is a donation as listed to its creator, with the status
+// of its alert, if one was queued.
+type CreatorDonation struct {
+	Donation
+	AlertStatus string `db:"alert_status"`
+}
+
+// Alert is a queued on-stream notification for a settled donation.
+// It stays pending until an overlay reports it as displayed or skipped.
+// DeliveredAt is when it was first written to an overlay, DisplayedAt when
+// an overlay acked it.
+type Alert struct {
+	ID          int        `db:"id"`
+	CreatorID   int        `db:"creator_id"`
+	DonationID  int        `db:"donation_id"`
+	Status      string     `db:"status"`
+	CreatedAt   time.Time  `db:"created_at"`
+	DeliveredAt *time.Time `db:"delivered_at"`
+	DisplayedAt *time.Time `db:"displayed_at"`
+}
+
+// Session is one logged-in device. The refresh token is only stored as a
+// hash and is replaced on every refresh.
+type Session struct {
+	ID               string     `db:"id"`
+	UserID           int        `db:"user_id"`
+	RefreshTokenHash string     `db:"refresh_token_hash"`
+	UserAgent        string     `db:"user_agent"`
+	IPAddress        string     `db:"ip_address"`
+	CreatedAt        time.Time  `db:"created_at"`
+	LastUsedAt       time.Time  `db:"last_used_at"`
+	ExpiresAt        time.Time  `db:"expires_at"`
+	RevokedAt        *time.Time `db:"revoked_at"`
+}
+
+// PasswordReset is a single-use reset token. Only its hash is stored.
+type PasswordReset struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// RecoveryCode is a single-use fallback for a lost authenticator app.
+type RecoveryCode struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	CodeHash  string     `db:"code_hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// Refund statuses. A refund is pending from when it is reserved until the
+// gateway answers.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// Refund is money returned to a donor through the gateway. A donation can
+// have several partial refunds. It was requested either by the creator's
+// user or by an operator through the admin API.
+type Refund struct {
+	ID                  int       `db:"id"`
+	DonationID          int       `db:"donation_id"`
+	GatewayRefundID     string    `db:"gateway_refund_id"`
+	Amount              int64     `db:"amount"`
+	Reason              string    `db:"reason"`
+	Status              string    `db:"status"`
+	RequestedBy         *int      `db:"requested_by"`
+	RequestedByOperator *string   `db:"requested_by_operator"`
+	CreatedAt           time.Time `db:"created_at"`
+}
+
+// CreatorCurrency is a currency a creator accepts donations in, with the
+// allowed range in its minor units. A zero MaxAmount means no upper limit.
+type CreatorCurrency struct {
+	CreatorID int       `db:"creator_id"`
+	Currency  string    `db:"currency"`
+	MinAmount int64     `db:"min_amount"`
+	MaxAmount int64     `db:"max_amount"`
+	CreatedAt time.Time `db:"created_at"`
 }
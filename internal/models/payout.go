@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Payout statuses. A payout is requested by the creator, approved and then
+// marked paid by an admin, or fails at either step.
+const (
+	PayoutRequested = "requested"
+	PayoutApproved  = "approved"
+	PayoutPaid      = "paid"
+	PayoutFailed    = "failed"
+)
+
+var payoutTransitions = map[string][]string{
+	PayoutRequested: {PayoutApproved, PayoutFailed},
+	PayoutApproved:  {PayoutPaid, PayoutFailed},
+}
+
+// CanTransitionPayout reports whether a payout may move from one status to
+// another.
+func CanTransitionPayout(from, to string) bool {
+	for _, next := range payoutTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// BankAccount is where a creator's payouts are sent.
+type BankAccount struct {
+	ID                int       `db:"id"`
+	CreatorID         int       `db:"creator_id"`
+	BankCode          string    `db:"bank_code"`
+	AccountNumber     string    `db:"account_number"`
+	AccountHolderName string    `db:"account_holder_name"`
+	CreatedAt         time.Time `db:"created_at"`
+}
+
+type Payout struct {
+	ID            int        `db:"id"`
+	CreatorID     int        `db:"creator_id"`
+	BankAccountID int        `db:"bank_account_id"`
+	Amount        int64      `db:"amount"`
+	Currency      string     `db:"currency"`
+	Status        string     `db:"status"`
+	FailureReason *string    `db:"failure_reason"`
+	CreatedAt     time.Time  `db:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at"`
+	ApprovedAt    *time.Time `db:"approved_at"`
+	PaidAt        *time.Time `db:"paid_at"`
+}
+
+// FeeSchedule is the platform fee for donations in a currency, to one
+// creator or, with no CreatorID, to every creator without their own.
+type FeeSchedule struct {
+	CreatorID   *int      `db:"creator_id"`
+	Currency    string    `db:"currency"`
+	PercentBPS  int       `db:"percent_bps"`
+	FixedAmount int64     `db:"fixed_amount"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
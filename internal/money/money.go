@@ -0,0 +1,112 @@
+// Package money represents amounts as an integer number of a currency's
+// minor units, e.g. cents for USD and whole rupiah for IDR, together with
+// the ISO 4217 currency code.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownCurrency means the currency code is not one we can take.
+var ErrUnknownCurrency = errors.New("money: unknown currency")
+
+// Currency is an ISO 4217 currency and how many minor units make up one
+// major unit, as an exponent of ten.
+type Currency struct {
+	Code       string
+	MinorUnits int
+}
+
+// currencies we can take payments in. IDR officially has two minor units,
+// but sen are not in use and Midtrans and Xendit both reject fractions of a
+// rupiah, so it is treated as having none.
+var currencies = map[string]Currency{
+	"IDR": {"IDR", 0},
+	"MYR": {"MYR", 2},
+	"PHP": {"PHP", 2},
+	"SGD": {"SGD", 2},
+	"THB": {"THB", 2},
+	"USD": {"USD", 2},
+	"VND": {"VND", 0},
+}
+
+// Lookup returns the currency for an ISO 4217 code, which is matched case
+// insensitively.
+func Lookup(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// Money is an amount in minor units of a currency.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New checks the currency code and returns the amount with the code in its
+// canonical upper case form.
+func New(amount int64, code string) (Money, error) {
+	c, err := Lookup(code)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: c.Code}, nil
+}
+
+// Decimal formats the amount in major units, e.g. "12.50" for 1250 USD
+// and "25000" for 25000 IDR.
+func (m Money) Decimal() string {
+	c, err := Lookup(m.Currency)
+	if err != nil || c.MinorUnits == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	unit := int64(1)
+	for i := 0; i < c.MinorUnits; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, c.MinorUnits, amount%unit)
+}
+
+// ParseDecimal reads an amount in major units, as gateways report them,
+// into minor units. More decimals than the currency has are an error.
+func ParseDecimal(s, code string) (Money, error) {
+	c, err := Lookup(code)
+	if err != nil {
+		return Money{}, err
+	}
+
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	// Padding the fraction would otherwise read "" or "." as zero
+	if strings.TrimLeft(whole, "+-") == "" && frac == "" {
+		return Money{}, fmt.Errorf("money: invalid amount %q", s)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > c.MinorUnits {
+		return Money{}, fmt.Errorf("money: %q has more than %d decimals for %s", s, c.MinorUnits, c.Code)
+	}
+	frac += strings.Repeat("0", c.MinorUnits-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("money: invalid amount %q: %w", s, err)
+	}
+	return Money{Amount: amount, Currency: c.Code}, nil
+}
+
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
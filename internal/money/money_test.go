@@ -0,0 +1,108 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		amount   int64
+		ok       bool
+	}{
+		{"12.50", "USD", 1250, true},
+		{"12.5", "USD", 1250, true},
+		{"12", "USD", 1200, true},
+		{".5", "USD", 50, true},
+		{"0.05", "USD", 5, true},
+		{"-0.5", "USD", -50, true},
+		{"-12.34", "USD", -1234, true},
+		{"1.2300", "USD", 123, true},
+		{" 7.00 ", "usd", 700, true},
+		{"1.234", "USD", 0, false},
+		{"1e3", "USD", 0, false},
+		{"1.5e3", "USD", 0, false},
+		{"1.5.0", "USD", 0, false},
+		{"1,000.00", "USD", 0, false},
+		{"", "USD", 0, false},
+		{".", "USD", 0, false},
+		{"-", "USD", 0, false},
+		{"abc", "USD", 0, false},
+
+		{"25000", "IDR", 25000, true},
+		{"25000.00", "IDR", 25000, true},
+		{"25000.", "IDR", 25000, true},
+		{"-25000", "IDR", -25000, true},
+		{"25000.50", "IDR", 0, false},
+		{"1e3", "IDR", 0, false},
+		{"", "IDR", 0, false},
+		{"99999999999999999999", "IDR", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.in, tt.currency)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("ParseDecimal(%q, %s) = %v, want an error", tt.in, tt.currency, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDecimal(%q, %s): %v", tt.in, tt.currency, err)
+			continue
+		}
+		if got.Amount != tt.amount {
+			t.Errorf("ParseDecimal(%q, %s) = %d, want %d", tt.in, tt.currency, got.Amount, tt.amount)
+		}
+	}
+}
+
+func TestParseDecimalUnknownCurrency(t *testing.T) {
+	if _, err := ParseDecimal("1.00", "XXX"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("err = %v, want ErrUnknownCurrency", err)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, "USD", "12.50"},
+		{5, "USD", "0.05"},
+		{0, "USD", "0.00"},
+		{-5, "USD", "-0.05"},
+		{-1250, "USD", "-12.50"},
+		{25000, "IDR", "25000"},
+		{-25000, "IDR", "-25000"},
+		{0, "VND", "0"},
+	}
+	for _, tt := range tests {
+		if got := (Money{Amount: tt.amount, Currency: tt.currency}).Decimal(); got != tt.want {
+			t.Errorf("Decimal(%d %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+// TestDecimalRoundTrip checks that ParseDecimal reads back what Decimal
+// writes, since gateway amounts are compared after both.
+func TestDecimalRoundTrip(t *testing.T) {
+	for _, m := range []Money{{1250, "USD"}, {-5, "SGD"}, {0, "MYR"}, {25000, "IDR"}, {-1, "VND"}} {
+		got, err := ParseDecimal(m.Decimal(), m.Currency)
+		if err != nil || got != m {
+			t.Errorf("ParseDecimal(%q) = %v, %v, want %v", m.Decimal(), got, err, m)
+		}
+	}
+}
+
+func TestLookupIsCaseInsensitive(t *testing.T) {
+	c, err := Lookup("sgd")
+	if err != nil || c.Code != "SGD" || c.MinorUnits != 2 {
+		t.Errorf("Lookup(sgd) = %v, %v", c, err)
+	}
+	if _, err := Lookup("XXX"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("Lookup(XXX) err = %v, want ErrUnknownCurrency", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package outbox implements the transactional outbox pattern: messages are
+// written in the same database transaction as the change they announce and
+// delivered afterwards by a Dispatcher, so a crash between commit and
+// delivery cannot lose them and slow consumers cannot stall the writer.
+//
+// Delivery is at least once. A message is retried until every sink for its
+// topic has accepted it, and a sink that accepted it is not called again.
+// A crash between a delivery and recording it still repeats the message,
+// so consumers must tolerate duplicates.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Topics
+const (
+	TopicDonationAlert = "donation.alert"
+)
+
+// Message is one row of the outbox table.
+type Message struct {
+	ID            int64           `db:"id"`
+	Topic         string          `db:"topic"`
+	Payload       json.RawMessage `db:"payload"`
+	Attempts      int             `db:"attempts"`
+	CreatedAt     time.Time       `db:"created_at"`
+	NextAttemptAt time.Time       `db:"next_attempt_at"`
+	ClaimedUntil  *time.Time      `db:"claimed_until"`
+	DispatchedAt  *time.Time      `db:"dispatched_at"`
+	LastError     *string         `db:"last_error"`
+}
+
+// Sink delivers messages of the topics it is registered for.
+type Sink interface {
+	Name() string
+	Deliver(msg Message) error
+}
+
+// Write adds a message to the outbox inside the caller's transaction.
+func Write(tx sqlx.Execer, topic string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox (topic, payload) VALUES ($1, $2)`
+	_, err = tx.Exec(query, topic, data)
+	return err
+}
+
+// Dispatcher polls the outbox and hands undelivered messages to sinks.
+type Dispatcher struct {
+	DB           *sqlx.DB
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts failures in a row park a message; it stays in the table
+	// with its last error for someone to look at.
+	MaxAttempts int
+	// ClaimTimeout is how long a claimed batch is reserved for this
+	// dispatcher. Messages it has not got to by then are left to the next
+	// claim, and so are those of a dispatcher that died.
+	ClaimTimeout time.Duration
+
+	sinks map[string][]Sink
+}
+
+func NewDispatcher(db *sqlx.DB, pollInterval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		PollInterval: pollInterval,
+		BatchSize:    50,
+		MaxAttempts:  10,
+		ClaimTimeout: 5 * time.Minute,
+		sinks:        make(map[string][]Sink),
+	}
+}
+
+// Register adds a sink for a topic. Call it before Run. Deliveries are
+// recorded by sink name, so the sinks of a topic need distinct names.
+func (d *Dispatcher) Register(topic string, sink Sink) {
+	d.sinks[topic] = append(d.sinks[topic], sink)
+}
+
+// Run dispatches forever, draining the outbox every PollInterval.
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Keep going while full batches come back, so a backlog drains
+		// without waiting a tick per batch.
+		for {
+			n, err := d.dispatchBatch()
+			if err != nil {
+				log.Println("Outbox dispatch failed:", err)
+				break
+			}
+			if n < d.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// dispatchBatch claims a batch, delivers it and returns how many messages
+// it took. The claim commits on its own before any sink is called, so no
+// row lock or connection is held while sinks are slow, and several API
+// instances can run a dispatcher without delivering the same row at the
+// same time.
+func (d *Dispatcher) dispatchBatch() (int, error) {
+	deadline := time.Now().Add(d.ClaimTimeout)
+
+	var batch []Message
+	query := `UPDATE outbox SET claimed_until = NOW() + make_interval(secs => $3)
+	          WHERE id IN (
+	            SELECT id FROM outbox
+	            WHERE dispatched_at IS NULL AND attempts < $1 AND next_attempt_at <= NOW()
+	              AND (claimed_until IS NULL OR claimed_until < NOW())
+	            ORDER BY id
+	            LIMIT $2
+	            FOR UPDATE SKIP LOCKED
+	          )
+	          RETURNING id, topic, payload, attempts, created_at, next_attempt_at`
+	if err := d.DB.Select(&batch, query, d.MaxAttempts, d.BatchSize, d.ClaimTimeout.Seconds()); err != nil {
+		return 0, err
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+
+	for _, msg := range batch {
+		// Past the deadline the rest may have been claimed by someone else
+		if time.Now().After(deadline) {
+			break
+		}
+
+		if err := d.deliver(msg); err != nil {
+			attempts := msg.Attempts + 1
+			log.Printf("Outbox message %d (%s) failed attempt %d: %v", msg.ID, msg.Topic, attempts, err)
+
+			query := `UPDATE outbox
+			          SET attempts = $1, last_error = $2, next_attempt_at = NOW() + make_interval(secs => $3),
+			              claimed_until = NULL
+			          WHERE id = $4`
+			if _, err := d.DB.Exec(query, attempts, err.Error(), backoff(attempts).Seconds(), msg.ID); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		query := `UPDATE outbox SET dispatched_at = NOW(), claimed_until = NULL WHERE id = $1`
+		if _, err := d.DB.Exec(query, msg.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
+
+// deliver hands the message to each sink of its topic that has not
+// accepted it yet, recording every success, so a failing sink does not
+// make the others see the message again when it is retried.
+func (d *Dispatcher) deliver(msg Message) error {
+	sinks := d.sinks[msg.Topic]
+	if len(sinks) == 0 {
+		log.Printf("Outbox message %d has no sink for topic %s, dropping", msg.ID, msg.Topic)
+		return nil
+	}
+
+	var delivered []string
+	if err := d.DB.Select(&delivered, `SELECT sink FROM outbox_deliveries WHERE message_id = $1`, msg.ID); err != nil {
+		return err
+	}
+
+	for _, sink := range sinks {
+		if slices.Contains(delivered, sink.Name()) {
+			continue
+		}
+		if err := sink.Deliver(msg); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+
+		query := `INSERT INTO outbox_deliveries (message_id, sink) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := d.DB.Exec(query, msg.ID, sink.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff doubles the wait after every failed attempt, up to five minutes.
+func backoff(attempts int) time.Duration {
+	wait := time.Second << min(attempts, 9)
+	return min(wait, 5*time.Minute)
+}
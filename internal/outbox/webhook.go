@@ -0,0 +1,44 @@
+package outbox
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WebhookSink POSTs message payloads as JSON to a fixed URL, for example a
+// chat bot or an analytics collector. Any non-2xx reply is retried.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Deliver(msg Message) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Topic", msg.Topic)
+	// Receivers can dedupe retries on the message ID
+	req.Header.Set("X-Outbox-Message-Id", strconv.FormatInt(msg.ID, 10))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
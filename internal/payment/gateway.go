@@ -0,0 +1,189 @@
+// Package payment hides the payment provider behind a Gateway interface so
+// handlers never talk to a provider SDK directly.
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"my-platform/internal/money"
+)
+
+var (
+	// ErrNotFound means the gateway has no transaction for the order ID.
+	ErrNotFound = errors.New("payment: transaction not found")
+	// ErrInvalidNotification means a webhook body could not be parsed.
+	ErrInvalidNotification = errors.New("payment: invalid notification")
+	// ErrInvalidSignature means a webhook did not come from the gateway.
+	ErrInvalidSignature = errors.New("payment: invalid notification signature")
+	// ErrUnsupportedCurrency means the gateway cannot charge the currency.
+	ErrUnsupportedCurrency = errors.New("payment: unsupported currency")
+)
+
+// RequestTimeout bounds every call to a provider's API, so a request that
+// waits on one still answers well within a client's own timeout.
+const RequestTimeout = 15 * time.Second
+
+// Status is a transaction status normalized across gateways.
+type Status string
+
+const (
+	StatusPending           Status = "pending"
+	StatusPaid              Status = "paid"
+	StatusChallenge         Status = "challenge" // paid but held for fraud review
+	StatusDenied            Status = "denied"
+	StatusCancelled         Status = "cancelled"
+	StatusExpired           Status = "expired"
+	StatusFailed            Status = "failed"
+	StatusRefunded          Status = "refunded"
+	StatusPartiallyRefunded Status = "partially_refunded"
+)
+
+// CheckoutRequest describes the payment a donor is about to make.
+type CheckoutRequest struct {
+	OrderID      string
+	Amount       money.Money
+	CustomerName string
+}
+
+// Checkout is where the donor is sent to pay.
+type Checkout struct {
+	RedirectURL string
+	Token       string
+}
+
+// Transaction is the gateway's view of a payment.
+type Transaction struct {
+	OrderID       string
+	TransactionID string
+	Status        Status
+	// RawStatus and FraudStatus are the gateway's own values, kept for logs
+	// and the donation's status history.
+	RawStatus   string
+	FraudStatus string
+	// Gross is what the donor was charged, as the gateway reports it. It is
+	// zero for transactions made up locally, such as an expiry, which never
+	// settle.
+	Gross money.Money
+	// RefundedAmount is the total refunded so far, in minor units of the
+	// donation's currency.
+	RefundedAmount int64
+}
+
+// Refund is the result of a refund request.
+type Refund struct {
+	RefundID string
+	Amount   money.Money
+}
+
+// Gateway is implemented by every payment provider we support.
+type Gateway interface {
+	// Name identifies the gateway and is stored on each donation, so the
+	// status of an order is always checked with the provider that took it.
+	Name() string
+	// SupportsCurrency reports whether CreateCheckout can charge in the
+	// ISO 4217 currency.
+	SupportsCurrency(code string) bool
+	CreateCheckout(req CheckoutRequest) (*Checkout, error)
+	// VerifyNotification authenticates a webhook call and returns the
+	// transaction it reports on.
+	VerifyNotification(header http.Header, body []byte) (*Transaction, error)
+	FetchStatus(orderID string) (*Transaction, error)
+	// Refund returns amount to the donor. The key identifies the refund to
+	// the gateway: calling Refund again with the same key, e.g. after a
+	// timeout, must not refund a second time.
+	Refund(orderID string, amount money.Money, reason, key string) (*Refund, error)
+}
+
+// Config selects the checkout gateway and configures every provider
+// that has credentials.
+type Config struct {
+	Provider string // "midtrans" or "xendit", takes new donations
+
+	MidtransServerKey  string
+	MidtransProduction bool
+	// MidtransBaseURL overrides the API host, for a fake Midtrans in tests
+	MidtransBaseURL string
+
+	XenditSecretKey     string
+	XenditCallbackToken string
+}
+
+// Registry holds every configured gateway by name. New donations go to the
+// checkout gateway; the others stay registered so orders they took can
+// still be notified, reconciled and refunded after a switch.
+type Registry struct {
+	checkout Gateway
+	gateways map[string]Gateway
+}
+
+// NewRegistry registers the gateways and takes new donations through
+// checkout, which is registered too.
+func NewRegistry(checkout Gateway, others ...Gateway) *Registry {
+	r := &Registry{checkout: checkout, gateways: make(map[string]Gateway)}
+	for _, g := range append([]Gateway{checkout}, others...) {
+		r.gateways[g.Name()] = g
+	}
+	return r
+}
+
+// Checkout is the gateway new donations are taken with.
+func (r *Registry) Checkout() Gateway {
+	return r.checkout
+}
+
+// Get returns the gateway stored on a donation as its payment_gateway.
+func (r *Registry) Get(name string) (Gateway, bool) {
+	g, ok := r.gateways[name]
+	return g, ok
+}
+
+// All returns every registered gateway, sorted by name.
+func (r *Registry) All() []Gateway {
+	all := make([]Gateway, 0, len(r.gateways))
+	for _, g := range r.gateways {
+		all = append(all, g)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name() < all[j].Name() })
+	return all
+}
+
+// New builds a gateway for every provider with credentials in cfg and
+// takes checkouts with cfg.Provider. Keep the credentials of a provider
+// you switch away from until its last orders are settled or expired.
+func New(cfg Config) (*Registry, error) {
+	var gateways []Gateway
+	if cfg.MidtransServerKey != "" || cfg.Provider == "" || cfg.Provider == "midtrans" {
+		// Notifications are signed with the server key, so without one
+		// anyone could forge them
+		if cfg.MidtransServerKey == "" {
+			return nil, errors.New("midtrans gateway requires a server key")
+		}
+		m := NewMidtrans(cfg.MidtransServerKey, cfg.MidtransProduction)
+		if cfg.MidtransBaseURL != "" {
+			m.SetBaseURL(cfg.MidtransBaseURL)
+		}
+		gateways = append(gateways, m)
+	}
+	if cfg.XenditSecretKey != "" || cfg.XenditCallbackToken != "" || cfg.Provider == "xendit" {
+		if cfg.XenditSecretKey == "" || cfg.XenditCallbackToken == "" {
+			return nil, errors.New("xendit gateway requires a secret key and a callback token")
+		}
+		gateways = append(gateways, NewXendit(cfg.XenditSecretKey, cfg.XenditCallbackToken))
+	}
+
+	provider := cfg.Provider
+	if provider == "" {
+		provider = "midtrans"
+	}
+	for i, g := range gateways {
+		if g.Name() == provider {
+			others := append(gateways[:i:i], gateways[i+1:]...)
+			return NewRegistry(g, others...), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown payment gateway %q", cfg.Provider)
+}
@@ -0,0 +1,49 @@
+package payment
+
+import "testing"
+
+func TestNewRegistersEveryConfiguredGateway(t *testing.T) {
+	gateways, err := New(Config{
+		Provider:            "xendit",
+		MidtransServerKey:   testServerKey,
+		XenditSecretKey:     "xnd_development_key",
+		XenditCallbackToken: "callback-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := gateways.Checkout().Name(); name != "xendit" {
+		t.Errorf("checkout gateway = %q, want xendit", name)
+	}
+	// Orders Midtrans took before the switch are still checked with it
+	if g, ok := gateways.Get("midtrans"); !ok || g.Name() != "midtrans" {
+		t.Errorf("Get(midtrans) = %v, %v", g, ok)
+	}
+	if _, ok := gateways.Get("fake"); ok {
+		t.Error("Get(fake) found a gateway that was never configured")
+	}
+
+	var names []string
+	for _, g := range gateways.All() {
+		names = append(names, g.Name())
+	}
+	if len(names) != 2 || names[0] != "midtrans" || names[1] != "xendit" {
+		t.Errorf("All() = %v, want [midtrans xendit]", names)
+	}
+}
+
+func TestNewRejectsUnconfiguredProvider(t *testing.T) {
+	if _, err := New(Config{Provider: "xendit"}); err == nil {
+		t.Error("xendit without credentials was accepted")
+	}
+	if _, err := New(Config{Provider: "midtrans"}); err == nil {
+		t.Error("midtrans without a server key was accepted")
+	}
+	if _, err := New(Config{}); err == nil {
+		t.Error("default provider without a server key was accepted")
+	}
+	if _, err := New(Config{Provider: "stripe"}); err == nil {
+		t.Error("unknown provider was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midtrans/midtrans-go"
+
+	"my-platform/internal/money"
+	"my-platform/internal/payment/midtranstest"
+)
+
+// testServerKey is the key the fixtures in testdata/midtrans were signed with.
+const testServerKey = "SB-Mid-server-TESTFIXTUREKEY"
+
+// fakeCoreAPI answers Core API status checks with a fixed body and counts
+// how often it was called.
+type fakeCoreAPI struct {
+	status []byte
+	calls  int
+}
+
+func (f *fakeCoreAPI) Call(method, url string, apiKey *string, options *midtrans.ConfigOptions, body io.Reader, result interface{}) *midtrans.Error {
+	f.calls++
+	if err := json.Unmarshal(f.status, result); err != nil {
+		return &midtrans.Error{Message: err.Error(), RawError: err}
+	}
+	return nil
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "midtrans", name+".json"))
+	if err != nil {
+		t.Fatalf("read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+// newTestMidtrans returns a gateway whose Core API replies with the fixture.
+func newTestMidtrans(t *testing.T, fixture string) (*Midtrans, *fakeCoreAPI) {
+	t.Helper()
+	m := NewMidtrans(testServerKey, false)
+	fake := &fakeCoreAPI{status: loadFixture(t, fixture)}
+	m.CoreClient.HttpClient = fake
+	return m, fake
+}
+
+func TestMidtransVerifyNotificationAcceptsSignedFixtures(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		wantStatus  Status
+		wantOrderID string
+	}{
+		{"settlement", StatusPaid, "DONATION-1760270651-C7"},
+		{"capture_challenge", StatusChallenge, "DONATION-1760270702-C7"},
+		{"expire", StatusExpired, "DONATION-1760270800-C9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			m, fake := newTestMidtrans(t, tt.fixture)
+
+			txn, err := m.VerifyNotification(http.Header{}, loadFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("VerifyNotification: %v", err)
+			}
+			if txn.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", txn.Status, tt.wantStatus)
+			}
+			if txn.OrderID != tt.wantOrderID {
+				t.Errorf("order ID = %q, want %q", txn.OrderID, tt.wantOrderID)
+			}
+			if fake.calls != 1 {
+				t.Errorf("Core API calls = %d, want 1", fake.calls)
+			}
+		})
+	}
+}
+
+func TestMidtransVerifyNotificationRejectsBadSignatures(t *testing.T) {
+	for _, fixture := range []string{"tampered_amount", "wrong_key", "missing_signature"} {
+		t.Run(fixture, func(t *testing.T) {
+			m, fake := newTestMidtrans(t, "settlement")
+
+			_, err := m.VerifyNotification(http.Header{}, loadFixture(t, fixture))
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("err = %v, want ErrInvalidSignature", err)
+			}
+			if fake.calls != 0 {
+				t.Errorf("Core API calls = %d, want none before the signature is checked", fake.calls)
+			}
+		})
+	}
+}
+
+func TestMidtransVerifyNotificationRejectsWrongServerKey(t *testing.T) {
+	m, fake := newTestMidtrans(t, "settlement")
+	m.ServerKey = "SB-Mid-server-ANOTHERMERCHANT"
+
+	_, err := m.VerifyNotification(http.Header{}, loadFixture(t, "settlement"))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("err = %v, want ErrInvalidSignature", err)
+	}
+	if fake.calls != 0 {
+		t.Errorf("Core API calls = %d, want 0", fake.calls)
+	}
+}
+
+func TestMidtransVerifyNotificationRejectsMalformedBody(t *testing.T) {
+	m, _ := newTestMidtrans(t, "settlement")
+
+	for _, body := range []string{"", "not json", `{"status_code":"200"}`} {
+		if _, err := m.VerifyNotification(http.Header{}, []byte(body)); !errors.Is(err, ErrInvalidNotification) {
+			t.Errorf("body %q: err = %v, want ErrInvalidNotification", body, err)
+		}
+	}
+}
+
+func TestMidtransAgainstFakeServer(t *testing.T) {
+	fake := midtranstest.NewServer(testServerKey)
+	defer fake.Close()
+
+	m := NewMidtrans(testServerKey, false)
+	m.SetBaseURL(fake.URL)
+
+	checkout, err := m.CreateCheckout(CheckoutRequest{
+		OrderID:      "DONATION-1-C1",
+		Amount:       money.Money{Amount: 25000, Currency: "IDR"},
+		CustomerName: "Bob",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckout: %v", err)
+	}
+	if checkout.Token == "" || !strings.HasPrefix(checkout.RedirectURL, fake.URL) {
+		t.Errorf("checkout = %+v", checkout)
+	}
+
+	txn, err := m.FetchStatus("DONATION-1-C1")
+	if err != nil || txn.Status != StatusPending {
+		t.Fatalf("FetchStatus = %+v, %v, want pending", txn, err)
+	}
+
+	fake.SetStatus("DONATION-1-C1", "settlement", "accept")
+	body, _ := fake.Notification("DONATION-1-C1")
+	txn, err = m.VerifyNotification(http.Header{}, body)
+	if err != nil || txn.Status != StatusPaid {
+		t.Fatalf("VerifyNotification = %+v, %v, want paid", txn, err)
+	}
+	if want := (money.Money{Amount: 25000, Currency: "IDR"}); txn.Gross != want {
+		t.Errorf("gross = %s, want %s", txn.Gross, want)
+	}
+
+	if _, err := m.FetchStatus("DONATION-404"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown order: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Package midtranstest runs a fake Midtrans for tests. It implements the
+// Snap create-transaction and Core API status and refund endpoints the
+// Midtrans gateway uses, and signs webhook bodies the way Midtrans does.
+package midtranstest
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transaction is the fake's record of one order.
+type Transaction struct {
+	OrderID           string
+	TransactionID     string
+	GrossAmount       int64
+	TransactionStatus string
+	FraudStatus       string
+	Token             string
+	// RefundAmount is the total refunded so far
+	RefundAmount int64
+	// RefundKeys are the refund_key of every refund taken, so a retried
+	// refund is not applied twice.
+	RefundKeys []string
+}
+
+// Server is a fake Midtrans. Point the gateway at URL with
+// payment.Midtrans.SetBaseURL.
+type Server struct {
+	*httptest.Server
+	ServerKey string
+
+	mu           sync.Mutex
+	transactions map[string]*Transaction
+}
+
+// NewServer starts a fake Midtrans that accepts serverKey. Close it when
+// done.
+func NewServer(serverKey string) *Server {
+	s := &Server{ServerKey: serverKey, transactions: make(map[string]*Transaction)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /snap/v1/transactions", s.createTransaction)
+	mux.HandleFunc("GET /v2/{order_id}/status", s.status)
+	mux.HandleFunc("POST /v2/{order_id}/refund", s.refund)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Transaction returns the order's transaction, if Snap created one.
+func (s *Server) Transaction(orderID string) (Transaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.transactions[orderID]
+	if !ok {
+		return Transaction{}, false
+	}
+	return *txn, true
+}
+
+// SetStatus changes the order's status as if the donor had paid, the
+// payment expired and so on. fraudStatus only matters for "capture".
+func (s *Server) SetStatus(orderID, transactionStatus, fraudStatus string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.transactions[orderID]
+	if !ok {
+		return fmt.Errorf("midtranstest: no transaction for order %s", orderID)
+	}
+	txn.TransactionStatus = transactionStatus
+	txn.FraudStatus = fraudStatus
+	return nil
+}
+
+// Notification returns the signed webhook body Midtrans would post for the
+// order's current status.
+func (s *Server) Notification(orderID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.transactions[orderID]
+	if !ok {
+		return nil, fmt.Errorf("midtranstest: no transaction for order %s", orderID)
+	}
+	return json.Marshal(s.statusBody(txn))
+}
+
+type snapRequest struct {
+	TransactionDetails struct {
+		OrderID     string `json:"order_id"`
+		GrossAmount int64  `json:"gross_amount"`
+	} `json:"transaction_details"`
+}
+
+func (s *Server) createTransaction(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{
+			"error_messages": []string{"Access denied due to unauthorized transaction, please check client or server key"},
+		})
+		return
+	}
+
+	var req snapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TransactionDetails.OrderID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error_messages": []string{"transaction_details.order_id is required"},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orderID := req.TransactionDetails.OrderID
+	if _, ok := s.transactions[orderID]; ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error_messages": []string{"transaction_details.order_id has already been taken"},
+		})
+		return
+	}
+
+	txn := &Transaction{
+		OrderID:           orderID,
+		TransactionID:     uuid.NewString(),
+		GrossAmount:       req.TransactionDetails.GrossAmount,
+		TransactionStatus: "pending",
+		Token:             uuid.NewString(),
+	}
+	s.transactions[orderID] = txn
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"token":        txn.Token,
+		"redirect_url": s.URL + "/snap/v4/redirection/" + txn.Token,
+	})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"status_code":    "401",
+			"status_message": "Unknown Merchant server_key/id",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.transactions[r.PathValue("order_id")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"status_code":    "404",
+			"status_message": "Transaction doesn't exist.",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.statusBody(txn))
+}
+
+type refundRequest struct {
+	RefundKey string `json:"refund_key"`
+	Amount    int64  `json:"amount"`
+	Reason    string `json:"reason"`
+}
+
+// refund returns part or all of a settled transaction, moving it to
+// partial_refund or refund.
+func (s *Server) refund(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"status_code":    "401",
+			"status_message": "Unknown Merchant server_key/id",
+		})
+		return
+	}
+
+	var req refundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"status_code": "400", "status_message": "Invalid request"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.transactions[r.PathValue("order_id")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"status_code":    "404",
+			"status_message": "Transaction doesn't exist.",
+		})
+		return
+	}
+
+	if !slices.Contains(txn.RefundKeys, req.RefundKey) {
+		refundable := txn.TransactionStatus == "settlement" || txn.TransactionStatus == "partial_refund"
+		if !refundable || req.Amount <= 0 || txn.RefundAmount+req.Amount > txn.GrossAmount {
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{
+				"status_code":    "412",
+				"status_message": "Transaction status cannot be updated.",
+			})
+			return
+		}
+
+		txn.RefundAmount += req.Amount
+		txn.RefundKeys = append(txn.RefundKeys, req.RefundKey)
+		txn.TransactionStatus = "partial_refund"
+		if txn.RefundAmount == txn.GrossAmount {
+			txn.TransactionStatus = "refund"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status_code":        "200",
+		"status_message":     "Success, refund request is approved",
+		"order_id":           txn.OrderID,
+		"transaction_id":     txn.TransactionID,
+		"transaction_status": txn.TransactionStatus,
+		"refund_amount":      fmt.Sprintf("%d.00", req.Amount),
+		"refund_key":         req.RefundKey,
+	})
+}
+
+// statusBody is the Core API status response, which is also what Midtrans
+// posts to the webhook. The caller holds the lock.
+func (s *Server) statusBody(txn *Transaction) map[string]string {
+	statusCode := "200"
+	if txn.TransactionStatus == "pending" {
+		statusCode = "201"
+	}
+	grossAmount := fmt.Sprintf("%d.00", txn.GrossAmount)
+
+	// signature_key is SHA512(order_id + status_code + gross_amount + server key)
+	sum := sha512.Sum512([]byte(txn.OrderID + statusCode + grossAmount + s.ServerKey))
+
+	body := map[string]string{
+		"transaction_time":   time.Now().Format("2006-01-02 15:04:05"),
+		"transaction_status": txn.TransactionStatus,
+		"transaction_id":     txn.TransactionID,
+		"status_message":     "midtrans payment notification",
+		"status_code":        statusCode,
+		"payment_type":       "qris",
+		"order_id":           txn.OrderID,
+		"gross_amount":       grossAmount,
+		"fraud_status":       txn.FraudStatus,
+		"currency":           "IDR",
+		"signature_key":      hex.EncodeToString(sum[:]),
+	}
+	if txn.RefundAmount > 0 {
+		body["refund_amount"] = fmt.Sprintf("%d.00", txn.RefundAmount)
+	}
+	return body
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	key, _, ok := r.BasicAuth()
+	return ok && key == s.ServerKey
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,232 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"my-platform/internal/money"
+)
+
+// Xendit takes payments through hosted invoices, see
+// https://developers.xendit.co/api-reference/#invoices
+type Xendit struct {
+	SecretKey     string
+	CallbackToken string
+	BaseURL       string
+	HTTPClient    *http.Client
+}
+
+func NewXendit(secretKey, callbackToken string) *Xendit {
+	return &Xendit{
+		SecretKey:     secretKey,
+		CallbackToken: callbackToken,
+		BaseURL:       "https://api.xendit.co",
+		HTTPClient:    &http.Client{Timeout: RequestTimeout},
+	}
+}
+
+// Xendit sends amounts in major units, e.g. 12.5 for PHP 12.50, so they are
+// kept as json.Number and converted with the money package.
+type xenditInvoice struct {
+	ID         string      `json:"id"`
+	ExternalID string      `json:"external_id"`
+	Status     string      `json:"status"`
+	Amount     json.Number `json:"amount"`
+	Currency   string      `json:"currency"`
+	InvoiceURL string      `json:"invoice_url"`
+}
+
+type xenditRefund struct {
+	ID       string      `json:"id"`
+	Status   string      `json:"status"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+// xenditCurrencies are the invoice currencies Xendit accepts.
+var xenditCurrencies = map[string]bool{"IDR": true, "MYR": true, "PHP": true, "THB": true, "VND": true}
+
+// xenditError is the error body returned by every Xendit endpoint.
+type xenditError struct {
+	StatusCode int    `json:"-"`
+	ErrorCode  string `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *xenditError) Error() string {
+	return fmt.Sprintf("xendit: %d %s: %s", e.StatusCode, e.ErrorCode, e.Message)
+}
+
+func (x *Xendit) Name() string {
+	return "xendit"
+}
+
+func (x *Xendit) SupportsCurrency(code string) bool {
+	return xenditCurrencies[code]
+}
+
+func (x *Xendit) CreateCheckout(req CheckoutRequest) (*Checkout, error) {
+	if !x.SupportsCurrency(req.Amount.Currency) {
+		return nil, fmt.Errorf("%w: xendit cannot charge %s", ErrUnsupportedCurrency, req.Amount.Currency)
+	}
+
+	body := map[string]any{
+		"external_id": req.OrderID,
+		"amount":      json.Number(req.Amount.Decimal()),
+		"currency":    req.Amount.Currency,
+		"description": "Donation " + req.OrderID,
+		"customer":    map[string]any{"given_names": req.CustomerName},
+	}
+
+	var invoice xenditInvoice
+	if err := x.do(http.MethodPost, "/v2/invoices", body, &invoice); err != nil {
+		return nil, err
+	}
+	return &Checkout{RedirectURL: invoice.InvoiceURL, Token: invoice.ID}, nil
+}
+
+// VerifyNotification checks the callback token Xendit sends in the
+// x-callback-token header against the one from the Xendit dashboard.
+func (x *Xendit) VerifyNotification(header http.Header, body []byte) (*Transaction, error) {
+	token := header.Get("x-callback-token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(x.CallbackToken)) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	var invoice xenditInvoice
+	if err := json.Unmarshal(body, &invoice); err != nil || invoice.ExternalID == "" {
+		return nil, ErrInvalidNotification
+	}
+	txn, err := xenditTransaction(invoice)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	return txn, nil
+}
+
+func (x *Xendit) FetchStatus(orderID string) (*Transaction, error) {
+	invoice, err := x.findInvoice(orderID)
+	if err != nil {
+		return nil, err
+	}
+	return xenditTransaction(*invoice)
+}
+
+// Refund sends key as the Idempotency-key header, so Xendit answers a
+// retried refund with the refund it already made.
+func (x *Xendit) Refund(orderID string, amount money.Money, reason, key string) (*Refund, error) {
+	invoice, err := x.findInvoice(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]any{
+		"invoice_id": invoice.ID,
+		"amount":     json.Number(amount.Decimal()),
+		"reason":     "OTHERS",
+		"metadata":   map[string]string{"reason": reason},
+	}
+
+	var refund xenditRefund
+	if err := x.request(http.MethodPost, "/refunds", key, body, &refund); err != nil {
+		return nil, err
+	}
+	if refund.Status == "FAILED" {
+		return nil, fmt.Errorf("xendit: refund %s failed", refund.ID)
+	}
+	refunded, err := money.ParseDecimal(refund.Amount.String(), amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{RefundID: refund.ID, Amount: refunded}, nil
+}
+
+func (x *Xendit) findInvoice(orderID string) (*xenditInvoice, error) {
+	var invoices []xenditInvoice
+	if err := x.do(http.MethodGet, "/v2/invoices?external_id="+url.QueryEscape(orderID), nil, &invoices); err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, ErrNotFound
+	}
+	return &invoices[0], nil
+}
+
+func (x *Xendit) do(method, path string, body any, result any) error {
+	return x.request(method, path, "", body, result)
+}
+
+// request calls the Xendit API, sending idempotencyKey unless it is empty.
+func (x *Xendit) request(method, path, idempotencyKey string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, x.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(x.SecretKey, "")
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-key", idempotencyKey)
+	}
+
+	resp, err := x.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		xerr := &xenditError{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, xerr)
+		if resp.StatusCode == http.StatusNotFound {
+			return errors.Join(ErrNotFound, xerr)
+		}
+		return xerr
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// xenditTransaction maps invoice statuses. Xendit reports refunds on the
+// refund object rather than the invoice, so refunds never show up here.
+func xenditTransaction(invoice xenditInvoice) (*Transaction, error) {
+	status := StatusPending
+	switch invoice.Status {
+	case "PAID", "SETTLED":
+		status = StatusPaid
+	case "EXPIRED":
+		status = StatusExpired
+	}
+
+	gross, err := money.ParseDecimal(invoice.Amount.String(), invoice.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("xendit: invoice %s: %w", invoice.ID, err)
+	}
+
+	return &Transaction{
+		OrderID:       invoice.ExternalID,
+		TransactionID: invoice.ID,
+		Status:        status,
+		RawStatus:     invoice.Status,
+		Gross:         gross,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+type PostgresCreators struct {
+	DB *sqlx.DB
+}
+
+const creatorColumns = `c.id, c.user_id, c.username, c.display_name, c.widget_secret_token, c.created_at, c.updated_at,
+	c.bio, c.avatar_url, c.social_links, c.theme, c.accent_color,
+	c.goal_title, c.goal_amount, c.goal_currency, c.goal_started_at`
+
+func (r *PostgresCreators) GetByUserID(userID int) (models.Creator, error) {
+	var creator models.Creator
+	err := r.DB.Get(&creator, `SELECT `+creatorColumns+` FROM creators c WHERE c.user_id = $1`, userID)
+	return creator, translate(err)
+}
+
+func (r *PostgresCreators) GetByWidgetToken(token string) (models.Creator, error) {
+	var creator models.Creator
+	query := `SELECT ` + creatorColumns + ` FROM creators c
+	          INNER JOIN users u ON c.user_id = u.id
+	          WHERE c.widget_secret_token = $1 AND u.deleted_at IS NULL`
+	err := r.DB.Get(&creator, query, token)
+	return creator, translate(err)
+}
+
+func (r *PostgresCreators) GetVerifiedByUsername(username string) (models.Creator, error) {
+	var creator models.Creator
+	query := `SELECT ` + creatorColumns + ` FROM creators c
+	          INNER JOIN users u ON c.user_id = u.id
+	          WHERE c.username = $1 AND u.email_verified_at IS NOT NULL AND u.deleted_at IS NULL`
+	err := r.DB.Get(&creator, query, username)
+	return creator, translate(err)
+}
+
+func (r *PostgresCreators) RotateWidgetToken(userID int, token string) (models.Creator, error) {
+	var creator models.Creator
+	query := `UPDATE creators c SET widget_secret_token = $1, updated_at = NOW()
+	          WHERE c.user_id = $2
+	          RETURNING ` + creatorColumns
+	err := r.DB.Get(&creator, query, token, userID)
+	return creator, translate(err)
+}
+
+func (r *PostgresCreators) UpdateProfile(creator models.Creator) (models.Creator, error) {
+	query := `UPDATE creators c
+	          SET username = $1, display_name = $2,
+	              bio = $3, avatar_url = $4, social_links = $5, theme = $6, accent_color = $7,
+	              goal_title = $8, goal_amount = $9, goal_currency = $10, goal_started_at = $11,
+	              updated_at = NOW()
+	          WHERE c.id = $12
+	          RETURNING ` + creatorColumns
+	var updated models.Creator
+	err := r.DB.Get(&updated, query,
+		creator.Username, creator.DisplayName,
+		creator.Bio, creator.AvatarURL, creator.SocialLinks, creator.Theme, creator.AccentColor,
+		creator.GoalTitle, creator.GoalAmount, creator.GoalCurrency, creator.GoalStartedAt,
+		creator.ID,
+	)
+	return updated, translate(err)
+}
+
+func (r *PostgresCreators) Currencies(creatorID int) ([]models.CreatorCurrency, error) {
+	var accepted []models.CreatorCurrency
+	query := `SELECT creator_id, currency, min_amount, max_amount, created_at
+	          FROM creator_currencies WHERE creator_id = $1
+	          ORDER BY created_at`
+	err := r.DB.Select(&accepted, query, creatorID)
+	return accepted, err
+}
+
+func (r *PostgresCreators) SetCurrency(currency models.CreatorCurrency) error {
+	query := `INSERT INTO creator_currencies (creator_id, currency, min_amount, max_amount)
+	          VALUES ($1, $2, $3, $4)
+	          ON CONFLICT (creator_id, currency)
+	          DO UPDATE SET min_amount = EXCLUDED.min_amount, max_amount = EXCLUDED.max_amount`
+	_, err := r.DB.Exec(query, currency.CreatorID, currency.Currency, currency.MinAmount, currency.MaxAmount)
+	return err
+}
+
+func (r *PostgresCreators) RemoveCurrency(creatorID int, currency string) error {
+	query := `DELETE FROM creator_currencies WHERE creator_id = $1 AND currency = $2`
+	_, err := r.DB.Exec(query, creatorID, currency)
+	return err
+}
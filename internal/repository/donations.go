@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+type PostgresDonations struct {
+	DB *sqlx.DB
+}
+
+func (r *PostgresDonations) CreatePending(d models.Donation) (int, error) {
+	// If a concurrent request with the same idempotency key got there
+	// first, nothing is inserted
+	query := `
+		INSERT INTO donations
+		  (creator_id, amount, currency, donor_name, donor_message,
+		   media_type, media_url, media_start_seconds, media_end_seconds,
+		   order_id, payment_gateway, status, idempotency_key, request_fingerprint)
+		VALUES
+		  ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'pending', $12, $13)
+		ON CONFLICT (creator_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+		RETURNING id
+	`
+	var id int
+	err := r.DB.Get(&id, query,
+		d.CreatorID, d.Amount, d.Currency, d.DonorName, d.DonorMessage,
+		d.MediaType, d.MediaURL, d.MediaStartSeconds, d.MediaEndSeconds,
+		d.OrderID, d.PaymentGateway, d.IdempotencyKey, d.RequestFingerprint,
+	)
+	if err := translate(err); err == ErrNotFound {
+		return 0, ErrConflict
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *PostgresDonations) GetByIdempotencyKey(creatorID int, key string) (models.Donation, error) {
+	var donation models.Donation
+	query := `SELECT id, order_id, status, request_fingerprint, checkout_url FROM donations
+	          WHERE creator_id = $1 AND idempotency_key = $2`
+	err := r.DB.Get(&donation, query, creatorID, key)
+	return donation, translate(err)
+}
+
+func (r *PostgresDonations) SetCheckoutURL(id int, url string) error {
+	_, err := r.DB.Exec(`UPDATE donations SET checkout_url = $1 WHERE id = $2`, url, id)
+	return err
+}
+
+func (r *PostgresDonations) MarkFailed(id int) error {
+	query := `UPDATE donations SET status = 'failed', idempotency_key = NULL WHERE id = $1`
+	_, err := r.DB.Exec(query, id)
+	return err
+}
+
+func (r *PostgresDonations) ListPaidByCreator(creatorID int) ([]models.CreatorDonation, error) {
+	donations := []models.CreatorDonation{}
+	query := `SELECT
+		d.id, d.creator_id, d.order_id, d.amount, d.currency, d.refunded_amount, d.status,
+		d.donor_name, d.donor_message, d.payment_gateway_tx_id, d.payment_gateway, d.created_at,
+		d.media_type, d.media_url, d.media_start_seconds, d.media_end_seconds,
+		d.platform_fee, d.gateway_fee, d.net_amount,
+		COALESCE(a.status, '') AS alert_status
+		FROM donations d
+		LEFT JOIN alerts a ON a.donation_id = d.id
+		WHERE d.creator_id = $1 AND d.status IN ('settled', 'partially_refunded', 'refunded')
+		ORDER BY d.created_at DESC`
+	err := r.DB.Select(&donations, query, creatorID)
+	return donations, err
+}
+
+func (r *PostgresDonations) SettledTotalSince(creatorID int, currency string, since time.Time) (int64, error) {
+	var total int64
+	query := `SELECT COALESCE(SUM(amount - refunded_amount), 0) FROM donations
+	          WHERE creator_id = $1 AND currency = $2 AND created_at >= $3
+	            AND status IN ('settled', 'partially_refunded')`
+	err := r.DB.Get(&total, query, creatorID, currency, since)
+	return total, err
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+type PostgresFeeSchedules struct {
+	DB *sqlx.DB
+}
+
+const feeScheduleColumns = `creator_id, currency, percent_bps, fixed_amount, updated_at`
+
+func (r *PostgresFeeSchedules) List() ([]models.FeeSchedule, error) {
+	schedules := []models.FeeSchedule{}
+	query := `SELECT ` + feeScheduleColumns + ` FROM fee_schedules ORDER BY creator_id NULLS FIRST, currency`
+	err := r.DB.Select(&schedules, query)
+	return schedules, err
+}
+
+func (r *PostgresFeeSchedules) Set(s models.FeeSchedule) (models.FeeSchedule, error) {
+	// (creator_id, currency) is UNIQUE NULLS NOT DISTINCT, so the global
+	// rows upsert too
+	var schedule models.FeeSchedule
+	query := `INSERT INTO fee_schedules (creator_id, currency, percent_bps, fixed_amount)
+	          VALUES ($1, $2, $3, $4)
+	          ON CONFLICT (creator_id, currency)
+	          DO UPDATE SET percent_bps = EXCLUDED.percent_bps, fixed_amount = EXCLUDED.fixed_amount, updated_at = NOW()
+	          RETURNING ` + feeScheduleColumns
+	err := r.DB.Get(&schedule, query, s.CreatorID, s.Currency, s.PercentBPS, s.FixedAmount)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		// No such creator
+		return models.FeeSchedule{}, ErrNotFound
+	}
+	return schedule, translate(err)
+}
+
+func (r *PostgresFeeSchedules) Delete(creatorID *int, currency string) error {
+	query := `DELETE FROM fee_schedules WHERE creator_id IS NOT DISTINCT FROM $1 AND currency = $2`
+	_, err := r.DB.Exec(query, creatorID, currency)
+	return err
+}
@@ -0,0 +1,1238 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"my-platform/internal/alerts"
+	"my-platform/internal/donations"
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+	ws "my-platform/internal/websocket"
+)
+
+// memory holds the tables behind the in-memory repositories. One mutex
+// guards them all, which stands in for transactions.
+type memory struct {
+	mu sync.Mutex
+
+	users         []models.User
+	creators      []models.Creator
+	sessions      []models.Session
+	resets        []models.PasswordReset
+	recoveryCodes []models.RecoveryCode
+	currencies    []models.CreatorCurrency
+	donations     []models.Donation
+	refunds       []models.Refund
+	alerts        []models.Alert
+	books         ledger.Memory
+	bankAccounts  []models.BankAccount
+	payouts       []models.Payout
+	feeSchedules  []models.FeeSchedule
+}
+
+// NewMemory returns repositories that keep everything in memory, for
+// tests. There is no status history or outbox there, and only stored fee
+// schedules apply: without one the platform and gateway keep nothing.
+func NewMemory() Repos {
+	m := &memory{}
+	return Repos{
+		Users:        &MemoryUsers{m},
+		Sessions:     &MemorySessions{m},
+		Creators:     &MemoryCreators{m},
+		Donations:    &MemoryDonations{m},
+		Updater:      &MemoryUpdater{m},
+		Alerts:       &MemoryAlerts{m},
+		BankAccounts: &MemoryBankAccounts{m},
+		Payouts:      &MemoryPayouts{m},
+		FeeSchedules: &MemoryFeeSchedules{m},
+	}
+}
+
+// user returns the user with the ID. The caller holds the lock.
+func (m *memory) user(id int) *models.User {
+	for i := range m.users {
+		if m.users[i].ID == id {
+			return &m.users[i]
+		}
+	}
+	return nil
+}
+
+// owed reports whether the user's creator has a positive balance or a
+// payout that is neither paid nor failed. The caller holds the lock.
+func (m *memory) owed(userID int) bool {
+	for _, c := range m.creators {
+		if c.UserID != userID {
+			continue
+		}
+		for _, b := range m.books.Balances(c.ID, m.payouts) {
+			if b.Available > 0 || b.InTransit > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *memory) revokeAll(userID int, exceptID string) {
+	now := time.Now()
+	for i := range m.sessions {
+		s := &m.sessions[i]
+		if s.UserID == userID && s.RevokedAt == nil && s.ID != exceptID {
+			s.RevokedAt = &now
+		}
+	}
+}
+
+type MemoryUsers struct{ m *memory }
+
+func (r *MemoryUsers) GetByID(id int) (models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if u := r.m.user(id); u != nil {
+		return *u, nil
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r *MemoryUsers) GetByEmail(email string) (models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r *MemoryUsers) CreateWithCreator(user models.User, creator models.Creator) (models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.Email == user.Email {
+			return models.User{}, ErrConflict
+		}
+	}
+	for _, c := range r.m.creators {
+		if c.Username == creator.Username || c.WidgetSecretToken == creator.WidgetSecretToken {
+			return models.User{}, ErrConflict
+		}
+	}
+
+	now := time.Now()
+	user.ID = len(r.m.users) + 1
+	user.CreatedAt, user.UpdatedAt = now, now
+	r.m.users = append(r.m.users, user)
+
+	creator.ID = len(r.m.creators) + 1
+	creator.UserID = user.ID
+	creator.CreatedAt, creator.UpdatedAt = now, now
+	if creator.Theme == "" {
+		creator.Theme = "light"
+	}
+	r.m.creators = append(r.m.creators, creator)
+
+	return user, nil
+}
+
+func (r *MemoryUsers) MarkEmailVerified(id int, email string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil || u.Email != email {
+		return ErrNotFound
+	}
+	if u.EmailVerifiedAt == nil {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	return nil
+}
+
+func (r *MemoryUsers) CountPasswordResetsSince(userID int, since time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	count := 0
+	for _, reset := range r.m.resets {
+		if reset.UserID == userID && reset.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryUsers) CreatePasswordReset(reset models.PasswordReset) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	reset.ID = len(r.m.resets) + 1
+	reset.CreatedAt = time.Now()
+	r.m.resets = append(r.m.resets, reset)
+	return nil
+}
+
+func (r *MemoryUsers) RedeemPasswordReset(tokenHash, passwordHash string, now time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var reset *models.PasswordReset
+	for i := range r.m.resets {
+		if r.m.resets[i].TokenHash == tokenHash {
+			reset = &r.m.resets[i]
+		}
+	}
+	if reset == nil || reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+		return 0, ErrNotFound
+	}
+
+	u := r.m.user(reset.UserID)
+	if u == nil {
+		return 0, ErrNotFound
+	}
+	u.PasswordHash = passwordHash
+
+	for i := range r.m.resets {
+		if r.m.resets[i].UserID == reset.UserID && r.m.resets[i].UsedAt == nil {
+			r.m.resets[i].UsedAt = &now
+		}
+	}
+	r.m.revokeAll(reset.UserID, "")
+
+	return reset.UserID, nil
+}
+
+func (r *MemoryUsers) SetTOTPSecret(id int, secret string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if u := r.m.user(id); u != nil && u.TOTPEnabledAt == nil {
+		u.TOTPSecret = &secret
+		u.TOTPLastStep = nil
+	}
+	return nil
+}
+
+func (r *MemoryUsers) EnableTwoFactor(id int, step int64, recoveryCodeHashes []string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil || u.TOTPEnabledAt != nil {
+		return nil
+	}
+	now := time.Now()
+	u.TOTPEnabledAt = &now
+	u.TOTPLastStep = &step
+
+	r.deleteRecoveryCodes(id)
+	for _, hash := range recoveryCodeHashes {
+		r.m.recoveryCodes = append(r.m.recoveryCodes, models.RecoveryCode{UserID: id, CodeHash: hash, CreatedAt: now})
+	}
+	return nil
+}
+
+func (r *MemoryUsers) DisableTwoFactor(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if u := r.m.user(id); u != nil {
+		u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = nil, nil, nil
+		u.TOTPFailedAttempts, u.TOTPLockedUntil = 0, nil
+	}
+	r.deleteRecoveryCodes(id)
+	return nil
+}
+
+func (r *MemoryUsers) deleteRecoveryCodes(userID int) {
+	kept := r.m.recoveryCodes[:0]
+	for _, code := range r.m.recoveryCodes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	r.m.recoveryCodes = kept
+}
+
+func (r *MemoryUsers) ClaimTOTPStep(id int, step int64) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil || (u.TOTPLastStep != nil && *u.TOTPLastStep >= step) {
+		return false, nil
+	}
+	u.TOTPLastStep = &step
+	u.TOTPFailedAttempts, u.TOTPLockedUntil = 0, nil
+	return true, nil
+}
+
+func (r *MemoryUsers) UseRecoveryCode(id int, codeHash string) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.recoveryCodes {
+		code := &r.m.recoveryCodes[i]
+		if code.UserID == id && code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			if u := r.m.user(id); u != nil {
+				u.TOTPFailedAttempts, u.TOTPLockedUntil = 0, nil
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryUsers) RecordTwoFactorFailure(id int, maxAttempts int, lockout time.Duration) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil {
+		return nil
+	}
+	u.TOTPFailedAttempts++
+	if u.TOTPFailedAttempts >= maxAttempts {
+		until := time.Now().Add(lockout)
+		u.TOTPLockedUntil = &until
+		u.TOTPFailedAttempts = 0
+	}
+	return nil
+}
+
+func (r *MemoryUsers) ChangePassword(id int, passwordHash, exceptSessionID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil {
+		return ErrNotFound
+	}
+	u.PasswordHash = passwordHash
+	u.UpdatedAt = time.Now()
+	r.m.revokeAll(id, exceptSessionID)
+	return nil
+}
+
+func (r *MemoryUsers) ChangeEmail(id int, email string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.Email == email && u.ID != id {
+			return ErrConflict
+		}
+	}
+	u := r.m.user(id)
+	if u == nil {
+		return ErrNotFound
+	}
+	u.Email = email
+	u.EmailVerifiedAt = nil
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryUsers) ScheduleDeletion(id int) (models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil || u.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	if r.m.owed(id) {
+		return models.User{}, ErrMoneyOwed
+	}
+	now := time.Now()
+	u.DeletedAt = &now
+	u.UpdatedAt = now
+	r.m.revokeAll(id, "")
+	return *u, nil
+}
+
+func (r *MemoryUsers) CancelDeletion(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	u := r.m.user(id)
+	if u == nil || u.DeletedAt == nil || u.PurgedAt != nil {
+		return ErrNotFound
+	}
+	u.DeletedAt = nil
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryUsers) PurgeDeleted(before time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	var errs []error
+	for i := range r.m.users {
+		u := &r.m.users[i]
+		if u.DeletedAt == nil || u.PurgedAt != nil || !u.DeletedAt.Before(before) || r.m.owed(u.ID) {
+			continue
+		}
+		// Stand in for the unique constraints on the placeholders
+		placeholder := fmt.Sprintf("deleted:%d", u.ID)
+		if r.m.taken(placeholder) {
+			errs = append(errs, fmt.Errorf("user %d: %w", u.ID, ErrConflict))
+			continue
+		}
+		u.Email = placeholder
+		u.PasswordHash, u.EmailVerifiedAt = "", nil
+		u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = nil, nil, nil
+		u.TOTPFailedAttempts, u.TOTPLockedUntil = 0, nil
+		u.PurgedAt = &now
+
+		for j := range r.m.creators {
+			c := &r.m.creators[j]
+			if c.UserID == u.ID {
+				*c = models.Creator{
+					ID:                c.ID,
+					UserID:            c.UserID,
+					Username:          placeholder,
+					DisplayName:       "Deleted creator",
+					WidgetSecretToken: fmt.Sprintf("purged-%d-%d", u.ID, now.UnixNano()),
+					Theme:             c.Theme,
+					CreatedAt:         c.CreatedAt,
+					UpdatedAt:         now,
+				}
+			}
+		}
+		r.m.revokeAll(u.ID, "")
+		r.deleteRecoveryCodes(u.ID)
+		resets := r.m.resets[:0]
+		for _, reset := range r.m.resets {
+			if reset.UserID != u.ID {
+				resets = append(resets, reset)
+			}
+		}
+		r.m.resets = resets
+		purged++
+	}
+	return purged, errors.Join(errs...)
+}
+
+// taken reports whether any user has the email or any creator the
+// username. The caller holds the lock.
+func (m *memory) taken(value string) bool {
+	for _, u := range m.users {
+		if u.Email == value {
+			return true
+		}
+	}
+	for _, c := range m.creators {
+		if c.Username == value {
+			return true
+		}
+	}
+	return false
+}
+
+type MemorySessions struct{ m *memory }
+
+func (r *MemorySessions) Create(session models.Session) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	now := time.Now()
+	session.CreatedAt, session.LastUsedAt = now, now
+	r.m.sessions = append(r.m.sessions, session)
+	return nil
+}
+
+func activeSession(s models.Session) bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+func (r *MemorySessions) Active(id string, userID int) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, s := range r.m.sessions {
+		if s.ID == id && s.UserID == userID {
+			return activeSession(s), nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemorySessions) ListActive(userID int) ([]models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	sessions := []models.Session{}
+	for _, s := range r.m.sessions {
+		if s.UserID == userID && activeSession(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (r *MemorySessions) Rotate(id, tokenHash, newTokenHash, userAgent, ipAddress string) (models.Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.sessions {
+		s := &r.m.sessions[i]
+		if s.ID != id {
+			continue
+		}
+		if !activeSession(*s) {
+			return models.Session{}, ErrSessionInactive
+		}
+		if s.RefreshTokenHash != tokenHash {
+			now := time.Now()
+			s.RevokedAt = &now
+			return models.Session{}, ErrTokenReused
+		}
+		s.RefreshTokenHash = newTokenHash
+		s.LastUsedAt = time.Now()
+		s.UserAgent, s.IPAddress = userAgent, ipAddress
+		return *s, nil
+	}
+	return models.Session{}, ErrNotFound
+}
+
+func (r *MemorySessions) RevokeByToken(id, tokenHash string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.sessions {
+		s := &r.m.sessions[i]
+		if s.ID == id && s.RefreshTokenHash == tokenHash && s.RevokedAt == nil {
+			now := time.Now()
+			s.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *MemorySessions) Revoke(id string, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.sessions {
+		s := &r.m.sessions[i]
+		if s.ID == id && s.UserID == userID && s.RevokedAt == nil {
+			now := time.Now()
+			s.RevokedAt = &now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemorySessions) RevokeAll(userID int, exceptID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.revokeAll(userID, exceptID)
+	return nil
+}
+
+type MemoryCreators struct{ m *memory }
+
+func (r *MemoryCreators) find(match func(models.Creator) bool) (models.Creator, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, c := range r.m.creators {
+		if match(c) {
+			return c, nil
+		}
+	}
+	return models.Creator{}, ErrNotFound
+}
+
+func (r *MemoryCreators) GetByUserID(userID int) (models.Creator, error) {
+	return r.find(func(c models.Creator) bool { return c.UserID == userID })
+}
+
+func (r *MemoryCreators) GetByWidgetToken(token string) (models.Creator, error) {
+	return r.find(func(c models.Creator) bool {
+		u := r.m.user(c.UserID)
+		return c.WidgetSecretToken == token && u != nil && u.DeletedAt == nil
+	})
+}
+
+func (r *MemoryCreators) GetVerifiedByUsername(username string) (models.Creator, error) {
+	return r.find(func(c models.Creator) bool {
+		u := r.m.user(c.UserID)
+		return c.Username == username && u != nil && u.EmailVerifiedAt != nil && u.DeletedAt == nil
+	})
+}
+
+func (r *MemoryCreators) RotateWidgetToken(userID int, token string) (models.Creator, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.creators {
+		c := &r.m.creators[i]
+		if c.UserID == userID {
+			c.WidgetSecretToken = token
+			c.UpdatedAt = time.Now()
+			return *c, nil
+		}
+	}
+	return models.Creator{}, ErrNotFound
+}
+
+func (r *MemoryCreators) UpdateProfile(creator models.Creator) (models.Creator, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, c := range r.m.creators {
+		if c.Username == creator.Username && c.ID != creator.ID {
+			return models.Creator{}, ErrConflict
+		}
+	}
+	for i := range r.m.creators {
+		c := &r.m.creators[i]
+		if c.ID == creator.ID {
+			c.Username, c.DisplayName = creator.Username, creator.DisplayName
+			c.Bio, c.AvatarURL, c.SocialLinks = creator.Bio, creator.AvatarURL, creator.SocialLinks
+			c.Theme, c.AccentColor = creator.Theme, creator.AccentColor
+			c.GoalTitle, c.GoalAmount = creator.GoalTitle, creator.GoalAmount
+			c.GoalCurrency, c.GoalStartedAt = creator.GoalCurrency, creator.GoalStartedAt
+			c.UpdatedAt = time.Now()
+			return *c, nil
+		}
+	}
+	return models.Creator{}, ErrNotFound
+}
+
+func (r *MemoryCreators) Currencies(creatorID int) ([]models.CreatorCurrency, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var accepted []models.CreatorCurrency
+	for _, c := range r.m.currencies {
+		if c.CreatorID == creatorID {
+			accepted = append(accepted, c)
+		}
+	}
+	return accepted, nil
+}
+
+func (r *MemoryCreators) SetCurrency(currency models.CreatorCurrency) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.currencies {
+		c := &r.m.currencies[i]
+		if c.CreatorID == currency.CreatorID && c.Currency == currency.Currency {
+			c.MinAmount, c.MaxAmount = currency.MinAmount, currency.MaxAmount
+			return nil
+		}
+	}
+	currency.CreatedAt = time.Now()
+	r.m.currencies = append(r.m.currencies, currency)
+	return nil
+}
+
+func (r *MemoryCreators) RemoveCurrency(creatorID int, currency string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	kept := r.m.currencies[:0]
+	for _, c := range r.m.currencies {
+		if c.CreatorID != creatorID || c.Currency != currency {
+			kept = append(kept, c)
+		}
+	}
+	r.m.currencies = kept
+	return nil
+}
+
+type MemoryDonations struct{ m *memory }
+
+func (r *MemoryDonations) donation(id int) *models.Donation {
+	for i := range r.m.donations {
+		if r.m.donations[i].ID == id {
+			return &r.m.donations[i]
+		}
+	}
+	return nil
+}
+
+func (r *MemoryDonations) CreatePending(d models.Donation) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, existing := range r.m.donations {
+		if existing.OrderID == d.OrderID {
+			return 0, ErrConflict
+		}
+		if d.IdempotencyKey != nil && existing.IdempotencyKey != nil &&
+			existing.CreatorID == d.CreatorID && *existing.IdempotencyKey == *d.IdempotencyKey {
+			return 0, ErrConflict
+		}
+	}
+
+	d.ID = len(r.m.donations) + 1
+	d.Status = models.DonationPending
+	d.CreatedAt = time.Now()
+	r.m.donations = append(r.m.donations, d)
+	return d.ID, nil
+}
+
+func (r *MemoryDonations) GetByIdempotencyKey(creatorID int, key string) (models.Donation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, d := range r.m.donations {
+		if d.CreatorID == creatorID && d.IdempotencyKey != nil && *d.IdempotencyKey == key {
+			return d, nil
+		}
+	}
+	return models.Donation{}, ErrNotFound
+}
+
+func (r *MemoryDonations) SetCheckoutURL(id int, url string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if d := r.donation(id); d != nil {
+		d.CheckoutURL = &url
+	}
+	return nil
+}
+
+func (r *MemoryDonations) MarkFailed(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if d := r.donation(id); d != nil {
+		d.Status = models.DonationFailed
+		d.IdempotencyKey = nil
+	}
+	return nil
+}
+
+// Settle settles the donation as a webhook reporting it paid in full
+// would, so tests need not build one.
+func (r *MemoryDonations) Settle(id int) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if d := r.donation(id); d != nil {
+		r.m.apply(payment.Transaction{
+			OrderID:   d.OrderID,
+			Status:    payment.StatusPaid,
+			RawStatus: "settlement",
+			Gross:     money.Money{Amount: d.Amount, Currency: d.Currency},
+		}, "test")
+	}
+}
+
+func (r *MemoryDonations) ListPaidByCreator(creatorID int) ([]models.CreatorDonation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	donations := []models.CreatorDonation{}
+	for _, d := range r.m.donations {
+		switch d.Status {
+		case models.DonationSettled, models.DonationPartiallyRefunded, models.DonationRefunded:
+			if d.CreatorID == creatorID {
+				donation := models.CreatorDonation{Donation: d}
+				for _, a := range r.m.alerts {
+					if a.DonationID == d.ID {
+						donation.AlertStatus = a.Status
+					}
+				}
+				donations = append(donations, donation)
+			}
+		}
+	}
+	sort.SliceStable(donations, func(i, j int) bool { return donations[i].CreatedAt.After(donations[j].CreatedAt) })
+	return donations, nil
+}
+
+func (r *MemoryDonations) SettledTotalSince(creatorID int, currency string, since time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var total int64
+	for _, d := range r.m.donations {
+		switch d.Status {
+		case models.DonationSettled, models.DonationPartiallyRefunded:
+			if d.CreatorID == creatorID && d.Currency == currency && !d.CreatedAt.Before(since) {
+				total += d.Amount - d.RefundedAmount
+			}
+		}
+	}
+	return total, nil
+}
+
+// donationByOrderID returns the donation with the order ID. The caller
+// holds the lock.
+func (m *memory) donationByOrderID(orderID string) *models.Donation {
+	for i := range m.donations {
+		if m.donations[i].OrderID == orderID {
+			return &m.donations[i]
+		}
+	}
+	return nil
+}
+
+// feeSchedule returns the platform fee for the creator's donations in the
+// currency: their own schedule, else the global one, else none. The caller
+// holds the lock.
+func (m *memory) feeSchedule(creatorID int, currency string) ledger.Schedule {
+	var schedule ledger.Schedule
+	for _, s := range m.feeSchedules {
+		if s.Currency != currency {
+			continue
+		}
+		if s.CreatorID == nil || *s.CreatorID == creatorID {
+			schedule = ledger.Schedule{PercentBPS: s.PercentBPS, FixedAmount: s.FixedAmount, Currency: s.Currency}
+			if s.CreatorID != nil {
+				break
+			}
+		}
+	}
+	return schedule
+}
+
+// apply is MemoryUpdater.Apply for a caller that holds the lock.
+func (m *memory) apply(txn payment.Transaction, source string) (donations.Result, error) {
+	d := m.donationByOrderID(txn.OrderID)
+	if d == nil {
+		return donations.Result{}, donations.ErrNotFound
+	}
+
+	result, ok := donations.Decide(*d, txn, source)
+	if !ok {
+		return result, nil
+	}
+	if err := m.transition(d, &result, txn); err != nil {
+		return donations.Result{}, err
+	}
+	result.Changed = true
+	return result, nil
+}
+
+// transition does what donations.Updater does when a donation changes
+// status, short of the history row and the outbox message. The caller
+// holds the lock.
+func (m *memory) transition(d *models.Donation, result *donations.Result, txn payment.Transaction) error {
+	before := *d
+
+	d.Status = result.To
+	if txn.TransactionID != "" {
+		d.PaymentGatewayTxID = txn.TransactionID
+	}
+	if result.To == models.DonationRefunded {
+		d.RefundedAmount = d.Amount
+	} else {
+		d.RefundedAmount = max(d.RefundedAmount, txn.RefundedAmount)
+	}
+
+	if refunded := d.RefundedAmount - before.RefundedAmount; refunded > 0 {
+		ref := ledger.RefundReference(d.OrderID, d.RefundedAmount)
+		if err := ledger.RecordRefund(&m.books, before, ref, refunded); err != nil {
+			return err
+		}
+	}
+
+	switch result.To {
+	case models.DonationSettled:
+		gross := money.Money{Amount: d.Amount, Currency: d.Currency}
+		fees := ledger.Split(m.feeSchedule(d.CreatorID, d.Currency), ledger.Schedule{}, gross)
+		d.PlatformFee, d.GatewayFee, d.NetAmount = &fees.Platform, &fees.Gateway, &fees.Net
+		if err := ledger.RecordDonation(&m.books, *d, fees); err != nil {
+			return err
+		}
+		m.alerts = append(m.alerts, models.Alert{
+			ID:         len(m.alerts) + 1,
+			CreatorID:  d.CreatorID,
+			DonationID: d.ID,
+			Status:     alerts.StatusPending,
+			CreatedAt:  time.Now(),
+		})
+	case models.DonationRefunded:
+		for i := range m.alerts {
+			if a := &m.alerts[i]; a.DonationID == d.ID && a.Status == alerts.StatusPending {
+				a.Status = alerts.StatusSkipped
+			}
+		}
+	}
+
+	result.Donation = *d
+	return nil
+}
+
+type MemoryUpdater struct{ m *memory }
+
+func (r *MemoryUpdater) Apply(txn payment.Transaction, source string) (donations.Result, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	return r.m.apply(txn, source)
+}
+
+// Refund reserves the refund, calls the gateway without the lock held and
+// then records it, as donations.Updater does.
+func (r *MemoryUpdater) Refund(gateways *payment.Registry, req donations.RefundRequest) (donations.Result, error) {
+	pending, err := r.reserveRefund(gateways, req)
+	if err != nil {
+		return donations.Result{}, err
+	}
+
+	refund, err := pending.Gateway.Refund(pending.OrderID, pending.Amount, req.Reason, pending.Key)
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	record := &r.m.refunds[pending.ID-1]
+	if err != nil {
+		record.Status = models.RefundFailed
+		return donations.Result{}, fmt.Errorf("%w: %w", donations.ErrGateway, err)
+	}
+	record.Status, record.GatewayRefundID = models.RefundSucceeded, refund.RefundID
+
+	d := r.m.donationByOrderID(pending.OrderID)
+	result, ok := donations.DecideRefund(*d, pending.Total)
+	if ok {
+		txn := payment.Transaction{OrderID: d.OrderID, RawStatus: "refund", RefundedAmount: pending.Total}
+		if err := r.m.transition(d, &result, txn); err != nil {
+			return donations.Result{}, err
+		}
+		result.Changed = true
+	}
+	return result, nil
+}
+
+func (r *MemoryUpdater) reserveRefund(gateways *payment.Registry, req donations.RefundRequest) (donations.PendingRefund, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	d := r.m.donationByOrderID(req.OrderID)
+	if d == nil {
+		return donations.PendingRefund{}, donations.ErrNotFound
+	}
+	gateway, ok := gateways.Get(d.PaymentGateway)
+	if !ok {
+		return donations.PendingRefund{}, donations.ErrNotRefundable
+	}
+
+	donation := *d
+	for _, refund := range r.m.refunds {
+		if refund.DonationID == d.ID && refund.Status == models.RefundPending &&
+			time.Since(refund.CreatedAt) < donations.PendingRefundTTL {
+			donation.RefundedAmount += refund.Amount
+		}
+	}
+	amount, err := donations.PlanRefund(donation, req)
+	if err != nil {
+		return donations.PendingRefund{}, err
+	}
+
+	record := models.Refund{
+		ID:         len(r.m.refunds) + 1,
+		DonationID: d.ID,
+		Amount:     amount,
+		Reason:     req.Reason,
+		Status:     models.RefundPending,
+		CreatedAt:  time.Now(),
+	}
+	if req.Operator != "" {
+		record.RequestedByOperator = &req.Operator
+	} else {
+		record.RequestedBy = &req.RequestedBy
+	}
+	r.m.refunds = append(r.m.refunds, record)
+
+	return donations.PendingRefund{
+		ID:      record.ID,
+		OrderID: d.OrderID,
+		Gateway: gateway,
+		Amount:  money.Money{Amount: amount, Currency: d.Currency},
+		Total:   donation.RefundedAmount + amount,
+		Key:     donations.RefundKey(donation, amount),
+	}, nil
+}
+
+type MemoryAlerts struct{ m *memory }
+
+func (r *MemoryAlerts) Pending(creatorID int) ([]ws.DonationAlert, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var pending []ws.DonationAlert
+	for _, a := range r.m.alerts {
+		if a.CreatorID != creatorID || a.Status != alerts.StatusPending {
+			continue
+		}
+		for _, d := range r.m.donations {
+			if d.ID == a.DonationID {
+				pending = append(pending, ws.DonationAlert{
+					ID:                a.ID,
+					TargetCreatorID:   creatorID,
+					DonorName:         d.DonorName,
+					Amount:            d.Amount,
+					Currency:          d.Currency,
+					DonorMessage:      d.DonorMessage,
+					MediaType:         d.MediaType,
+					MediaURL:          d.MediaURL,
+					MediaStartSeconds: d.MediaStartSeconds,
+					MediaEndSeconds:   d.MediaEndSeconds,
+				})
+			}
+		}
+		if len(pending) == alerts.ReplayBatchSize {
+			break
+		}
+	}
+	return pending, nil
+}
+
+func (r *MemoryAlerts) MarkDelivered(creatorID, alertID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.alerts {
+		if a := &r.m.alerts[i]; a.ID == alertID && a.CreatorID == creatorID && a.DeliveredAt == nil {
+			now := time.Now()
+			a.DeliveredAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *MemoryAlerts) Resolve(creatorID, alertID int, status string) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range r.m.alerts {
+		a := &r.m.alerts[i]
+		if a.ID != alertID || a.CreatorID != creatorID || a.Status != alerts.StatusPending {
+			continue
+		}
+		now := time.Now()
+		a.Status = status
+		if a.DeliveredAt == nil {
+			a.DeliveredAt = &now
+		}
+		if status == alerts.StatusDisplayed {
+			a.DisplayedAt = &now
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+type MemoryBankAccounts struct{ m *memory }
+
+func (r *MemoryBankAccounts) ListByCreator(creatorID int) ([]models.BankAccount, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	accounts := []models.BankAccount{}
+	for _, a := range r.m.bankAccounts {
+		if a.CreatorID == creatorID {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+func (r *MemoryBankAccounts) Create(account models.BankAccount) (models.BankAccount, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	account.ID = len(r.m.bankAccounts) + 1
+	account.CreatedAt = time.Now()
+	r.m.bankAccounts = append(r.m.bankAccounts, account)
+	return account, nil
+}
+
+type MemoryPayouts struct{ m *memory }
+
+func (r *MemoryPayouts) Balances(creatorID int) ([]ledger.Balance, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	return r.m.books.Balances(creatorID, r.m.payouts), nil
+}
+
+func (r *MemoryPayouts) ListByCreator(creatorID int) ([]models.Payout, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	payouts := []models.Payout{}
+	for _, p := range r.m.payouts {
+		if p.CreatorID == creatorID {
+			payouts = append(payouts, p)
+		}
+	}
+	slices.Reverse(payouts)
+	return payouts, nil
+}
+
+func (r *MemoryPayouts) List(status string) ([]models.Payout, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	payouts := []models.Payout{}
+	for _, p := range r.m.payouts {
+		if status == "" || p.Status == status {
+			payouts = append(payouts, p)
+		}
+	}
+	return payouts, nil
+}
+
+func (r *MemoryPayouts) Request(creatorID, bankAccountID int, amount money.Money) (models.Payout, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if !slices.ContainsFunc(r.m.bankAccounts, func(a models.BankAccount) bool {
+		return a.ID == bankAccountID && a.CreatorID == creatorID
+	}) {
+		return models.Payout{}, ErrNotFound
+	}
+	if amount.Amount > r.m.books.Sum(ledger.CreatorAccount(creatorID), amount.Currency) {
+		return models.Payout{}, ErrInsufficientFunds
+	}
+
+	now := time.Now()
+	payout := models.Payout{
+		ID:            len(r.m.payouts) + 1,
+		CreatorID:     creatorID,
+		BankAccountID: bankAccountID,
+		Amount:        amount.Amount,
+		Currency:      amount.Currency,
+		Status:        models.PayoutRequested,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := ledger.RecordPayoutRequest(&r.m.books, payout); err != nil {
+		return models.Payout{}, err
+	}
+	r.m.payouts = append(r.m.payouts, payout)
+	return payout, nil
+}
+
+func (r *MemoryPayouts) Move(id int, to, reason string) (models.Payout, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	i := slices.IndexFunc(r.m.payouts, func(p models.Payout) bool { return p.ID == id })
+	if i < 0 {
+		return models.Payout{}, ErrNotFound
+	}
+	p := &r.m.payouts[i]
+	before := *p
+	if !models.CanTransitionPayout(p.Status, to) {
+		return before, ErrInvalidTransition
+	}
+
+	var err error
+	switch to {
+	case models.PayoutPaid:
+		err = ledger.RecordPayoutPaid(&r.m.books, before)
+	case models.PayoutFailed:
+		err = ledger.RecordPayoutFailed(&r.m.books, before)
+	}
+	if err != nil {
+		return models.Payout{}, err
+	}
+
+	now := time.Now()
+	p.Status, p.UpdatedAt = to, now
+	if reason != "" {
+		p.FailureReason = &reason
+	}
+	switch to {
+	case models.PayoutApproved:
+		p.ApprovedAt = &now
+	case models.PayoutPaid:
+		p.PaidAt = &now
+	}
+	return before, nil
+}
+
+type MemoryFeeSchedules struct{ m *memory }
+
+func (r *MemoryFeeSchedules) List() ([]models.FeeSchedule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	schedules := slices.Clone(r.m.feeSchedules)
+	sort.SliceStable(schedules, func(i, j int) bool {
+		a, b := schedules[i], schedules[j]
+		if (a.CreatorID == nil) != (b.CreatorID == nil) {
+			return a.CreatorID == nil
+		}
+		if a.CreatorID != nil && *a.CreatorID != *b.CreatorID {
+			return *a.CreatorID < *b.CreatorID
+		}
+		return a.Currency < b.Currency
+	})
+	if schedules == nil {
+		schedules = []models.FeeSchedule{}
+	}
+	return schedules, nil
+}
+
+// sameFeeScope reports whether a schedule is for the creator, or is the
+// global one if creatorID is nil, in the currency.
+func sameFeeScope(s models.FeeSchedule, creatorID *int, currency string) bool {
+	if s.Currency != currency || (s.CreatorID == nil) != (creatorID == nil) {
+		return false
+	}
+	return creatorID == nil || *s.CreatorID == *creatorID
+}
+
+func (r *MemoryFeeSchedules) Set(schedule models.FeeSchedule) (models.FeeSchedule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if schedule.CreatorID != nil && !slices.ContainsFunc(r.m.creators, func(c models.Creator) bool {
+		return c.ID == *schedule.CreatorID
+	}) {
+		return models.FeeSchedule{}, ErrNotFound
+	}
+
+	schedule.UpdatedAt = time.Now()
+	for i, s := range r.m.feeSchedules {
+		if sameFeeScope(s, schedule.CreatorID, schedule.Currency) {
+			r.m.feeSchedules[i] = schedule
+			return schedule, nil
+		}
+	}
+	r.m.feeSchedules = append(r.m.feeSchedules, schedule)
+	return schedule, nil
+}
+
+func (r *MemoryFeeSchedules) Delete(creatorID *int, currency string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.feeSchedules = slices.DeleteFunc(r.m.feeSchedules, func(s models.FeeSchedule) bool {
+		return sameFeeScope(s, creatorID, currency)
+	})
+	return nil
+}
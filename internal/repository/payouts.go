@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+)
+
+type PostgresBankAccounts struct {
+	DB *sqlx.DB
+}
+
+const bankAccountColumns = `id, creator_id, bank_code, account_number, account_holder_name, created_at`
+
+func (r *PostgresBankAccounts) ListByCreator(creatorID int) ([]models.BankAccount, error) {
+	accounts := []models.BankAccount{}
+	query := `SELECT ` + bankAccountColumns + ` FROM bank_accounts WHERE creator_id = $1 ORDER BY created_at`
+	err := r.DB.Select(&accounts, query, creatorID)
+	return accounts, err
+}
+
+func (r *PostgresBankAccounts) Create(a models.BankAccount) (models.BankAccount, error) {
+	var account models.BankAccount
+	query := `INSERT INTO bank_accounts (creator_id, bank_code, account_number, account_holder_name)
+	          VALUES ($1, $2, $3, $4)
+	          RETURNING ` + bankAccountColumns
+	err := r.DB.Get(&account, query, a.CreatorID, a.BankCode, a.AccountNumber, a.AccountHolderName)
+	return account, translate(err)
+}
+
+type PostgresPayouts struct {
+	DB *sqlx.DB
+}
+
+const payoutColumns = `id, creator_id, bank_account_id, amount, currency, status, failure_reason,
+	created_at, updated_at, approved_at, paid_at`
+
+func (r *PostgresPayouts) Balances(creatorID int) ([]ledger.Balance, error) {
+	return ledger.Balances(r.DB, creatorID)
+}
+
+func (r *PostgresPayouts) ListByCreator(creatorID int) ([]models.Payout, error) {
+	payouts := []models.Payout{}
+	query := `SELECT ` + payoutColumns + ` FROM payouts WHERE creator_id = $1 ORDER BY created_at DESC`
+	err := r.DB.Select(&payouts, query, creatorID)
+	return payouts, err
+}
+
+func (r *PostgresPayouts) List(status string) ([]models.Payout, error) {
+	payouts := []models.Payout{}
+	query := `SELECT ` + payoutColumns + ` FROM payouts
+	          WHERE $1 = '' OR status = $1
+	          ORDER BY created_at`
+	err := r.DB.Select(&payouts, query, status)
+	return payouts, err
+}
+
+func (r *PostgresPayouts) Request(creatorID, bankAccountID int, amount money.Money) (models.Payout, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return models.Payout{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the creator so two requests cannot both spend the same balance
+	if _, err := tx.Exec(`SELECT id FROM creators WHERE id = $1 FOR UPDATE`, creatorID); err != nil {
+		return models.Payout{}, err
+	}
+
+	var accountID int
+	query := `SELECT id FROM bank_accounts WHERE id = $1 AND creator_id = $2`
+	if err := tx.Get(&accountID, query, bankAccountID, creatorID); err != nil {
+		return models.Payout{}, translate(err)
+	}
+
+	available, err := ledger.AvailableTx(tx, creatorID, amount.Currency)
+	if err != nil {
+		return models.Payout{}, err
+	}
+	if amount.Amount > available {
+		return models.Payout{}, ErrInsufficientFunds
+	}
+
+	var payout models.Payout
+	query = `INSERT INTO payouts (creator_id, bank_account_id, amount, currency, status)
+	         VALUES ($1, $2, $3, $4, 'requested')
+	         RETURNING ` + payoutColumns
+	if err := tx.Get(&payout, query, creatorID, accountID, amount.Amount, amount.Currency); err != nil {
+		return models.Payout{}, err
+	}
+	if err := ledger.RecordPayoutRequest(ledger.Tx{Tx: tx}, payout); err != nil {
+		return models.Payout{}, err
+	}
+
+	return payout, tx.Commit()
+}
+
+func (r *PostgresPayouts) Move(id int, to, reason string) (models.Payout, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return models.Payout{}, err
+	}
+	defer tx.Rollback()
+
+	var payout models.Payout
+	query := `SELECT ` + payoutColumns + ` FROM payouts WHERE id = $1 FOR UPDATE`
+	if err := tx.Get(&payout, query, id); err != nil {
+		return models.Payout{}, translate(err)
+	}
+	if !models.CanTransitionPayout(payout.Status, to) {
+		return payout, ErrInvalidTransition
+	}
+
+	query = `UPDATE payouts
+	         SET status = $1,
+	             failure_reason = NULLIF($2, ''),
+	             approved_at = CASE WHEN $1 = 'approved' THEN NOW() ELSE approved_at END,
+	             paid_at = CASE WHEN $1 = 'paid' THEN NOW() ELSE paid_at END,
+	             updated_at = NOW()
+	         WHERE id = $3`
+	if _, err := tx.Exec(query, to, reason, payout.ID); err != nil {
+		return models.Payout{}, err
+	}
+
+	switch to {
+	case models.PayoutPaid:
+		err = ledger.RecordPayoutPaid(ledger.Tx{Tx: tx}, payout)
+	case models.PayoutFailed:
+		err = ledger.RecordPayoutFailed(ledger.Tx{Tx: tx}, payout)
+	}
+	if err != nil {
+		return models.Payout{}, err
+	}
+
+	return payout, tx.Commit()
+}
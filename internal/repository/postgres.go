@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/alerts"
+	"my-platform/internal/donations"
+)
+
+// PostgreSQL error codes for a duplicate key and a reference to a missing
+// row.
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// NewPostgres returns the repositories backed by PostgreSQL. The alert
+// queue and donation updater are built by their own packages, since the
+// updater needs the fee settings, and passed in.
+func NewPostgres(db *sqlx.DB, queue *alerts.Queue, updater *donations.Updater) Repos {
+	return Repos{
+		Users:        &PostgresUsers{DB: db},
+		Sessions:     &PostgresSessions{DB: db},
+		Creators:     &PostgresCreators{DB: db},
+		Donations:    &PostgresDonations{DB: db},
+		Updater:      updater,
+		Alerts:       queue,
+		BankAccounts: &PostgresBankAccounts{DB: db},
+		Payouts:      &PostgresPayouts{DB: db},
+		FeeSchedules: &PostgresFeeSchedules{DB: db},
+	}
+}
+
+// translate turns driver errors into the package's errors.
+func translate(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrConflict
+	}
+	return err
+}
+
+// affected returns ErrNotFound if the statement changed no rows.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return translate(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
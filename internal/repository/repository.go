@@ -0,0 +1,224 @@
+// Package repository is the storage behind the HTTP handlers. Each
+// repository has a PostgreSQL implementation for the server and an
+// in-memory one for tests.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"my-platform/internal/donations"
+	"my-platform/internal/ledger"
+	"my-platform/internal/models"
+	"my-platform/internal/money"
+	"my-platform/internal/payment"
+	ws "my-platform/internal/websocket"
+)
+
+var (
+	// ErrNotFound means no row matched.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means a unique value, such as an email, is already taken.
+	ErrConflict = errors.New("already exists")
+	// ErrSessionInactive means the session was revoked or has expired.
+	ErrSessionInactive = errors.New("session revoked or expired")
+	// ErrTokenReused means a refresh token that was already rotated was
+	// presented again. The session has been revoked.
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrMoneyOwed means the user's creator has a balance or a payout
+	// that is not settled yet.
+	ErrMoneyOwed = errors.New("creator is still owed money")
+	// ErrInsufficientFunds means a payout exceeds the creator's available
+	// balance in its currency.
+	ErrInsufficientFunds = errors.New("amount exceeds the available balance")
+	// ErrInvalidTransition means a payout cannot move to the status asked
+	// for from the one it is in.
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+type UserRepo interface {
+	GetByID(id int) (models.User, error)
+	GetByEmail(email string) (models.User, error)
+	// CreateWithCreator stores a user and their creator profile together.
+	// It returns ErrConflict if the email or username is taken.
+	CreateWithCreator(user models.User, creator models.Creator) (models.User, error)
+	// MarkEmailVerified verifies the user if their email is still the one
+	// given, else returns ErrNotFound.
+	MarkEmailVerified(id int, email string) error
+
+	CountPasswordResetsSince(userID int, since time.Time) (int, error)
+	CreatePasswordReset(reset models.PasswordReset) error
+	// RedeemPasswordReset sets a new password with an unused, unexpired
+	// reset token, spends all of the user's reset tokens and revokes their
+	// sessions. It returns the user ID, or ErrNotFound for a bad token.
+	RedeemPasswordReset(tokenHash, passwordHash string, now time.Time) (int, error)
+
+	// SetTOTPSecret starts two-factor enrollment unless it is enabled.
+	SetTOTPSecret(id int, secret string) error
+	// EnableTwoFactor turns two-factor on and replaces the recovery codes.
+	EnableTwoFactor(id int, step int64, recoveryCodeHashes []string) error
+	DisableTwoFactor(id int) error
+	// ClaimTOTPStep records a used TOTP step, reporting false if it or a
+	// later one was used already. It clears the failed attempts.
+	ClaimTOTPStep(id int, step int64) (bool, error)
+	// UseRecoveryCode spends a recovery code, reporting false if there is
+	// no such unused code. It clears the failed attempts.
+	UseRecoveryCode(id int, codeHash string) (bool, error)
+	// RecordTwoFactorFailure counts a wrong code, locking two-factor for
+	// lockout once maxAttempts are reached.
+	RecordTwoFactorFailure(id int, maxAttempts int, lockout time.Duration) error
+
+	// ChangePassword sets a new password and revokes the user's sessions
+	// except exceptSessionID.
+	ChangePassword(id int, passwordHash, exceptSessionID string) error
+	// ChangeEmail sets a new, unverified email. It returns ErrConflict if
+	// the email is taken.
+	ChangeEmail(id int, email string) error
+	// ScheduleDeletion marks the user deleted and revokes their sessions.
+	// It returns ErrMoneyOwed while their creator is owed money.
+	ScheduleDeletion(id int) (models.User, error)
+	// CancelDeletion restores a deleted user that was not purged yet.
+	CancelDeletion(id int) error
+	// PurgeDeleted scrubs the personal data of users deleted before the
+	// time and returns how many it purged. Users still owed money are
+	// skipped. A user that fails does not stop the others; the errors are
+	// joined.
+	PurgeDeleted(before time.Time) (int, error)
+}
+
+type SessionRepo interface {
+	Create(session models.Session) error
+	// Active reports whether the user's session is neither revoked nor
+	// expired.
+	Active(id string, userID int) (bool, error)
+	// ListActive returns the user's active sessions, most recently used
+	// first.
+	ListActive(userID int) ([]models.Session, error)
+	// Rotate replaces the refresh token hash of an active session. A hash
+	// that does not match revokes the session and returns ErrTokenReused.
+	Rotate(id, tokenHash, newTokenHash, userAgent, ipAddress string) (models.Session, error)
+	// RevokeByToken revokes the session if the token matches. Revoking an
+	// unknown session is not an error.
+	RevokeByToken(id, tokenHash string) error
+	// Revoke revokes one of the user's active sessions, or returns
+	// ErrNotFound.
+	Revoke(id string, userID int) error
+	// RevokeAll revokes the user's sessions except exceptID, which may be
+	// empty.
+	RevokeAll(userID int, exceptID string) error
+}
+
+type CreatorRepo interface {
+	GetByUserID(userID int) (models.Creator, error)
+	// GetByWidgetToken skips creators whose account is deleted.
+	GetByWidgetToken(token string) (models.Creator, error)
+	// GetVerifiedByUsername finds a creator whose email is verified and
+	// whose account is not deleted, as only they get a public donation
+	// page.
+	GetVerifiedByUsername(username string) (models.Creator, error)
+	RotateWidgetToken(userID int, token string) (models.Creator, error)
+	// UpdateProfile saves the editable fields of the creator, from the
+	// username to the donation page, and returns the updated creator. It
+	// returns ErrConflict if the username is taken.
+	UpdateProfile(creator models.Creator) (models.Creator, error)
+
+	// Currencies lists the currencies the creator accepts, oldest first.
+	Currencies(creatorID int) ([]models.CreatorCurrency, error)
+	// SetCurrency adds a currency or updates its limits.
+	SetCurrency(currency models.CreatorCurrency) error
+	RemoveCurrency(creatorID int, currency string) error
+}
+
+type DonationRepo interface {
+	// CreatePending stores a new pending donation and returns its ID. It
+	// returns ErrConflict if the creator already has a donation with the
+	// same idempotency key.
+	CreatePending(donation models.Donation) (int, error)
+	GetByIdempotencyKey(creatorID int, key string) (models.Donation, error)
+	SetCheckoutURL(id int, url string) error
+	// MarkFailed fails a donation whose checkout could not be created and
+	// frees its idempotency key for a retry.
+	MarkFailed(id int) error
+	// ListPaidByCreator lists the creator's paid donations, including
+	// refunded ones, newest first.
+	ListPaidByCreator(creatorID int) ([]models.CreatorDonation, error)
+	// SettledTotalSince sums what the creator kept of donations in the
+	// currency made since the time, net of refunds but not of fees.
+	SettledTotalSince(creatorID int, currency string, since time.Time) (int64, error)
+}
+
+// DonationUpdater moves donations through their status state machine. The
+// PostgreSQL one is donations.Updater.
+type DonationUpdater interface {
+	// Apply moves the donation to the status the gateway reported.
+	Apply(txn payment.Transaction, source string) (donations.Result, error)
+	// Refund returns money to the donor through the gateway that took the
+	// payment and records it.
+	Refund(gateways *payment.Registry, req donations.RefundRequest) (donations.Result, error)
+}
+
+// AlertRepo is the replay queue of donation alerts. The PostgreSQL one is
+// alerts.Queue.
+type AlertRepo interface {
+	// Pending returns up to alerts.ReplayBatchSize of the creator's
+	// unresolved alerts, oldest first.
+	Pending(creatorID int) ([]ws.DonationAlert, error)
+	// MarkDelivered records the first time the alert was written to one of
+	// the creator's overlays.
+	MarkDelivered(creatorID, alertID int) error
+	// Resolve records an overlay's outcome for one of its creator's
+	// pending alerts. It reports false if none matched.
+	Resolve(creatorID, alertID int, status string) (bool, error)
+}
+
+type BankAccountRepo interface {
+	// ListByCreator lists the creator's bank accounts, oldest first.
+	ListByCreator(creatorID int) ([]models.BankAccount, error)
+	Create(account models.BankAccount) (models.BankAccount, error)
+}
+
+type PayoutRepo interface {
+	// Balances returns what the creator is owed and their lifetime totals,
+	// one entry per currency.
+	Balances(creatorID int) ([]ledger.Balance, error)
+	// ListByCreator lists the creator's payouts, newest first.
+	ListByCreator(creatorID int) ([]models.Payout, error)
+	// List lists the payouts with the status, or all of them if it is
+	// empty, oldest first.
+	List(status string) ([]models.Payout, error)
+	// Request reserves part of the creator's available balance for a
+	// transfer to one of their bank accounts. It returns ErrNotFound if
+	// the bank account is not theirs and ErrInsufficientFunds if the
+	// balance is too low.
+	Request(creatorID, bankAccountID int, amount money.Money) (models.Payout, error)
+	// Move applies an admin decision to a payout and books it: paid moves
+	// the money out of transit, failed returns it to the creator. It
+	// returns the payout as it was, and ErrInvalidTransition if it cannot
+	// move to the status.
+	Move(id int, to, reason string) (models.Payout, error)
+}
+
+type FeeScheduleRepo interface {
+	// List returns every schedule, the global ones first.
+	List() ([]models.FeeSchedule, error)
+	// Set stores a schedule, replacing the one for the same creator and
+	// currency. A nil CreatorID sets the global schedule. It returns
+	// ErrNotFound if there is no such creator.
+	Set(schedule models.FeeSchedule) (models.FeeSchedule, error)
+	// Delete drops a schedule. Deleting one that does not exist is not an
+	// error.
+	Delete(creatorID *int, currency string) error
+}
+
+// Repos bundles the repositories of one store.
+type Repos struct {
+	Users        UserRepo
+	Sessions     SessionRepo
+	Creators     CreatorRepo
+	Donations    DonationRepo
+	Updater      DonationUpdater
+	Alerts       AlertRepo
+	BankAccounts BankAccountRepo
+	Payouts      PayoutRepo
+	FeeSchedules FeeScheduleRepo
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+type PostgresSessions struct {
+	DB *sqlx.DB
+}
+
+func (r *PostgresSessions) Create(session models.Session) error {
+	query := `INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+	          VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.DB.Exec(query, session.ID, session.UserID, session.RefreshTokenHash,
+		session.UserAgent, session.IPAddress, session.ExpiresAt)
+	return err
+}
+
+func (r *PostgresSessions) Active(id string, userID int) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS (
+	            SELECT 1 FROM sessions
+	            WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	          )`
+	err := r.DB.Get(&active, query, id, userID)
+	return active, err
+}
+
+func (r *PostgresSessions) ListActive(userID int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	query := `SELECT id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at
+	          FROM sessions
+	          WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	          ORDER BY last_used_at DESC`
+	err := r.DB.Select(&sessions, query, userID)
+	return sessions, err
+}
+
+func (r *PostgresSessions) Rotate(id, tokenHash, newTokenHash, userAgent, ipAddress string) (models.Session, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return models.Session{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so two concurrent refreshes cannot both rotate it
+	var session models.Session
+	query := `SELECT id, user_id, refresh_token_hash, expires_at, revoked_at
+	          FROM sessions WHERE id = $1 FOR UPDATE`
+	if err := tx.Get(&session, query, id); err != nil {
+		return models.Session{}, translate(err)
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return models.Session{}, ErrSessionInactive
+	}
+
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(tokenHash)) != 1 {
+		if _, err := tx.Exec(`UPDATE sessions SET revoked_at = NOW() WHERE id = $1`, session.ID); err != nil {
+			log.Println("Failed to revoke session:", err)
+		} else if err := tx.Commit(); err != nil {
+			log.Println("Failed to commit transaction:", err)
+		}
+		return models.Session{}, ErrTokenReused
+	}
+
+	query = `UPDATE sessions
+	         SET refresh_token_hash = $1, last_used_at = NOW(), user_agent = $2, ip_address = $3
+	         WHERE id = $4`
+	if _, err := tx.Exec(query, newTokenHash, userAgent, ipAddress, session.ID); err != nil {
+		return models.Session{}, err
+	}
+	session.RefreshTokenHash = newTokenHash
+
+	return session, tx.Commit()
+}
+
+func (r *PostgresSessions) RevokeByToken(id, tokenHash string) error {
+	query := `UPDATE sessions SET revoked_at = NOW()
+	          WHERE id = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL`
+	_, err := r.DB.Exec(query, id, tokenHash)
+	return err
+}
+
+func (r *PostgresSessions) Revoke(id string, userID int) error {
+	query := `UPDATE sessions SET revoked_at = NOW()
+	          WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	return affected(r.DB.Exec(query, id, userID))
+}
+
+func (r *PostgresSessions) RevokeAll(userID int, exceptID string) error {
+	return revokeAll(r.DB, userID, exceptID)
+}
+
+// revokeAll ends every active session of the user except exceptID, which
+// may be empty to revoke them all.
+func revokeAll(db sqlx.Execer, userID int, exceptID string) error {
+	query := `UPDATE sessions SET revoked_at = NOW()
+	          WHERE user_id = $1 AND revoked_at IS NULL AND id::text <> $2`
+	_, err := db.Exec(query, userID, exceptID)
+	return err
+}
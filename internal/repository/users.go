@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"my-platform/internal/models"
+)
+
+type PostgresUsers struct {
+	DB *sqlx.DB
+}
+
+const userColumns = `id, email, password_hash, email_verified_at, created_at, updated_at,
+	totp_secret, totp_enabled_at, totp_last_step, totp_failed_attempts, totp_locked_until,
+	deleted_at, purged_at`
+
+func (r *PostgresUsers) GetByID(id int) (models.User, error) {
+	var user models.User
+	err := r.DB.Get(&user, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+	return user, translate(err)
+}
+
+func (r *PostgresUsers) GetByEmail(email string) (models.User, error) {
+	var user models.User
+	err := r.DB.Get(&user, `SELECT `+userColumns+` FROM users WHERE email = $1`, email)
+	return user, translate(err)
+}
+
+func (r *PostgresUsers) CreateWithCreator(user models.User, creator models.Creator) (models.User, error) {
+	// A transaction ensures that *both* tables are updated, or neither are.
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var created models.User
+	query := `INSERT INTO users (email, password_hash)
+	          VALUES ($1, $2)
+	          RETURNING ` + userColumns
+	if err := tx.Get(&created, query, user.Email, user.PasswordHash); err != nil {
+		return models.User{}, translate(err)
+	}
+
+	query = `INSERT INTO creators (user_id, username, display_name, widget_secret_token)
+	         VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(query, created.ID, creator.Username, creator.DisplayName, creator.WidgetSecretToken)
+	if err != nil {
+		return models.User{}, translate(err)
+	}
+
+	return created, tx.Commit()
+}
+
+func (r *PostgresUsers) MarkEmailVerified(id int, email string) error {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+	          WHERE id = $1 AND email = $2`
+	return affected(r.DB.Exec(query, id, email))
+}
+
+func (r *PostgresUsers) CountPasswordResetsSince(userID int, since time.Time) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM password_resets WHERE user_id = $1 AND created_at > $2`
+	err := r.DB.Get(&count, query, userID, since)
+	return count, err
+}
+
+func (r *PostgresUsers) CreatePasswordReset(reset models.PasswordReset) error {
+	query := `INSERT INTO password_resets (user_id, token_hash, expires_at)
+	          VALUES ($1, $2, $3)`
+	_, err := r.DB.Exec(query, reset.UserID, reset.TokenHash, reset.ExpiresAt)
+	return err
+}
+
+func (r *PostgresUsers) RedeemPasswordReset(tokenHash, passwordHash string, now time.Time) (int, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the token so it cannot be redeemed twice concurrently
+	var reset models.PasswordReset
+	query := `SELECT id, user_id, expires_at, used_at FROM password_resets
+	          WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.Get(&reset, query, tokenHash); err != nil {
+		return 0, translate(err)
+	}
+	if reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+		return 0, ErrNotFound
+	}
+
+	query = `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`
+	if _, err := tx.Exec(query, passwordHash, reset.UserID); err != nil {
+		return 0, err
+	}
+
+	// Spend this token and any other outstanding ones
+	query = `UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.Exec(query, reset.UserID); err != nil {
+		return 0, err
+	}
+
+	if err := revokeAll(tx, reset.UserID, ""); err != nil {
+		return 0, err
+	}
+
+	return reset.UserID, tx.Commit()
+}
+
+func (r *PostgresUsers) SetTOTPSecret(id int, secret string) error {
+	query := `UPDATE users SET totp_secret = $1, totp_last_step = NULL, updated_at = NOW()
+	          WHERE id = $2 AND totp_enabled_at IS NULL`
+	_, err := r.DB.Exec(query, secret, id)
+	return err
+}
+
+func (r *PostgresUsers) EnableTwoFactor(id int, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1, updated_at = NOW()
+	          WHERE id = $2 AND totp_enabled_at IS NULL`
+	if _, err := tx.Exec(query, step, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.Exec(query, id, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresUsers) DisableTwoFactor(id int) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users
+	          SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+	              totp_failed_attempts = 0, totp_locked_until = NULL, updated_at = NOW()
+	          WHERE id = $1`
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresUsers) ClaimTOTPStep(id int, step int64) (bool, error) {
+	// Claiming the step atomically stops the same code from being replayed
+	query := `UPDATE users
+	          SET totp_last_step = $1, totp_failed_attempts = 0, totp_locked_until = NULL
+	          WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`
+	res, err := r.DB.Exec(query, step, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *PostgresUsers) UseRecoveryCode(id int, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = NOW()
+	          WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	res, err := r.DB.Exec(query, id, codeHash)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	_, err = r.DB.Exec(`UPDATE users SET totp_failed_attempts = 0, totp_locked_until = NULL WHERE id = $1`, id)
+	return err == nil, err
+}
+
+func (r *PostgresUsers) RecordTwoFactorFailure(id int, maxAttempts int, lockout time.Duration) error {
+	query := `UPDATE users
+	          SET totp_locked_until = CASE WHEN totp_failed_attempts + 1 >= $2
+	                                       THEN NOW() + make_interval(secs => $3) ELSE totp_locked_until END,
+	              totp_failed_attempts = CASE WHEN totp_failed_attempts + 1 >= $2
+	                                          THEN 0 ELSE totp_failed_attempts + 1 END
+	          WHERE id = $1`
+	_, err := r.DB.Exec(query, id, maxAttempts, lockout.Seconds())
+	return err
+}
+
+func (r *PostgresUsers) ChangePassword(id int, passwordHash, exceptSessionID string) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`
+	if err := affected(tx.Exec(query, passwordHash, id)); err != nil {
+		return err
+	}
+	if err := revokeAll(tx, id, exceptSessionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresUsers) ChangeEmail(id int, email string) error {
+	query := `UPDATE users SET email = $1, email_verified_at = NULL, updated_at = NOW() WHERE id = $2`
+	return affected(r.DB.Exec(query, email, id))
+}
+
+func (r *PostgresUsers) ScheduleDeletion(id int) (models.User, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the creator row, as payout requests do, so none slips in
+	// between the check and the update.
+	var owed bool
+	query := `SELECT ` + moneyOwed("c.user_id") + ` FROM creators c WHERE c.user_id = $1 FOR UPDATE`
+	if err := tx.Get(&owed, query, id); err != nil {
+		return models.User{}, translate(err)
+	}
+	if owed {
+		return models.User{}, ErrMoneyOwed
+	}
+
+	var user models.User
+	query = `UPDATE users SET deleted_at = NOW(), updated_at = NOW()
+	          WHERE id = $1 AND deleted_at IS NULL
+	          RETURNING ` + userColumns
+	if err := tx.Get(&user, query, id); err != nil {
+		return models.User{}, translate(err)
+	}
+	if err := revokeAll(tx, id, ""); err != nil {
+		return models.User{}, err
+	}
+
+	return user, tx.Commit()
+}
+
+func (r *PostgresUsers) CancelDeletion(id int) error {
+	query := `UPDATE users SET deleted_at = NULL, updated_at = NOW()
+	          WHERE id = $1 AND deleted_at IS NOT NULL AND purged_at IS NULL`
+	return affected(r.DB.Exec(query, id))
+}
+
+func (r *PostgresUsers) PurgeDeleted(before time.Time) (int, error) {
+	var ids []int
+	query := `SELECT id FROM users WHERE deleted_at < $1 AND purged_at IS NULL ORDER BY id`
+	if err := r.DB.Select(&ids, query, before); err != nil {
+		return 0, err
+	}
+
+	// Each user gets their own transaction, so one that fails does not
+	// hold back the rest.
+	purged := 0
+	var errs []error
+	for _, id := range ids {
+		ok, err := r.purge(id, before)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", id, err))
+		} else if ok {
+			purged++
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// purge scrubs a deleted user's personal data. It reports false if the
+// user was restored or purged meanwhile, or is still owed money.
+func (r *PostgresUsers) purge(id int, before time.Time) (bool, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The placeholder email and username free the real ones for reuse. They
+	// contain a ':', which neither an email nor a username may, so they
+	// cannot collide with an account. Donations, the ledger and payouts are
+	// kept for the books. Users whose creator is still owed money, say from
+	// a donation that settled after they left, wait until support has paid
+	// it out.
+	query := `UPDATE users
+	          SET email = 'deleted:' || id, password_hash = '', email_verified_at = NULL,
+	              totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+	              totp_failed_attempts = 0, totp_locked_until = NULL,
+	              purged_at = NOW(), updated_at = NOW()
+	          WHERE id = $1 AND deleted_at < $2 AND purged_at IS NULL AND NOT ` + moneyOwed("users.id")
+	if err := affected(tx.Exec(query, id, before)); errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	query = `UPDATE creators
+	         SET username = 'deleted:' || user_id, display_name = 'Deleted creator',
+	             widget_secret_token = replace(gen_random_uuid()::text, '-', ''),
+	             bio = '', avatar_url = NULL, social_links = '[]', accent_color = NULL,
+	             goal_title = NULL, goal_amount = NULL, goal_currency = NULL, goal_started_at = NULL,
+	             updated_at = NOW()
+	         WHERE user_id = $1`
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, err
+	}
+	// Bank accounts that paid payouts must stay for the payouts' sake, so
+	// they keep only the last four digits.
+	query = `DELETE FROM bank_accounts b USING creators c
+	         WHERE b.creator_id = c.id AND c.user_id = $1
+	           AND NOT EXISTS (SELECT 1 FROM payouts p WHERE p.bank_account_id = b.id)`
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, err
+	}
+	query = `UPDATE bank_accounts b
+	         SET account_number = repeat('*', greatest(length(account_number) - 4, 0)) || right(account_number, 4),
+	             account_holder_name = 'Deleted creator'
+	         FROM creators c
+	         WHERE b.creator_id = c.id AND c.user_id = $1`
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, err
+	}
+	for _, table := range []string{"sessions", "password_resets", "recovery_codes"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, id); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// moneyOwed is an SQL condition that holds while the platform owes the
+// creator of the user whose ID is in userID: a positive balance in any
+// currency, or a payout that is neither paid nor failed.
+func moneyOwed(userID string) string {
+	return `(EXISTS (SELECT 1 FROM payouts p INNER JOIN creators oc ON oc.id = p.creator_id
+	                 WHERE oc.user_id = ` + userID + ` AND p.status IN ('requested', 'approved'))
+	        OR EXISTS (SELECT 1 FROM creators oc
+	                   INNER JOIN ledger_entries e ON e.account = 'creator:' || oc.id
+	                   INNER JOIN ledger_transactions t ON t.id = e.transaction_id
+	                   WHERE oc.user_id = ` + userID + `
+	                   GROUP BY t.currency HAVING SUM(e.amount) > 0))`
+}
@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 Appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestRFC6238Vectors checks the SHA1 test vectors of RFC 6238 Appendix B,
+// which are 8 digits long, and the 6 digit codes CodeAt derives from them.
+func TestRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		if got := hotp([]byte("12345678901234567890"), step, 8); got != tt.code {
+			t.Errorf("T=%d: hotp = %s, want %s", tt.unix, got, tt.code)
+		}
+
+		got, err := CodeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("CodeAt: %v", err)
+		}
+		if want := tt.code[2:]; got != want {
+			t.Errorf("T=%d: CodeAt = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestVerifyWindow(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps early", -2, false},
+		{"one step early", -1, true},
+		{"current step", 0, true},
+		{"one step late", 1, true},
+		{"two steps late", 2, false},
+	}
+	for _, tt := range tests {
+		code, _ := CodeAt(rfcSecret, step+tt.offset)
+		matched, ok := Verify(rfcSecret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && matched != step+tt.offset {
+			t.Errorf("%s: matched step %d, want %d", tt.name, matched, step+tt.offset)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := CodeAt(rfcSecret, Step(now))
+
+	if _, ok := Verify(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("code with a space was rejected")
+	}
+
+	for _, bad := range []string{
+		"",
+		code[:5],
+		code + "0",
+		"94287082",
+		"12345a",
+		"+12345",
+		"-12345",
+	} {
+		if _, ok := Verify(rfcSecret, bad, now); ok {
+			t.Errorf("Verify accepted %q", bad)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != secretBytes {
+		t.Errorf("secret is %d bytes, want %d", len(key), secretBytes)
+	}
+}
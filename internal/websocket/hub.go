@@ -3,6 +3,7 @@ package websocket
 import (
 	"encoding/json"
 	"log"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
@@ -14,30 +15,58 @@ type Client struct {
 	CreatorID int
 }
 
+// DonationAlert is the payload shown by the overlay. ID refers to the queued
+// alert row and is sent as the ID of the surrounding alert Message.
 type DonationAlert struct {
-	TargetCreatorID   int    `json:"-"`
-	DonorName         string `json:"donor_name"`
-	AmountCents       int    `json:"amount_cents"`
-	DonorMessage      string `json:"donor_message"`
-	MediaType         string `json:"media_type"`
-	MediaURL          string `json:"media_url"`
-	MediaStartSeconds int    `json:"media_start_seconds"`
-	MediaEndSeconds   int    `json:"media_end_seconds"`
+	ID                int    `db:"alert_id" json:"-"`
+	TargetCreatorID   int    `db:"-" json:"-"`
+	DonorName         string `db:"donor_name" json:"donor_name"`
+	Amount            int64  `db:"amount" json:"amount"`
+	Currency          string `db:"currency" json:"currency"`
+	DonorMessage      string `db:"donor_message" json:"donor_message"`
+	MediaType         string `db:"media_type" json:"media_type"`
+	MediaURL          string `db:"media_url" json:"media_url"`
+	MediaStartSeconds int    `db:"media_start_seconds" json:"media_start_seconds"`
+	MediaEndSeconds   int    `db:"media_end_seconds" json:"media_end_seconds"`
 }
 
+// Delivery is a message addressed to a single connection instead of every
+// overlay of a creator, such as alerts replayed after a reconnect.
+type Delivery struct {
+	Client *Client
+	Data   []byte
+}
+
+// Hub keeps every open overlay connection grouped by creator, so a creator
+// can have the alert widget open in OBS and in a browser preview at once.
 type Hub struct {
-	Clients        map[int]*Client
+	Clients        map[int]map[*Client]bool
 	Register       chan *Client
 	Unregister     chan *Client
 	BroadcastAlert chan DonationAlert
+	Deliver        chan Delivery
+	// Disconnect closes every connection of the given creator, e.g. after
+	// their widget token was rotated.
+	Disconnect chan int
+
+	connected atomic.Int64
+	reaped    atomic.Int64
+}
+
+// Stats is a snapshot of the hub's connection counters.
+type Stats struct {
+	ConnectedClients int64 `json:"connected_clients"`
+	ReapedClients    int64 `json:"reaped_clients"`
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		Clients:        make(map[int]*Client),
+		Clients:        make(map[int]map[*Client]bool),
 		Register:       make(chan *Client),
 		Unregister:     make(chan *Client),
 		BroadcastAlert: make(chan DonationAlert),
+		Deliver:        make(chan Delivery),
+		Disconnect:     make(chan int),
 	}
 }
 
@@ -45,33 +74,90 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
-			h.Clients[client.CreatorID] = client
-			log.Printf("WebSocket Client registered for creator %d", client.CreatorID)
+			if h.Clients[client.CreatorID] == nil {
+				h.Clients[client.CreatorID] = make(map[*Client]bool)
+			}
+			h.Clients[client.CreatorID][client] = true
+			h.connected.Add(1)
+			log.Printf("WebSocket Client registered for creator %d (%d connected)", client.CreatorID, len(h.Clients[client.CreatorID]))
 
 		case client := <-h.Unregister:
-			if _, ok := h.Clients[client.CreatorID]; ok {
-				delete(h.Clients, client.CreatorID)
-				close(client.Send)
+			if _, ok := h.Clients[client.CreatorID][client]; ok {
+				h.removeClient(client)
 				log.Printf("WebSocket Client unregistered for creator %d", client.CreatorID)
 			}
 
 		case alert := <-h.BroadcastAlert:
-			if client, ok := h.Clients[alert.TargetCreatorID]; ok {
-				jsonData, err := json.Marshal(alert)
+			clients, ok := h.Clients[alert.TargetCreatorID]
+			if !ok {
+				continue
+			}
 
-				if err != nil {
-					log.Println("Failed to marshal donation alert:", err)
-					continue
-				}
+			jsonData, err := json.Marshal(NewAlertMessage(alert))
+			if err != nil {
+				log.Println("Failed to marshal donation alert:", err)
+				continue
+			}
 
+			for client := range clients {
 				select {
 				case client.Send <- jsonData:
-					log.Printf("Sent alert to creator %d", client.CreatorID)
 				default:
-					close(client.Send)
-					delete(h.Clients, client.CreatorID)
+					// The client's buffer is full, so it is too slow or gone.
+					h.removeClient(client)
 				}
 			}
+			log.Printf("Sent alert to %d client(s) of creator %d", len(clients), alert.TargetCreatorID)
+
+		case creatorID := <-h.Disconnect:
+			clients := h.Clients[creatorID]
+			count := len(clients)
+			for client := range clients {
+				h.removeClient(client)
+			}
+			if count > 0 {
+				log.Printf("Disconnected %d client(s) of creator %d", count, creatorID)
+			}
+
+		case delivery := <-h.Deliver:
+			// The client may have disconnected since the delivery was queued.
+			if _, ok := h.Clients[delivery.Client.CreatorID][delivery.Client]; !ok {
+				continue
+			}
+
+			select {
+			case delivery.Client.Send <- delivery.Data:
+			default:
+				h.removeClient(delivery.Client)
+			}
 		}
 	}
 }
+
+// removeClient closes the client's send channel and drops it from the
+// creator's set, deleting the set once the last connection is gone.
+func (h *Hub) removeClient(client *Client) {
+	clients := h.Clients[client.CreatorID]
+	delete(clients, client)
+	close(client.Send)
+	h.connected.Add(-1)
+
+	if len(clients) == 0 {
+		delete(h.Clients, client.CreatorID)
+	}
+}
+
+// RecordReaped counts a client that was dropped because it stopped
+// answering pings or could not be written to in time.
+func (h *Hub) RecordReaped(client *Client) {
+	total := h.reaped.Add(1)
+	log.Printf("Reaped dead WebSocket client for creator %d (%d reaped so far)", client.CreatorID, total)
+}
+
+// Stats is safe to call from any goroutine.
+func (h *Hub) Stats() Stats {
+	return Stats{
+		ConnectedClients: h.connected.Load(),
+		ReapedClients:    h.reaped.Load(),
+	}
+}
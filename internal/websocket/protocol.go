@@ -0,0 +1,44 @@
+package websocket
+
+import "encoding/json"
+
+// Message types exchanged with the alert overlay.
+const (
+	// Server to overlay
+	TypeAlert = "alert"
+	TypePong  = "pong"
+	TypeError = "error"
+
+	// Overlay to server
+	TypeAck           = "ack"            // the alert finished playing on stream
+	TypeSkip          = "skip"           // the alert was dismissed without playing
+	TypeReplayRequest = "replay-request" // resend the oldest 50 unacknowledged alerts
+	TypePing          = "ping"
+)
+
+// Message is the envelope for every frame on the widget socket. For alerts
+// the ID is the queued alert's ID, which the overlay echoes in ack and skip.
+// A ping's ID is echoed back in the matching pong.
+type Message struct {
+	Type  string         `json:"type"`
+	ID    int            `json:"id,omitempty"`
+	Alert *DonationAlert `json:"alert,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+func NewAlertMessage(alert DonationAlert) Message {
+	return Message{Type: TypeAlert, ID: alert.ID, Alert: &alert}
+}
+
+// AlertID returns the queued alert ID of an encoded message, or false if
+// the message is not an alert.
+func AlertID(data []byte) (int, bool) {
+	var msg struct {
+		Type string `json:"type"`
+		ID   int    `json:"id"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != TypeAlert {
+		return 0, false
+	}
+	return msg.ID, true
+}
@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// PumpConfig tunes the heartbeat and limits applied to every overlay
+// connection. PingInterval must be shorter than PongWait, otherwise healthy
+// clients would time out between two pings.
+type PumpConfig struct {
+	PingInterval   time.Duration
+	PongWait       time.Duration
+	WriteTimeout   time.Duration
+	MaxMessageSize int64
+}
+
+func DefaultPumpConfig() PumpConfig {
+	return PumpConfig{
+		PingInterval:   54 * time.Second,
+		PongWait:       60 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxMessageSize: 4096,
+	}
+}
+
+// Validate rejects settings that would disconnect healthy clients.
+func (c PumpConfig) Validate() error {
+	if c.PingInterval <= 0 || c.PongWait <= 0 || c.WriteTimeout <= 0 || c.MaxMessageSize <= 0 {
+		return errors.New("websocket pump settings must all be positive")
+	}
+	if c.PingInterval >= c.PongWait {
+		return errors.New("websocket ping interval must be shorter than pong wait")
+	}
+	return nil
+}
+
+// IsTimeout reports whether err came from an expired read or write deadline,
+// meaning the peer stopped responding rather than closing the connection.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}